@@ -0,0 +1,110 @@
+// Package cache holds hot read results in front of the store. Entries live
+// in a pluggable Cache; LRU keeps them in process, which is enough for a
+// single backend instance.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache maps string keys to values. Implementations must be safe for
+// concurrent use and must not modify stored values.
+type Cache interface {
+	// Get returns the value stored under key, if it is present and fresh.
+	Get(key string) (any, bool)
+	// Set stores value under key, replacing any previous value.
+	Set(key string, value any)
+	// Delete removes the given keys. Missing keys are ignored.
+	Delete(keys ...string)
+}
+
+// LRU is an in-memory Cache holding at most a fixed number of entries, each
+// for at most a fixed TTL. When full, the least recently used entry is
+// evicted.
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+	// now is overridable for tests.
+	now func() time.Time
+}
+
+type entry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// NewLRU returns an LRU that holds up to size entries for ttl each. A zero
+// ttl keeps entries until they are evicted or deleted.
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+func (c *LRU) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if c.ttl > 0 && !c.now().Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *LRU) Set(key string, value any) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRU) Delete(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// Len returns the number of entries held, including expired ones that have
+// not been looked up since.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
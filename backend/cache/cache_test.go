@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLRU(size int, ttl time.Duration) (*LRU, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewLRU(size, ttl)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestLRU_GetSet(t *testing.T) {
+	c, _ := newTestLRU(2, time.Minute)
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected miss on empty cache")
+	}
+	c.Set("a", 1)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("got %v, %v, want 1, true", v, ok)
+	}
+	c.Set("a", 2)
+	if v, _ := c.Get("a"); v != 2 {
+		t.Errorf("got %v after overwrite, want 2", v)
+	}
+}
+
+func TestLRU_EvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := newTestLRU(2, time.Minute)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("expected %s to be kept", key)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("got %d entries, want 2", c.Len())
+	}
+}
+
+func TestLRU_Expires(t *testing.T) {
+	c, now := newTestLRU(2, time.Minute)
+	c.Set("a", 1)
+
+	*now = now.Add(59 * time.Second)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected entry before its TTL")
+	}
+	*now = now.Add(time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected entry to expire after its TTL")
+	}
+	if c.Len() != 0 {
+		t.Errorf("got %d entries, want expired entry removed", c.Len())
+	}
+}
+
+func TestLRU_Delete(t *testing.T) {
+	c, _ := newTestLRU(3, 0)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Delete("a", "b", "missing")
+
+	if c.Len() != 0 {
+		t.Errorf("got %d entries, want 0", c.Len())
+	}
+}
+
+func TestLRU_ZeroSizeStoresNothing(t *testing.T) {
+	c, _ := newTestLRU(0, time.Minute)
+	c.Set("a", 1)
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected zero-size cache to store nothing")
+	}
+}
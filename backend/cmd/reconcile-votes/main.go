@@ -0,0 +1,49 @@
+// Command reconcile-votes recomputes every post's denormalized vote columns
+// from the votes table and reports any posts whose stored score has drifted.
+//
+// By default it only reports; pass -fix to correct the drifted rows.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/tonitran/dischord/store"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "correct drifted posts instead of only reporting them")
+	flag.Parse()
+
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		connStr = "postgres://localhost/dischord?sslmode=disable"
+	}
+	s, err := store.Open(connStr)
+	if err != nil {
+		log.Fatal("failed to connect to database: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	drifts, err := s.ReconcileVoteScores(ctx, *fix)
+	if err != nil {
+		log.Fatal("reconcile failed: ", err)
+	}
+	for _, d := range drifts {
+		log.Printf("post %s: score %d -> %d, upvotes %d -> %d, downvotes %d -> %d",
+			d.PostID, d.Score, d.WantScore, d.Upvotes, d.WantUpvotes, d.Downvotes, d.WantDownvotes)
+	}
+	switch {
+	case len(drifts) == 0:
+		log.Println("no drift found")
+	case *fix:
+		log.Printf("corrected %d drifted post(s)", len(drifts))
+	default:
+		log.Printf("found %d drifted post(s); rerun with -fix to correct them", len(drifts))
+		os.Exit(1)
+	}
+}
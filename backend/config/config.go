@@ -0,0 +1,403 @@
+// Package config loads the settings of the backend binary. Each setting has
+// a default, which can be overridden by an optional JSON config file, then
+// by an environment variable, then by a command-line flag.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tonitran/dischord/logging"
+	"github.com/tonitran/dischord/ratelimit"
+)
+
+// Config is the complete backend configuration.
+type Config struct {
+	// Addr is the address the HTTP server listens on.
+	Addr     string         `json:"addr"`
+	TLS      TLSConfig      `json:"tls"`
+	HTTP     HTTPConfig     `json:"http"`
+	Database DatabaseConfig `json:"database"`
+	Cache    CacheConfig    `json:"cache"`
+	Posts    PostsConfig    `json:"posts"`
+	Log      logging.Config `json:"log"`
+	// CORSOrigins lists the browser origins allowed to call the API, or
+	// "*" for any. Empty disables CORS headers.
+	CORSOrigins []string `json:"cors_origins"`
+	// MailDir, if set, writes verification emails to files in this
+	// directory instead of logging them.
+	MailDir string       `json:"mail_dir"`
+	Limits  LimitsConfig `json:"limits"`
+}
+
+// TLSConfig enables HTTPS when both files are set.
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// Enabled reports whether the server should serve HTTPS.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// HTTPConfig holds the HTTP server timeouts. DrainDelay is how long the
+// server keeps serving after failing readiness on shutdown, so load
+// balancers can stop routing to it before connections are closed.
+type HTTPConfig struct {
+	ReadTimeout     Duration `json:"read_timeout"`
+	WriteTimeout    Duration `json:"write_timeout"`
+	IdleTimeout     Duration `json:"idle_timeout"`
+	DrainDelay      Duration `json:"drain_delay"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+// DatabaseConfig holds the Postgres connection strings and pool sizing.
+// Zero pool values keep the database/sql defaults.
+type DatabaseConfig struct {
+	URL string `json:"url"`
+	// ReplicaURLs are read replicas of URL that serve read-only queries.
+	ReplicaURLs []string `json:"replica_urls"`
+	// ReadYourWrites sends a user's reads to the primary for this long
+	// after they write; 0 lets replicas serve them straight away.
+	ReadYourWrites  Duration `json:"read_your_writes"`
+	MaxOpenConns    int      `json:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+	// QueryTimeout bounds each store call; 0 means no timeout.
+	QueryTimeout Duration `json:"query_timeout"`
+}
+
+// CacheConfig sizes the in-memory cache of posts and servers.
+type CacheConfig struct {
+	// Size is the maximum number of cached entries; 0 disables the cache.
+	Size int `json:"size"`
+	// TTL bounds how long an entry is served, and so how stale a read can
+	// be when another instance writes; 0 keeps entries until evicted.
+	TTL Duration `json:"ttl"`
+}
+
+// PostsConfig controls how long deleted posts are kept.
+type PostsConfig struct {
+	// Retention is how long moderators can restore a deleted post.
+	Retention Duration `json:"retention"`
+	// PurgeInterval is how often posts past Retention are removed for
+	// good; 0 disables purging.
+	PurgeInterval Duration `json:"purge_interval"`
+}
+
+// LimitsConfig holds request limits.
+type LimitsConfig struct {
+	// MaxBodyBytes caps request body size; 0 means no limit.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// RateLimiting turns the per-route rate limits on or off.
+	RateLimiting bool `json:"rate_limiting"`
+	// RateLimits overrides the budget of individual route classes (chat,
+	// posting, voting, account). It can only be set in the config file.
+	RateLimits map[ratelimit.Class]RateLimit `json:"rate_limits"`
+	// TrustForwardedFor takes the client IP for rate limiting from
+	// X-Forwarded-For. Only enable behind a proxy that sets it.
+	TrustForwardedFor bool `json:"trust_forwarded_for"`
+}
+
+// RateLimit is a token bucket budget for one route class.
+type RateLimit struct {
+	Burst    int      `json:"burst"`
+	Interval Duration `json:"interval"`
+}
+
+// RateLimitTable returns the default limits with the configured overrides
+// applied.
+func (l LimitsConfig) RateLimitTable() map[ratelimit.Class]ratelimit.Limit {
+	limits := make(map[ratelimit.Class]ratelimit.Limit, len(ratelimit.DefaultLimits))
+	for class, limit := range ratelimit.DefaultLimits {
+		limits[class] = limit
+	}
+	for class, limit := range l.RateLimits {
+		limits[class] = ratelimit.Limit{Burst: limit.Burst, Interval: time.Duration(limit.Interval)}
+	}
+	return limits
+}
+
+// Duration is a time.Duration written as a string such as "30s" in the
+// config file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() Config {
+	return Config{
+		Addr: ":8080",
+		HTTP: HTTPConfig{
+			ReadTimeout:     Duration(15 * time.Second),
+			WriteTimeout:    Duration(30 * time.Second),
+			IdleTimeout:     Duration(2 * time.Minute),
+			DrainDelay:      Duration(5 * time.Second),
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+		Database: DatabaseConfig{
+			URL:             "postgres://localhost/dischord?sslmode=disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: Duration(30 * time.Minute),
+			QueryTimeout:    Duration(5 * time.Second),
+			ReadYourWrites:  Duration(5 * time.Second),
+		},
+		Cache: CacheConfig{Size: 10000, TTL: Duration(30 * time.Second)},
+		Posts: PostsConfig{Retention: Duration(30 * 24 * time.Hour), PurgeInterval: Duration(time.Hour)},
+		Log:   logging.Config{Level: "info", Format: "json", Output: "stdout"},
+		Limits: LimitsConfig{
+			MaxBodyBytes: 1 << 20,
+			RateLimiting: true,
+		},
+	}
+}
+
+// setting is a value that can be set from an environment variable or flag.
+type setting struct {
+	flag, env, usage string
+	set              func(c *Config, v string) error
+}
+
+func stringSetting(p func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*p(c) = v
+		return nil
+	}
+}
+
+func intSetting(p func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		*p(c) = n
+		return nil
+	}
+}
+
+func boolSetting(p func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", v)
+		}
+		*p(c) = b
+		return nil
+	}
+}
+
+func durationSetting(p func(*Config) *Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", v)
+		}
+		*p(c) = Duration(d)
+		return nil
+	}
+}
+
+var settings = []setting{
+	{"addr", "LISTEN_ADDR", "listen address", stringSetting(func(c *Config) *string { return &c.Addr })},
+	{"tls-cert", "TLS_CERT_FILE", "TLS certificate file; enables HTTPS with -tls-key", stringSetting(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"tls-key", "TLS_KEY_FILE", "TLS private key file", stringSetting(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"read-timeout", "HTTP_READ_TIMEOUT", "maximum time to read a request", durationSetting(func(c *Config) *Duration { return &c.HTTP.ReadTimeout })},
+	{"write-timeout", "HTTP_WRITE_TIMEOUT", "maximum time to write a response", durationSetting(func(c *Config) *Duration { return &c.HTTP.WriteTimeout })},
+	{"idle-timeout", "HTTP_IDLE_TIMEOUT", "how long to keep idle connections open", durationSetting(func(c *Config) *Duration { return &c.HTTP.IdleTimeout })},
+	{"drain-delay", "DRAIN_DELAY", "how long to keep serving after failing readiness on shutdown", durationSetting(func(c *Config) *Duration { return &c.HTTP.DrainDelay })},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long to wait for requests to finish on shutdown", durationSetting(func(c *Config) *Duration { return &c.HTTP.ShutdownTimeout })},
+	{"database-url", "DATABASE_URL", "Postgres connection string", stringSetting(func(c *Config) *string { return &c.Database.URL })},
+	{"database-replica-urls", "DATABASE_REPLICA_URLS", "comma-separated Postgres connection strings of read replicas", func(c *Config, v string) error {
+		c.Database.ReplicaURLs = splitList(v)
+		return nil
+	}},
+	{"db-read-your-writes", "DB_READ_YOUR_WRITES", "how long a user's reads go to the primary after they write", durationSetting(func(c *Config) *Duration { return &c.Database.ReadYourWrites })},
+	{"db-max-open-conns", "DB_MAX_OPEN_CONNS", "maximum open database connections", intSetting(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"db-max-idle-conns", "DB_MAX_IDLE_CONNS", "maximum idle database connections", intSetting(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"db-conn-max-lifetime", "DB_CONN_MAX_LIFETIME", "maximum lifetime of a database connection", durationSetting(func(c *Config) *Duration { return &c.Database.ConnMaxLifetime })},
+	{"db-query-timeout", "DB_QUERY_TIMEOUT", "maximum duration of each store call, 0 for none", durationSetting(func(c *Config) *Duration { return &c.Database.QueryTimeout })},
+	{"cache-size", "CACHE_SIZE", "maximum cached posts and servers, 0 to disable caching", intSetting(func(c *Config) *int { return &c.Cache.Size })},
+	{"cache-ttl", "CACHE_TTL", "how long a cached post or server is served", durationSetting(func(c *Config) *Duration { return &c.Cache.TTL })},
+	{"post-retention", "POST_RETENTION", "how long a deleted post can be restored", durationSetting(func(c *Config) *Duration { return &c.Posts.Retention })},
+	{"post-purge-interval", "POST_PURGE_INTERVAL", "how often expired deleted posts are purged, 0 to disable", durationSetting(func(c *Config) *Duration { return &c.Posts.PurgeInterval })},
+	{"log-level", "LOG_LEVEL", "log level: debug, info, warn or error", stringSetting(func(c *Config) *string { return &c.Log.Level })},
+	{"log-format", "LOG_FORMAT", "log format: json or text", stringSetting(func(c *Config) *string { return &c.Log.Format })},
+	{"log-output", "LOG_OUTPUT", "log destination: stdout, stderr or a file path", stringSetting(func(c *Config) *string { return &c.Log.Output })},
+	{"cors-origins", "CORS_ORIGINS", "comma-separated allowed browser origins, or *", func(c *Config, v string) error {
+		c.CORSOrigins = splitList(v)
+		return nil
+	}},
+	{"mail-dir", "MAIL_DIR", "directory to write verification emails to instead of logging them", stringSetting(func(c *Config) *string { return &c.MailDir })},
+	{"max-body-bytes", "MAX_BODY_BYTES", "maximum request body size in bytes, 0 for no limit", func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		c.Limits.MaxBodyBytes = n
+		return nil
+	}},
+	{"rate-limiting", "RATE_LIMITING", "enable per-route rate limits", boolSetting(func(c *Config) *bool { return &c.Limits.RateLimiting })},
+	{"trust-forwarded-for", "TRUST_FORWARDED_FOR", "rate limit by the X-Forwarded-For client IP", boolSetting(func(c *Config) *bool { return &c.Limits.TrustForwardedFor })},
+}
+
+// splitList splits a comma-separated setting, dropping blank items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ConfigFileEnv names the environment variable that points at the config
+// file when the -config flag is not given.
+const ConfigFileEnv = "DISCHORD_CONFIG"
+
+// Load builds the configuration from the defaults, the config file, the
+// environment (read through getenv) and the command-line args, in increasing
+// order of precedence, and validates the result.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	fs := flag.NewFlagSet("dischord", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	path := fs.String("config", getenv(ConfigFileEnv), "path to a JSON config file")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := Default()
+	if *path != "" {
+		if err := loadFile(&cfg, *path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var errs []error
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(&cfg, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				if err := s.set(&cfg, *values[s.flag]); err != nil {
+					errs = append(errs, fmt.Errorf("-%s: %w", s.flag, err))
+				}
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return Config{}, errors.Join(errs...)
+	}
+	return cfg, cfg.Validate()
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting in c.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Addr != "", "addr is required")
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls cert_file and key_file must be set together")
+	check(c.HTTP.ReadTimeout >= 0 && c.HTTP.WriteTimeout >= 0 && c.HTTP.IdleTimeout >= 0 && c.HTTP.ShutdownTimeout >= 0,
+		"http timeouts must not be negative")
+	check(c.HTTP.DrainDelay >= 0, "http drain_delay must not be negative")
+
+	check(c.Database.URL != "", "database url is required")
+	for _, u := range c.Database.ReplicaURLs {
+		check(u != c.Database.URL, "database replica_urls must not include the primary url")
+	}
+	check(c.Database.ReadYourWrites >= 0, "database read_your_writes must not be negative")
+	check(c.Database.MaxOpenConns >= 0, "database max_open_conns must not be negative")
+	check(c.Database.MaxIdleConns >= 0, "database max_idle_conns must not be negative")
+	check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns,
+		"database max_idle_conns must not exceed max_open_conns")
+	check(c.Database.ConnMaxLifetime >= 0, "database conn_max_lifetime must not be negative")
+	check(c.Database.QueryTimeout >= 0, "database query_timeout must not be negative")
+	check(c.Cache.Size >= 0, "cache size must not be negative")
+	check(c.Cache.TTL >= 0, "cache ttl must not be negative")
+	check(c.Posts.Retention > 0, "posts retention must be positive")
+	check(c.Posts.PurgeInterval >= 0, "posts purge_interval must not be negative")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log level %q must be debug, info, warn or error", c.Log.Level)
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log format %q must be json or text", c.Log.Format)
+
+	for _, o := range c.CORSOrigins {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && (u.Path == "" || u.Path == "/"),
+			"cors origin %q must be * or a scheme and host such as https://example.com", o)
+	}
+
+	check(c.Limits.MaxBodyBytes >= 0, "limits max_body_bytes must not be negative")
+	for class, limit := range c.Limits.RateLimits {
+		_, known := ratelimit.DefaultLimits[class]
+		check(known, "limits rate_limits: unknown class %q", class)
+		check(limit.Burst > 0 && limit.Interval > 0, "limits rate_limits %s: burst and interval must be positive", class)
+	}
+	return errors.Join(errs...)
+}
+
+// Usage writes the available flags and their environment variables to w.
+func Usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage of dischord:\n  -config string\n\tpath to a JSON config file (env %s)\n", ConfigFileEnv)
+	for _, s := range settings {
+		fmt.Fprintf(w, "  -%s\n\t%s (env %s)\n", s.flag, s.usage, s.env)
+	}
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tonitran/dischord/ratelimit"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(k string) string { return vars[k] }
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":8080" || cfg.Database.URL == "" || !cfg.Limits.RateLimiting {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dischord.json")
+	os.WriteFile(path, []byte(`{
+		"addr": ":9000",
+		"log": {"level": "debug"},
+		"database": {"max_open_conns": 50, "conn_max_lifetime": "1h"},
+		"limits": {"rate_limits": {"chat": {"burst": 20, "interval": "500ms"}}}
+	}`), 0644)
+
+	cfg, err := Load(
+		[]string{"-config", path, "-log-level", "error", "-database-replica-urls", "postgres://r1/db,,postgres://r2/db"},
+		env(map[string]string{"LOG_LEVEL": "warn", "LISTEN_ADDR": ":9001", "CORS_ORIGINS": "https://a.example, http://localhost:5173"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"flag beats env and file", cfg.Log.Level, "error"},
+		{"env beats file", cfg.Addr, ":9001"},
+		{"file beats default", cfg.Database.MaxOpenConns, 50},
+		{"file duration", time.Duration(cfg.Database.ConnMaxLifetime), time.Hour},
+		{"default kept", cfg.Log.Format, "json"},
+		{"list from env", strings.Join(cfg.CORSOrigins, " "), "https://a.example http://localhost:5173"},
+		{"list from flag", strings.Join(cfg.Database.ReplicaURLs, " "), "postgres://r1/db postgres://r2/db"},
+		{"rate limit override", cfg.Limits.RateLimitTable()[ratelimit.Chat], ratelimit.Limit{Burst: 20, Interval: 500 * time.Millisecond}},
+		{"rate limit default", cfg.Limits.RateLimitTable()[ratelimit.Voting], ratelimit.DefaultLimits[ratelimit.Voting]},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoad_ConfigFileFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dischord.json")
+	os.WriteFile(path, []byte(`{"mail_dir": "/tmp/mail"}`), 0644)
+	cfg, err := Load(nil, env(map[string]string{ConfigFileEnv: path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MailDir != "/tmp/mail" {
+		t.Errorf("got mail_dir %q, want /tmp/mail", cfg.MailDir)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	unknown := filepath.Join(dir, "unknown.json")
+	os.WriteFile(unknown, []byte(`{"adress": ":1"}`), 0644)
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "bad int", env: map[string]string{"DB_MAX_OPEN_CONNS": "lots"}, wantErr: "DB_MAX_OPEN_CONNS"},
+		{name: "bad duration flag", args: []string{"-read-timeout", "soon"}, wantErr: "-read-timeout"},
+		{name: "unknown flag", args: []string{"-nope"}, wantErr: "nope"},
+		{name: "missing file", args: []string{"-config", filepath.Join(dir, "missing.json")}, wantErr: "config file"},
+		{name: "unknown file field", args: []string{"-config", unknown}, wantErr: "adress"},
+		{name: "half TLS", env: map[string]string{"TLS_CERT_FILE": "cert.pem"}, wantErr: "tls"},
+		{name: "idle above open", env: map[string]string{"DB_MAX_OPEN_CONNS": "5", "DB_MAX_IDLE_CONNS": "10"}, wantErr: "max_idle_conns"},
+		{name: "primary as replica", env: map[string]string{"DATABASE_URL": "postgres://db/x", "DATABASE_REPLICA_URLS": "postgres://db/x"}, wantErr: "replica_urls"},
+		{name: "negative drain delay", env: map[string]string{"DRAIN_DELAY": "-1s"}, wantErr: "drain_delay"},
+		{name: "negative cache size", args: []string{"-cache-size", "-1"}, wantErr: "cache size"},
+		{name: "zero post retention", env: map[string]string{"POST_RETENTION": "0s"}, wantErr: "posts retention"},
+		{name: "bad log level", env: map[string]string{"LOG_LEVEL": "loud"}, wantErr: "log level"},
+		{name: "bad CORS origin", env: map[string]string{"CORS_ORIGINS": "example.com"}, wantErr: "cors origin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.args, env(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_RateLimits(t *testing.T) {
+	cfg := Default()
+	cfg.Limits.RateLimits = map[ratelimit.Class]RateLimit{
+		"uploads":      {Burst: 1, Interval: Duration(time.Second)},
+		ratelimit.Chat: {Burst: 0, Interval: Duration(time.Second)},
+	}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), `unknown class "uploads"`) || !strings.Contains(err.Error(), "chat: burst") {
+		t.Errorf("got error %v, want both rate limit problems reported", err)
+	}
+}
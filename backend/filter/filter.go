@@ -0,0 +1,240 @@
+// Package filter implements per-server auto-moderation for posts and
+// messages: word and regex blocklists, a link allowlist, a length cap and a
+// mention-spam limit, each with a configurable action.
+package filter
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Action is what happens to content that trips a rule.
+type Action string
+
+const (
+	// Allow stores the content unchanged.
+	Allow Action = ""
+	// Mask replaces the offending text and stores the rest.
+	Mask Action = "mask"
+	// Flag stores the content and queues it for moderator review.
+	Flag Action = "flag"
+	// Reject refuses to store the content.
+	Reject Action = "reject"
+)
+
+// severity orders actions so the strongest one wins.
+var severity = map[Action]int{Allow: 0, Mask: 1, Flag: 2, Reject: 3}
+
+// Rules is a server's filter configuration as stored and edited by
+// moderators. Zero values disable the corresponding check.
+type Rules struct {
+	BlockedWords    []string `json:"blocked_words"`
+	BlockedPatterns []string `json:"blocked_patterns"`
+	BlocklistAction Action   `json:"blocklist_action"`
+
+	// AllowedLinkDomains restricts links to these domains and their
+	// subdomains. Empty allows every link.
+	AllowedLinkDomains []string `json:"allowed_link_domains"`
+	LinkAction         Action   `json:"link_action"`
+
+	// MaxLength is the maximum number of characters; longer content is
+	// always rejected.
+	MaxLength int `json:"max_length"`
+
+	MaxMentions   int    `json:"max_mentions"`
+	MentionAction Action `json:"mention_action"`
+}
+
+// WithDefaults returns a copy of r in which any configured check without an
+// action rejects matching content.
+func (r Rules) WithDefaults() Rules {
+	if r.BlocklistAction == Allow && (len(r.BlockedWords) > 0 || len(r.BlockedPatterns) > 0) {
+		r.BlocklistAction = Reject
+	}
+	if r.LinkAction == Allow && len(r.AllowedLinkDomains) > 0 {
+		r.LinkAction = Reject
+	}
+	if r.MentionAction == Allow && r.MaxMentions > 0 {
+		r.MentionAction = Reject
+	}
+	return r
+}
+
+// Result is the outcome of running content through a Filter.
+type Result struct {
+	Action Action
+	// Text is the content to store, with any masking applied.
+	Text string
+	// Reasons lists the rules that matched.
+	Reasons []string
+}
+
+func (r *Result) escalate(a Action, reason string) {
+	if severity[a] > severity[r.Action] {
+		r.Action = a
+	}
+	r.Reasons = append(r.Reasons, reason)
+}
+
+// Merge combines the results for several fields of the same item.
+func Merge(results ...Result) Result {
+	var merged Result
+	for _, r := range results {
+		if severity[r.Action] > severity[merged.Action] {
+			merged.Action = r.Action
+		}
+		merged.Reasons = append(merged.Reasons, r.Reasons...)
+	}
+	return merged
+}
+
+// Filter is a compiled set of Rules.
+type Filter struct {
+	rules   Rules
+	blocked []*regexp.Regexp
+	allowed []string
+}
+
+var (
+	linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+	// mentionPattern ends names at a letter, digit or underscore, so that
+	// sentence punctuation after a mention isn't taken as part of the name.
+	mentionPattern = regexp.MustCompile(`(?:^|\s)@[A-Za-z0-9_.-]*[A-Za-z0-9_]`)
+)
+
+// Compile validates rules and prepares them for matching.
+func Compile(rules Rules) (*Filter, error) {
+	for name, a := range map[string]Action{
+		"blocklist_action": rules.BlocklistAction,
+		"link_action":      rules.LinkAction,
+		"mention_action":   rules.MentionAction,
+	} {
+		if _, ok := severity[a]; !ok {
+			return nil, fmt.Errorf("%s must be one of mask, flag or reject", name)
+		}
+	}
+	if rules.MentionAction == Mask {
+		return nil, fmt.Errorf("mention_action must be flag or reject")
+	}
+	if rules.MaxLength < 0 || rules.MaxMentions < 0 {
+		return nil, fmt.Errorf("max_length and max_mentions must not be negative")
+	}
+
+	f := &Filter{rules: rules}
+	for _, word := range rules.BlockedWords {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		f.blocked = append(f.blocked, wordPattern(word))
+	}
+	for _, pattern := range rules.BlockedPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocked pattern %q: %v", pattern, err)
+		}
+		f.blocked = append(f.blocked, re)
+	}
+	for _, domain := range rules.AllowedLinkDomains {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			f.allowed = append(f.allowed, strings.TrimPrefix(domain, "."))
+		}
+	}
+	return f, nil
+}
+
+// wordPattern matches word case-insensitively as a whole word. \b only
+// holds next to a word character, so a word that starts or ends with
+// punctuation, like "c++" or "@everyone", is only anchored on its other side.
+func wordPattern(word string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(word)
+	if isWordByte(word[0]) {
+		pattern = `\b` + pattern
+	}
+	if isWordByte(word[len(word)-1]) {
+		pattern += `\b`
+	}
+	return regexp.MustCompile(`(?i)` + pattern)
+}
+
+// isWordByte reports whether b is an ASCII word character as \b sees it.
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// Apply runs text through every rule.
+func (f *Filter) Apply(text string) Result {
+	res := Result{Text: text}
+	if f == nil {
+		return res
+	}
+
+	if f.rules.MaxLength > 0 && utf8.RuneCountInString(text) > f.rules.MaxLength {
+		res.escalate(Reject, fmt.Sprintf("longer than %d characters", f.rules.MaxLength))
+	}
+
+	if f.rules.BlocklistAction != Allow {
+		for _, re := range f.blocked {
+			if !re.MatchString(res.Text) {
+				continue
+			}
+			res.escalate(f.rules.BlocklistAction, "matches blocklist entry "+re.String())
+			if f.rules.BlocklistAction == Mask {
+				res.Text = re.ReplaceAllStringFunc(res.Text, func(m string) string {
+					return strings.Repeat("*", utf8.RuneCountInString(m))
+				})
+			}
+		}
+	}
+
+	if len(f.allowed) > 0 && f.rules.LinkAction != Allow {
+		res.Text = linkPattern.ReplaceAllStringFunc(res.Text, func(link string) string {
+			if f.linkAllowed(link) {
+				return link
+			}
+			res.escalate(f.rules.LinkAction, "links to a domain that is not allowed")
+			if f.rules.LinkAction == Mask {
+				return "[link removed]"
+			}
+			return link
+		})
+	}
+
+	if f.rules.MaxMentions > 0 && f.rules.MentionAction != Allow {
+		if n := len(mentionPattern.FindAllString(text, -1)); n > f.rules.MaxMentions {
+			res.escalate(f.rules.MentionAction, fmt.Sprintf("%d mentions exceeds the limit of %d", n, f.rules.MaxMentions))
+		}
+	}
+	return res
+}
+
+// Mentions returns the usernames @mentioned in text, lowercased and without
+// repeats.
+func Mentions(text string) []string {
+	var names []string
+	for _, m := range mentionPattern.FindAllString(text, -1) {
+		name := strings.ToLower(m[strings.IndexByte(m, '@')+1:])
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (f *Filter) linkAllowed(link string) bool {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return slices.ContainsFunc(f.allowed, func(domain string) bool {
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	})
+}
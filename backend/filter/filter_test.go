@@ -0,0 +1,134 @@
+package filter
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFilter_Apply(t *testing.T) {
+	tests := []struct {
+		name       string
+		rules      Rules
+		text       string
+		wantAction Action
+		wantText   string
+	}{
+		{
+			name:       "no rules",
+			text:       "hello world",
+			wantAction: Allow,
+			wantText:   "hello world",
+		},
+		{
+			name:       "masked word",
+			rules:      Rules{BlockedWords: []string{"darn"}, BlocklistAction: Mask},
+			text:       "well Darn it, darned",
+			wantAction: Mask,
+			wantText:   "well **** it, darned",
+		},
+		{
+			name:       "masked words with punctuation",
+			rules:      Rules{BlockedWords: []string{"c++", "@everyone"}, BlocklistAction: Mask},
+			text:       "ping @everyone about C++ or abc++",
+			wantAction: Mask,
+			wantText:   "ping ********* about *** or abc++",
+		},
+		{
+			name:       "rejected pattern",
+			rules:      Rules{BlockedPatterns: []string{`\d{3}-\d{4}`}, BlocklistAction: Reject},
+			text:       "call 555-1234",
+			wantAction: Reject,
+			wantText:   "call 555-1234",
+		},
+		{
+			name:       "allowed link",
+			rules:      Rules{AllowedLinkDomains: []string{"example.com"}, LinkAction: Mask},
+			text:       "see https://docs.example.com/page",
+			wantAction: Allow,
+			wantText:   "see https://docs.example.com/page",
+		},
+		{
+			name:       "masked link",
+			rules:      Rules{AllowedLinkDomains: []string{"example.com"}, LinkAction: Mask},
+			text:       "see www.evil.test/x and https://example.com",
+			wantAction: Mask,
+			wantText:   "see [link removed] and https://example.com",
+		},
+		{
+			name:       "too long",
+			rules:      Rules{MaxLength: 5},
+			text:       "toolong",
+			wantAction: Reject,
+			wantText:   "toolong",
+		},
+		{
+			name:       "mention spam flagged",
+			rules:      Rules{MaxMentions: 2, MentionAction: Flag},
+			text:       "@a @b @c hi",
+			wantAction: Flag,
+			wantText:   "@a @b @c hi",
+		},
+		{
+			name:       "strongest action wins",
+			rules:      Rules{BlockedWords: []string{"spam"}, BlocklistAction: Mask, MaxMentions: 1, MentionAction: Reject},
+			text:       "spam @a @b",
+			wantAction: Reject,
+			wantText:   "**** @a @b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Compile(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := f.Apply(tt.text)
+			if got.Action != tt.wantAction {
+				t.Errorf("got action %q, want %q (reasons %v)", got.Action, tt.wantAction, got.Reasons)
+			}
+			if got.Text != tt.wantText {
+				t.Errorf("got text %q, want %q", got.Text, tt.wantText)
+			}
+			if tt.wantAction != Allow && len(got.Reasons) == 0 {
+				t.Error("expected reasons for a matched rule")
+			}
+		})
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	for name, rules := range map[string]Rules{
+		"bad regex":       {BlockedPatterns: []string{"("}},
+		"unknown action":  {BlocklistAction: "delete"},
+		"masked mentions": {MentionAction: Mask},
+		"negative limit":  {MaxLength: -1},
+	} {
+		if _, err := Compile(rules); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	got := Merge(Result{Action: Mask, Reasons: []string{"a"}}, Result{Action: Flag, Reasons: []string{"b"}})
+	if got.Action != Flag || !slices.Equal(got.Reasons, []string{"a", "b"}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "@Alice hi @bob.b, and @alice again; not an@email.com", want: []string{"alice", "bob.b"}},
+		{text: "hi @alice. Thanks @bob_-- and @carol...", want: []string{"alice", "bob_", "carol"}},
+		{text: "just @ and @.", want: nil},
+	}
+	for _, tt := range tests {
+		if got := Mentions(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("Mentions(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxBatchIDs caps how many IDs a bulk endpoint accepts in one request.
+const maxBatchIDs = 100
+
+// batchIDs parses the comma-separated ids query parameter of a bulk
+// endpoint, dropping blanks and duplicates.
+func batchIDs(r *http.Request) ([]string, error) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("ids is required")
+	}
+	if len(ids) > maxBatchIDs {
+		return nil, fmt.Errorf("at most %d ids can be requested at once", maxBatchIDs)
+	}
+	return ids, nil
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBatchIDs(t *testing.T) {
+	tooMany := make([]string, maxBatchIDs+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprint("id", i)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "single", query: "ids=a", want: []string{"a"}},
+		{name: "keeps order", query: "ids=c,a,b", want: []string{"c", "a", "b"}},
+		{name: "drops blanks and duplicates", query: "ids=a,,+b+,a", want: []string{"a", "b"}},
+		{name: "missing", query: "", wantErr: true},
+		{name: "only commas", query: "ids=,,", wantErr: true},
+		{name: "too many", query: "ids=" + strings.Join(tooMany, ","), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/posts?"+tt.query, nil)
+			got, err := batchIDs(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
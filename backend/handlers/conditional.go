@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etag returns the entity tag of a representation last changed at modified.
+// view tells apart different representations of the same resource, such as
+// a user's own account and their public profile.
+func etag(modified time.Time, view string) string {
+	tag := strconv.FormatInt(modified.UnixMicro(), 36)
+	if view != "" {
+		tag += "-" + view
+	}
+	return `"` + tag + `"`
+}
+
+// etagTime recovers the modification time from an entity tag made by etag
+// without a view.
+func etagTime(tag string) (time.Time, bool) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return time.Time{}, false
+	}
+	micros, err := strconv.ParseInt(tag[1:len(tag)-1], 36, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(micros), true
+}
+
+// splitTags splits an If-Match or If-None-Match header value into its
+// entity tags.
+func splitTags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// notModified sets the ETag and Last-Modified validators of the
+// representation about to be sent and, if the request's If-None-Match or
+// If-Modified-Since show the client already has it, writes 304 Not Modified
+// and returns true. If-None-Match takes precedence, as in RFC 9110.
+func notModified(w http.ResponseWriter, r *http.Request, tag string, modified time.Time) bool {
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range splitTags(inm) {
+			if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		if !modified.Truncate(time.Second).After(ims) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch parses the If-Match header of a request that changes a resource
+// whose entity tags come from etag without a view. It returns the
+// modification times the client will accept, or nil when any version will
+// do because the header is absent or "*". ok is false when the header names
+// only tags that can't match any version.
+func ifMatch(r *http.Request) (versions []time.Time, ok bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil, true
+	}
+	for _, tag := range splitTags(header) {
+		if tag == "*" {
+			return nil, true
+		}
+		// Weak tags never match under the strong comparison If-Match uses.
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		if t, valid := etagTime(tag); valid {
+			versions = append(versions, t)
+		}
+	}
+	return versions, len(versions) > 0
+}
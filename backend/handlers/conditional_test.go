@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestETag_RoundTrip(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)
+	got, ok := etagTime(etag(modified, ""))
+	if !ok || !got.Equal(modified) {
+		t.Errorf("got %v, %v, want %v", got, ok, modified)
+	}
+	if etag(modified, "self") == etag(modified, "public") {
+		t.Error("expected views to have different tags")
+	}
+	if _, ok := etagTime(`"not base 36!"`); ok {
+		t.Error("expected malformed tag to be rejected")
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 30, 0, 500000000, time.UTC)
+	tag := etag(modified, "")
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "no conditions", want: false},
+		{name: "matching tag", headers: map[string]string{"If-None-Match": `"x", ` + tag}, want: true},
+		{name: "weak matching tag", headers: map[string]string{"If-None-Match": "W/" + tag}, want: true},
+		{name: "star", headers: map[string]string{"If-None-Match": "*"}, want: true},
+		{name: "other tag", headers: map[string]string{"If-None-Match": `"x"`}, want: false},
+		{name: "not modified since", headers: map[string]string{"If-Modified-Since": "Wed, 01 May 2024 12:30:00 GMT"}, want: true},
+		{name: "modified since", headers: map[string]string{"If-Modified-Since": "Wed, 01 May 2024 12:29:59 GMT"}, want: false},
+		{
+			name:    "tag beats date",
+			headers: map[string]string{"If-None-Match": `"x"`, "If-Modified-Since": "Wed, 01 May 2024 12:30:00 GMT"},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			if got := notModified(w, r, tag, modified); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if tt.want && w.Code != http.StatusNotModified {
+				t.Errorf("got status %d, want 304", w.Code)
+			}
+			if w.Header().Get("ETag") != tag || w.Header().Get("Last-Modified") != "Wed, 01 May 2024 12:30:00 GMT" {
+				t.Errorf("unexpected validators %v", w.Header())
+			}
+		})
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		header    string
+		wantTimes int
+		wantOK    bool
+	}{
+		{name: "absent", wantOK: true},
+		{name: "star", header: "*", wantOK: true},
+		{name: "tag", header: etag(modified, ""), wantTimes: 1, wantOK: true},
+		{name: "weak tag never matches", header: "W/" + etag(modified, ""), wantOK: false},
+		{name: "malformed", header: `"?"`, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("If-Match", tt.header)
+			}
+			versions, ok := ifMatch(r)
+			if ok != tt.wantOK || len(versions) != tt.wantTimes {
+				t.Errorf("got %v, %v, want %d versions, %v", versions, ok, tt.wantTimes, tt.wantOK)
+			}
+		})
+	}
+}
@@ -15,8 +15,8 @@ func setupFriendsTest(t *testing.T) (*store.Database, *http.ServeMux) {
 	s := testStore(t)
 	h := &FriendHandler{Store: s}
 
-	s.CreateUser(models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
-	s.CreateUser(models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /users/{id}/friends", h.Add)
@@ -74,7 +74,7 @@ func TestFriendHandler_Add(t *testing.T) {
 
 func TestFriendHandler_List(t *testing.T) {
 	s, mux := setupFriendsTest(t)
-	s.AddFriend("u1", "u2")
+	s.AddFriend(t.Context(), "u1", "u2")
 
 	t.Run("user with friends", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/users/u1/friends", nil)
@@ -84,10 +84,13 @@ func TestFriendHandler_List(t *testing.T) {
 		if w.Code != http.StatusOK {
 			t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
 		}
-		var friends []models.User
+		var friends []map[string]any
 		json.NewDecoder(w.Body).Decode(&friends)
 		if len(friends) != 1 {
-			t.Errorf("got %d friends, want 1", len(friends))
+			t.Fatalf("got %d friends, want 1", len(friends))
+		}
+		if _, ok := friends[0]["email"]; ok {
+			t.Errorf("friend list exposes email: %v", friends[0])
 		}
 	})
 
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/tonitran/dischord/store"
+)
+
+// readyTimeout bounds the database ping made by Readyz.
+const readyTimeout = 2 * time.Second
+
+type HealthHandler struct {
+	Store *store.Database
+	// Draining reports whether the server is shutting down. Nil means never.
+	Draining func() bool
+}
+
+// Healthz reports that the process is up. It does not touch the database.
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether the server can take traffic: it is not shutting
+// down and the database answers a ping.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if h.Draining != nil && h.Draining() {
+		logger.WarnContext(r.Context(), "health: Readyz: shutting down")
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	if err := h.Store.Ping(ctx); err != nil {
+		logger.ErrorContext(r.Context(), "health: Readyz: database ping failed", "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "database unavailable"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
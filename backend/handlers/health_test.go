@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthHandler_Healthz(t *testing.T) {
+	h := &HealthHandler{}
+	w := httptest.NewRecorder()
+	h.Healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestHealthHandler_Readyz(t *testing.T) {
+	t.Run("draining", func(t *testing.T) {
+		h := &HealthHandler{Draining: func() bool { return true }}
+		w := httptest.NewRecorder()
+		h.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("database reachable", func(t *testing.T) {
+		h := &HealthHandler{Store: testStore(t)}
+		w := httptest.NewRecorder()
+		h.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+}
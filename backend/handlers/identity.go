@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// UserIDHeader carries the ID of the user making a request. There is no
+// authentication layer yet, so the value is trusted as-is, the same way
+// author_id and owner_id in request bodies are.
+const UserIDHeader = "X-User-ID"
+
+// callerID returns the ID of the user making the request, or "" if the
+// request is anonymous.
+func callerID(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get(UserIDHeader))
+}
@@ -1,23 +1,12 @@
 package handlers
 
-import (
-	"io"
-	"log/slog"
-	"os"
-)
+import "log/slog"
 
-var logger *slog.Logger
+// logger is used by all handlers. Records are logged with the request's
+// context so that they carry its request ID.
+var logger = slog.Default()
 
-func init() {
-	if err := os.MkdirAll("logs", 0755); err != nil {
-		panic("failed to create logs directory: " + err.Error())
-	}
-	f, err := os.OpenFile("logs/dischord.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		panic("failed to open log file: " + err.Error())
-	}
-	w := io.MultiWriter(os.Stdout, f)
-	logger = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
-		Level: slog.LevelDebug,
-	}))
+// SetLogger replaces the logger used by the handlers.
+func SetLogger(l *slog.Logger) {
+	logger = l
 }
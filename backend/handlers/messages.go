@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/tonitran/dischord/filter"
 	"github.com/tonitran/dischord/models"
 	"github.com/tonitran/dischord/store"
 )
@@ -21,36 +24,135 @@ func (h *MessageHandler) Create(w http.ResponseWriter, r *http.Request) {
 		Content  string `json:"content"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("messages: Create: failed to decode request body", "server_id", serverID, "error", err)
+		logger.ErrorContext(r.Context(), "messages: Create: failed to decode request body", "server_id", serverID, "error", err)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 	if req.AuthorID == "" || req.Content == "" {
-		logger.Warn("messages: Create: missing required fields", "server_id", serverID, "author_id", req.AuthorID)
+		logger.WarnContext(r.Context(), "messages: Create: missing required fields", "server_id", serverID, "author_id", req.AuthorID)
 		http.Error(w, "author_id and content are required", http.StatusBadRequest)
 		return
 	}
+	// Slow mode is keyed on author_id, so it must be the caller's own.
+	caller := callerID(r)
+	if caller != req.AuthorID {
+		logger.WarnContext(r.Context(), "messages: Create: author is not the caller", "server_id", serverID, "author_id", req.AuthorID, "caller", caller)
+		http.Error(w, "author_id must match the "+UserIDHeader+" header", http.StatusForbidden)
+		return
+	}
+
+	slowMode, err := h.Store.GetSlowMode(r.Context(), serverID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "messages: Create: server not found", "server_id", serverID, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	member, err := h.Store.IsMember(r.Context(), serverID, req.AuthorID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "messages: Create: membership check failed", "server_id", serverID, "author_id", req.AuthorID, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		logger.WarnContext(r.Context(), "messages: Create: author is not a member", "server_id", serverID, "author_id", req.AuthorID)
+		http.Error(w, "only server members can send messages", http.StatusForbidden)
+		return
+	}
+	// Moderators are exempt from slow mode.
+	if slowMode > 0 {
+		isMod, err := h.Store.IsModerator(r.Context(), serverID, caller)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "messages: Create: slow mode check failed", "server_id", serverID, "caller", caller, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if isMod {
+			slowMode = 0
+		}
+	}
+
+	screened, verdict, err := screenContent(r.Context(), h.Store, serverID, req.Content)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "messages: Create: content filter error", "server_id", serverID, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verdict.Action == filter.Reject {
+		logger.InfoContext(r.Context(), "messages: Create: rejected by content filter", "server_id", serverID, "author_id", req.AuthorID, "reasons", verdict.Reasons)
+		writeJSON(w, http.StatusUnprocessableEntity, FilterError{Error: "message rejected by the server's content filter", Reasons: verdict.Reasons})
+		return
+	}
 
 	msg := models.Message{
 		ID:        generateID(),
 		ServerID:  serverID,
 		AuthorID:  req.AuthorID,
-		Content:   req.Content,
+		Content:   screened[0],
 		CreatedAt: time.Now(),
 	}
-	if err := h.Store.CreateMessage(msg); err != nil {
-		logger.Error("messages: Create: store error", "server_id", serverID, "author_id", req.AuthorID, "error", err)
+	flags := contentFlags(verdict, serverID, store.ContentMessage, msg.ID, msg.AuthorID, msg.Content)
+	wait, err := h.Store.CreateMessage(r.Context(), msg, time.Duration(slowMode)*time.Second, flags...)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "messages: Create: store error", "server_id", serverID, "author_id", req.AuthorID, "error", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	logger.Info("messages: Create: message created", "id", msg.ID, "server_id", serverID, "author_id", req.AuthorID)
+	if wait > 0 {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		logger.InfoContext(r.Context(), "messages: Create: slow mode", "server_id", serverID, "author_id", req.AuthorID, "retry_after", retryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeJSON(w, http.StatusTooManyRequests, SlowModeError{
+			Error:           "slow mode is enabled; wait before sending another message",
+			RetryAfter:      retryAfter,
+			SlowModeSeconds: slowMode,
+		})
+		return
+	}
+	if len(flags) > 0 {
+		logger.InfoContext(r.Context(), "messages: Create: message flagged for review", "id", msg.ID, "reasons", verdict.Reasons)
+	}
+	logger.InfoContext(r.Context(), "messages: Create: message created", "id", msg.ID, "server_id", serverID, "author_id", req.AuthorID)
+	messagesSent.Inc()
 	writeJSON(w, http.StatusCreated, msg)
 }
 
+// SlowModeError is the body of a 429 response for a message sent too soon
+// after the author's previous one.
+type SlowModeError struct {
+	Error           string `json:"error"`
+	RetryAfter      int    `json:"retry_after_seconds"`
+	SlowModeSeconds int    `json:"slow_mode_seconds"`
+}
+
 func (h *MessageHandler) ListByServer(w http.ResponseWriter, r *http.Request) {
 	serverID := r.PathValue("server_id")
-	logger.Debug("messages: ListByServer: request", "server_id", serverID)
-	msgs := h.Store.GetMessagesByServer(serverID)
-	logger.Debug("messages: ListByServer: success", "server_id", serverID, "count", len(msgs))
+	logger.DebugContext(r.Context(), "messages: ListByServer: request", "server_id", serverID)
+	msgs := h.Store.GetMessagesByServer(r.Context(), serverID)
+	logger.DebugContext(r.Context(), "messages: ListByServer: success", "server_id", serverID, "count", len(msgs))
 	writeJSON(w, http.StatusOK, msgs)
 }
+
+// Ack marks the caller as having read the server up to the given message
+// and returns their read state with the remaining unread and mention counts.
+func (h *MessageHandler) Ack(w http.ResponseWriter, r *http.Request) {
+	serverID, id, caller := r.PathValue("server_id"), r.PathValue("id"), callerID(r)
+	member, err := h.Store.IsMember(r.Context(), serverID, caller)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "messages: Ack: membership check failed", "server_id", serverID, "caller", caller, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		logger.WarnContext(r.Context(), "messages: Ack: forbidden", "server_id", serverID, "caller", caller)
+		http.Error(w, "only server members can mark messages read", http.StatusForbidden)
+		return
+	}
+	state, err := h.Store.AckMessage(r.Context(), serverID, caller, id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "messages: Ack: store error", "server_id", serverID, "id", id, "caller", caller, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.DebugContext(r.Context(), "messages: Ack: success", "server_id", serverID, "id", id, "caller", caller, "unread", state.UnreadCount)
+	writeJSON(w, http.StatusOK, state)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/tonitran/dischord/models"
 	"github.com/tonitran/dischord/store"
@@ -15,11 +16,13 @@ func setupMessagesTest(t *testing.T) (*store.Database, *http.ServeMux) {
 	s := testStore(t)
 	h := &MessageHandler{Store: s}
 
-	s.CreateServer(models.Server{ID: "s1", Name: "test-server", OwnerID: "u1", MemberIDs: []string{"u1"}})
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "test-server", OwnerID: "u1", MemberIDs: []string{"u1"}})
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /servers/{server_id}/messages", h.Create)
 	mux.HandleFunc("GET /servers/{server_id}/messages", h.ListByServer)
+	mux.HandleFunc("POST /servers/{server_id}/messages/{id}/ack", h.Ack)
 	return s, mux
 }
 
@@ -29,36 +32,56 @@ func TestMessageHandler_Create(t *testing.T) {
 	tests := []struct {
 		name       string
 		serverID   string
+		caller     string
 		body       string
 		wantStatus int
 	}{
 		{
 			name:       "valid message",
 			serverID:   "s1",
+			caller:     "u1",
 			body:       `{"author_id":"u1","content":"hello"}`,
 			wantStatus: http.StatusCreated,
 		},
 		{
 			name:       "missing content",
 			serverID:   "s1",
+			caller:     "u1",
 			body:       `{"author_id":"u1"}`,
 			wantStatus: http.StatusBadRequest,
 		},
 		{
 			name:       "missing author_id",
 			serverID:   "s1",
+			caller:     "u1",
 			body:       `{"content":"hello"}`,
 			wantStatus: http.StatusBadRequest,
 		},
 		{
 			name:       "nonexistent server",
 			serverID:   "missing",
+			caller:     "u1",
 			body:       `{"author_id":"u1","content":"hello"}`,
 			wantStatus: http.StatusNotFound,
 		},
+		{
+			name:       "non-member author",
+			serverID:   "s1",
+			caller:     "ghost",
+			body:       `{"author_id":"ghost","content":"hello"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "author is not the caller",
+			serverID:   "s1",
+			caller:     "u2",
+			body:       `{"author_id":"u1","content":"hello"}`,
+			wantStatus: http.StatusForbidden,
+		},
 		{
 			name:       "invalid json",
 			serverID:   "s1",
+			caller:     "u1",
 			body:       `{bad`,
 			wantStatus: http.StatusBadRequest,
 		},
@@ -67,6 +90,7 @@ func TestMessageHandler_Create(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(http.MethodPost, "/servers/"+tt.serverID+"/messages", strings.NewReader(tt.body))
+			req.Header.Set(UserIDHeader, tt.caller)
 			w := httptest.NewRecorder()
 			mux.ServeHTTP(w, req)
 
@@ -93,8 +117,8 @@ func TestMessageHandler_Create(t *testing.T) {
 func TestMessageHandler_ListByServer(t *testing.T) {
 	s, mux := setupMessagesTest(t)
 
-	s.CreateMessage(models.Message{ID: "m1", ServerID: "s1", AuthorID: "u1", Content: "hello"})
-	s.CreateMessage(models.Message{ID: "m2", ServerID: "s1", AuthorID: "u1", Content: "world"})
+	s.CreateMessage(t.Context(), models.Message{ID: "m1", ServerID: "s1", AuthorID: "u1", Content: "hello"}, 0)
+	s.CreateMessage(t.Context(), models.Message{ID: "m2", ServerID: "s1", AuthorID: "u1", Content: "world"}, 0)
 
 	t.Run("server with messages", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/servers/s1/messages", nil)
@@ -121,3 +145,101 @@ func TestMessageHandler_ListByServer(t *testing.T) {
 		}
 	})
 }
+
+func TestMessageHandler_SlowMode(t *testing.T) {
+	s, mux := setupMessagesTest(t)
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.JoinServer(t.Context(), "s1", "u2")
+	s.SetSlowMode(t.Context(), "s1", 60)
+
+	send := func(author string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/servers/s1/messages", strings.NewReader(`{"author_id":"`+author+`","content":"hi"}`))
+		req.Header.Set(UserIDHeader, author)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("u2"); w.Code != http.StatusCreated {
+		t.Fatalf("first message: got status %d, want %d", w.Code, http.StatusCreated)
+	}
+	w := send("u2")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second message: got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	var slow SlowModeError
+	json.NewDecoder(w.Body).Decode(&slow)
+	if slow.RetryAfter <= 0 || slow.RetryAfter > 60 || slow.SlowModeSeconds != 60 {
+		t.Errorf("unexpected slow mode error %+v", slow)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+
+	// u1 owns the server and is exempt.
+	for i := 0; i < 2; i++ {
+		if w := send("u1"); w.Code != http.StatusCreated {
+			t.Errorf("owner message %d: got status %d, want %d", i, w.Code, http.StatusCreated)
+		}
+	}
+}
+
+func TestMessageHandler_Ack(t *testing.T) {
+	s, mux := setupMessagesTest(t)
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "Bob", Email: "b@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u3", Username: "carol", Email: "c@example.com"})
+	s.JoinServer(t.Context(), "s1", "u2")
+	now := time.Now()
+	s.CreateMessage(t.Context(), models.Message{ID: "m1", ServerID: "s1", AuthorID: "u1", Content: "hi @bob", CreatedAt: now.Add(time.Minute)}, 0)
+	s.CreateMessage(t.Context(), models.Message{ID: "m2", ServerID: "s1", AuthorID: "u1", Content: "@carol isn't here", CreatedAt: now.Add(2 * time.Minute)}, 0)
+	s.CreateMessage(t.Context(), models.Message{ID: "m3", ServerID: "s1", AuthorID: "u2", Content: "hello @alice", CreatedAt: now.Add(3 * time.Minute)}, 0)
+
+	if state, err := s.GetReadState(t.Context(), "s1", "u2"); err != nil || state.UnreadCount != 2 || state.MentionCount != 1 {
+		t.Fatalf("got %+v, %v, want 2 unread and 1 mention", state, err)
+	}
+
+	tests := []struct {
+		name        string
+		caller      string
+		id          string
+		wantStatus  int
+		wantLast    string
+		wantUnread  int
+		wantMention int
+	}{
+		{name: "non-member", caller: "u3", id: "m1", wantStatus: http.StatusForbidden},
+		{name: "unknown message", caller: "u2", id: "missing", wantStatus: http.StatusNotFound},
+		{name: "first message", caller: "u2", id: "m1", wantStatus: http.StatusOK, wantLast: "m1", wantUnread: 1},
+		{name: "latest message", caller: "u2", id: "m3", wantStatus: http.StatusOK, wantLast: "m3"},
+		{name: "older message", caller: "u2", id: "m2", wantStatus: http.StatusOK, wantLast: "m3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/servers/s1/messages/"+tt.id+"/ack", nil)
+			req.Header.Set(UserIDHeader, tt.caller)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var state models.ReadState
+			json.NewDecoder(w.Body).Decode(&state)
+			if state.LastReadMessageID != tt.wantLast || state.UnreadCount != tt.wantUnread || state.MentionCount != tt.wantMention {
+				t.Errorf("got %+v, want last read %s with %d unread and %d mentions", state, tt.wantLast, tt.wantUnread, tt.wantMention)
+			}
+		})
+	}
+
+	// Alice is mentioned by Bob, and carol isn't a member so can't be.
+	servers, err := s.ListUserServers(t.Context(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0].UnreadCount != 1 || servers[0].MentionCount != 1 {
+		t.Errorf("got %+v, want 1 unread mention for alice", servers)
+	}
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import "github.com/tonitran/dischord/metrics"
+
+// Business counters, exposed at /metrics.
+var (
+	messagesSent = metrics.Default.Counter("dischord_messages_sent_total", "Chat messages stored.")
+	postsCreated = metrics.Default.Counter("dischord_posts_created_total", "Posts created.")
+	votesCast    = metrics.Default.Counter("dischord_votes_cast_total",
+		"Votes recorded, by the new vote: up, down or cleared.", "vote")
+)
+
+// voteLabel names a vote value for the votesCast counter.
+func voteLabel(vote int) string {
+	switch {
+	case vote > 0:
+		return "up"
+	case vote < 0:
+		return "down"
+	}
+	return "cleared"
+}
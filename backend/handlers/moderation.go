@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tonitran/dischord/filter"
+	"github.com/tonitran/dischord/models"
+	"github.com/tonitran/dischord/store"
+)
+
+type ModerationHandler struct {
+	Store *store.Database
+}
+
+// FilterError is the body of a 422 response for content the server's filter
+// rejected.
+type FilterError struct {
+	Error   string   `json:"error"`
+	Reasons []string `json:"reasons"`
+}
+
+// screenContent runs each field through serverID's content filter and
+// returns the fields as they should be stored along with the combined result.
+func screenContent(ctx context.Context, s *store.Database, serverID string, fields ...string) ([]string, filter.Result, error) {
+	rules, err := s.GetFilterRules(ctx, serverID)
+	if err != nil {
+		return nil, filter.Result{}, err
+	}
+	f, err := filter.Compile(rules)
+	if err != nil {
+		return nil, filter.Result{}, err
+	}
+	screened := make([]string, len(fields))
+	results := make([]filter.Result, len(fields))
+	for i, field := range fields {
+		results[i] = f.Apply(field)
+		screened[i] = results[i].Text
+	}
+	return screened, filter.Merge(results...), nil
+}
+
+// contentFlags returns the moderation queue entry for content the filter
+// flagged, to be stored along with the content, or none if verdict doesn't
+// call for review.
+func contentFlags(verdict filter.Result, serverID, contentType, contentID, authorID, content string) []models.Flag {
+	if verdict.Action != filter.Flag {
+		return nil
+	}
+	return []models.Flag{{
+		ID:          generateID(),
+		ServerID:    serverID,
+		ContentType: contentType,
+		ContentID:   contentID,
+		AuthorID:    authorID,
+		Content:     content,
+		Reasons:     verdict.Reasons,
+		Status:      store.FlagPending,
+		CreatedAt:   time.Now(),
+	}}
+}
+
+// requireAdmin writes a 403 and returns false unless the caller owns serverID
+// or is one of its admins.
+func requireAdmin(s *store.Database, w http.ResponseWriter, r *http.Request, serverID, op string) bool {
+	caller := callerID(r)
+	isAdmin, err := s.IsAdmin(r.Context(), serverID, caller)
+	if err != nil {
+		logger.ErrorContext(r.Context(), op+": admin check failed", "server_id", serverID, "caller", caller, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !isAdmin {
+		logger.WarnContext(r.Context(), op+": forbidden", "server_id", serverID, "caller", caller)
+		http.Error(w, "only the owner or an admin can do that", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireModerator writes a 403 and returns false unless the caller moderates
+// serverID.
+func requireModerator(s *store.Database, w http.ResponseWriter, r *http.Request, serverID, op string) bool {
+	caller := callerID(r)
+	isMod, err := s.IsModerator(r.Context(), serverID, caller)
+	if err != nil {
+		logger.ErrorContext(r.Context(), op+": moderator check failed", "server_id", serverID, "caller", caller, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !isMod {
+		logger.WarnContext(r.Context(), op+": forbidden", "server_id", serverID, "caller", caller)
+		http.Error(w, "only moderators can do that", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *ModerationHandler) GetFilter(w http.ResponseWriter, r *http.Request) {
+	serverID := r.PathValue("id")
+	if !requireModerator(h.Store, w, r, serverID, "moderation: GetFilter") {
+		return
+	}
+	rules, err := h.Store.GetFilterRules(r.Context(), serverID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "moderation: GetFilter: store error", "server_id", serverID, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugContext(r.Context(), "moderation: GetFilter: success", "server_id", serverID)
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// PutFilter replaces a server's content filter rules.
+func (h *ModerationHandler) PutFilter(w http.ResponseWriter, r *http.Request) {
+	serverID := r.PathValue("id")
+	if !requireModerator(h.Store, w, r, serverID, "moderation: PutFilter") {
+		return
+	}
+	var rules filter.Rules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		logger.ErrorContext(r.Context(), "moderation: PutFilter: failed to decode request body", "server_id", serverID, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	rules = rules.WithDefaults()
+	if _, err := filter.Compile(rules); err != nil {
+		logger.WarnContext(r.Context(), "moderation: PutFilter: invalid rules", "server_id", serverID, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.Store.SetFilterRules(r.Context(), serverID, rules); err != nil {
+		logger.ErrorContext(r.Context(), "moderation: PutFilter: store error", "server_id", serverID, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.InfoContext(r.Context(), "moderation: PutFilter: rules updated", "server_id", serverID, "caller", callerID(r))
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// ListFlags returns the server's moderation queue. It defaults to pending
+// flags; ?status=approved, ?status=removed or ?status=all select others.
+func (h *ModerationHandler) ListFlags(w http.ResponseWriter, r *http.Request) {
+	serverID := r.PathValue("id")
+	if !requireModerator(h.Store, w, r, serverID, "moderation: ListFlags") {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = store.FlagPending
+	case "all":
+		status = ""
+	case store.FlagPending, store.FlagApproved, store.FlagRemoved:
+	default:
+		http.Error(w, "status must be pending, approved, removed or all", http.StatusBadRequest)
+		return
+	}
+	flags, err := h.Store.ListFlags(r.Context(), serverID, status)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "moderation: ListFlags: store error", "server_id", serverID, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugContext(r.Context(), "moderation: ListFlags: success", "server_id", serverID, "count", len(flags))
+	writeJSON(w, http.StatusOK, flags)
+}
+
+// ResolveFlag approves flagged content or removes it.
+func (h *ModerationHandler) ResolveFlag(w http.ResponseWriter, r *http.Request) {
+	serverID := r.PathValue("id")
+	flagID := r.PathValue("flag_id")
+	if !requireModerator(h.Store, w, r, serverID, "moderation: ResolveFlag") {
+		return
+	}
+	var req struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.ErrorContext(r.Context(), "moderation: ResolveFlag: failed to decode request body", "flag_id", flagID, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	var status string
+	switch req.Action {
+	case "approve":
+		status = store.FlagApproved
+	case "remove":
+		status = store.FlagRemoved
+	default:
+		http.Error(w, "action must be approve or remove", http.StatusBadRequest)
+		return
+	}
+	if err := h.Store.ResolveFlag(r.Context(), serverID, flagID, callerID(r), status); err != nil {
+		logger.ErrorContext(r.Context(), "moderation: ResolveFlag: store error", "server_id", serverID, "flag_id", flagID, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.InfoContext(r.Context(), "moderation: ResolveFlag: resolved", "server_id", serverID, "flag_id", flagID, "status", status, "caller", callerID(r))
+	writeJSON(w, http.StatusOK, map[string]string{"status": status})
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tonitran/dischord/filter"
+	"github.com/tonitran/dischord/models"
+	"github.com/tonitran/dischord/store"
+)
+
+func setupModerationTest(t *testing.T) (*store.Database, *http.ServeMux) {
+	s := testStore(t)
+	h := &ModerationHandler{Store: s}
+	messages := &MessageHandler{Store: s}
+	posts := &PostHandler{Store: s}
+
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1"})
+	s.JoinServer(t.Context(), "s1", "u2")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /servers/{id}/filter", h.GetFilter)
+	mux.HandleFunc("PUT /servers/{id}/filter", h.PutFilter)
+	mux.HandleFunc("GET /servers/{id}/flags", h.ListFlags)
+	mux.HandleFunc("POST /servers/{id}/flags/{flag_id}/resolve", h.ResolveFlag)
+	mux.HandleFunc("POST /servers/{server_id}/messages", messages.Create)
+	mux.HandleFunc("POST /servers/{server_id}/posts", posts.Create)
+	return s, mux
+}
+
+func TestModerationHandler_PutFilter(t *testing.T) {
+	s, mux := setupModerationTest(t)
+
+	tests := []struct {
+		name       string
+		caller     string
+		body       string
+		wantStatus int
+	}{
+		{name: "owner sets rules", caller: "u1", body: `{"blocked_words":["darn"],"blocklist_action":"mask"}`, wantStatus: http.StatusOK},
+		{name: "member forbidden", caller: "u2", body: `{}`, wantStatus: http.StatusForbidden},
+		{name: "invalid regex", caller: "u1", body: `{"blocked_patterns":["("]}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid json", caller: "u1", body: `{bad`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/servers/s1/filter", strings.NewReader(tt.body))
+			req.Header.Set(UserIDHeader, tt.caller)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	rules, err := s.GetFilterRules(t.Context(), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.BlocklistAction != filter.Mask || len(rules.BlockedWords) != 1 {
+		t.Errorf("unexpected stored rules %+v", rules)
+	}
+}
+
+func TestModerationHandler_FilterPipeline(t *testing.T) {
+	s, mux := setupModerationTest(t)
+	s.SetFilterRules(t.Context(), "s1", filter.Rules{
+		BlockedWords:    []string{"darn"},
+		BlocklistAction: filter.Mask,
+		MaxLength:       50,
+		MaxMentions:     2,
+		MentionAction:   filter.Flag,
+	})
+
+	send := func(content string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"author_id": "u2", "content": content})
+		req := httptest.NewRequest(http.MethodPost, "/servers/s1/messages", strings.NewReader(string(body)))
+		req.Header.Set(UserIDHeader, "u2")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("masked", func(t *testing.T) {
+		w := send("oh darn")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusCreated)
+		}
+		var msg models.Message
+		json.NewDecoder(w.Body).Decode(&msg)
+		if msg.Content != "oh ****" {
+			t.Errorf("got content %q, want masked", msg.Content)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		w := send(strings.Repeat("x", 51))
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+		}
+		var ferr FilterError
+		json.NewDecoder(w.Body).Decode(&ferr)
+		if len(ferr.Reasons) == 0 {
+			t.Error("expected rejection reasons")
+		}
+	})
+
+	var flagged models.Message
+	t.Run("flagged", func(t *testing.T) {
+		w := send("@a @b @c look")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusCreated)
+		}
+		json.NewDecoder(w.Body).Decode(&flagged)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/servers/s1/flags", nil)
+	req.Header.Set(UserIDHeader, "u1")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list flags: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var flags []models.Flag
+	json.NewDecoder(w.Body).Decode(&flags)
+	if len(flags) != 1 || flags[0].ContentID != flagged.ID || flags[0].ContentType != store.ContentMessage {
+		t.Fatalf("unexpected queue %+v", flags)
+	}
+
+	resolve := func(caller, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/servers/s1/flags/"+flags[0].ID+"/resolve", strings.NewReader(body))
+		req.Header.Set(UserIDHeader, caller)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := resolve("u2", `{"action":"remove"}`); code != http.StatusForbidden {
+		t.Errorf("member resolving: got status %d, want %d", code, http.StatusForbidden)
+	}
+	if code := resolve("u1", `{"action":"ban"}`); code != http.StatusBadRequest {
+		t.Errorf("unknown action: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := resolve("u1", `{"action":"remove"}`); code != http.StatusOK {
+		t.Fatalf("remove: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := resolve("u1", `{"action":"approve"}`); code != http.StatusNotFound {
+		t.Errorf("already resolved: got status %d, want %d", code, http.StatusNotFound)
+	}
+	for _, m := range s.GetMessagesByServer(t.Context(), "s1") {
+		if m.ID == flagged.ID {
+			t.Error("expected removed message to be deleted")
+		}
+	}
+}
+
+func TestModerationHandler_FilterRejectsPost(t *testing.T) {
+	s, mux := setupModerationTest(t)
+	s.SetFilterRules(t.Context(), "s1", filter.Rules{BlockedWords: []string{"spam"}, BlocklistAction: filter.Reject})
+
+	req := httptest.NewRequest(http.MethodPost, "/servers/s1/posts", strings.NewReader(`{"author_id":"u2","title":"buy spam","body":"now"}`))
+	req.Header.Set(UserIDHeader, "u2")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
+	"github.com/tonitran/dischord/filter"
 	"github.com/tonitran/dischord/models"
 	"github.com/tonitran/dischord/store"
 )
@@ -21,94 +23,324 @@ func (h *PostHandler) Create(w http.ResponseWriter, r *http.Request) {
 		Body     string `json:"body"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("posts: Create: failed to decode request body", "server_id", server_id, "error", err)
+		logger.ErrorContext(r.Context(), "posts: Create: failed to decode request body", "server_id", server_id, "error", err)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 	if req.AuthorID == "" || req.Title == "" || req.Body == "" {
-		logger.Warn("posts: Create: missing required fields", "server_id", server_id, "author_id", req.AuthorID, "title", req.Title)
+		logger.WarnContext(r.Context(), "posts: Create: missing required fields", "server_id", server_id, "author_id", req.AuthorID, "title", req.Title)
 		http.Error(w, "author_id, title, and body are required", http.StatusBadRequest)
 		return
 	}
+	if caller := callerID(r); caller != req.AuthorID {
+		logger.WarnContext(r.Context(), "posts: Create: author is not the caller", "server_id", server_id, "author_id", req.AuthorID, "caller", caller)
+		http.Error(w, "author_id must match the "+UserIDHeader+" header", http.StatusForbidden)
+		return
+	}
+
+	if _, err := h.Store.GetUser(r.Context(), req.AuthorID); err != nil {
+		logger.WarnContext(r.Context(), "posts: Create: author not found", "server_id", server_id, "author_id", req.AuthorID, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	member, err := h.Store.IsMember(r.Context(), server_id, req.AuthorID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "posts: Create: membership check failed", "server_id", server_id, "author_id", req.AuthorID, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		logger.WarnContext(r.Context(), "posts: Create: author is not a member", "server_id", server_id, "author_id", req.AuthorID)
+		http.Error(w, "only server members can post", http.StatusForbidden)
+		return
+	}
+
+	screened, verdict, err := screenContent(r.Context(), h.Store, server_id, req.Title, req.Body)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "posts: Create: content filter error", "server_id", server_id, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verdict.Action == filter.Reject {
+		logger.InfoContext(r.Context(), "posts: Create: rejected by content filter", "server_id", server_id, "author_id", req.AuthorID, "reasons", verdict.Reasons)
+		writeJSON(w, http.StatusUnprocessableEntity, FilterError{Error: "post rejected by the server's content filter", Reasons: verdict.Reasons})
+		return
+	}
 
 	now := time.Now()
 	post := models.Post{
 		ID:        generateID(),
 		ServerID:  server_id,
 		AuthorID:  req.AuthorID,
-		Title:     req.Title,
-		Body:      req.Body,
+		Title:     screened[0],
+		Body:      screened[1],
 		Votes:     0,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
-	if err := h.Store.CreatePost(post); err != nil {
-		logger.Error("posts: Create: store error", "server_id", server_id, "author_id", req.AuthorID, "error", err)
+	flags := contentFlags(verdict, server_id, store.ContentPost, post.ID, post.AuthorID, post.Title+"\n\n"+post.Body)
+	if err := h.Store.CreatePost(r.Context(), post, flags...); err != nil {
+		logger.ErrorContext(r.Context(), "posts: Create: store error", "server_id", server_id, "author_id", req.AuthorID, "error", err)
 		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
-	logger.Info("posts: Create: post created", "id", post.ID, "server_id", server_id, "author_id", req.AuthorID, "title", req.Title)
+	if len(flags) > 0 {
+		logger.InfoContext(r.Context(), "posts: Create: post flagged for review", "id", post.ID, "reasons", verdict.Reasons)
+	}
+	logger.InfoContext(r.Context(), "posts: Create: post created", "id", post.ID, "server_id", server_id, "author_id", req.AuthorID, "title", req.Title)
+	postsCreated.Inc()
 	writeJSON(w, http.StatusCreated, post)
 }
 
 func (h *PostHandler) Get(w http.ResponseWriter, r *http.Request) {
 	server_id := r.PathValue("server_id")
 	id := r.PathValue("id")
-	logger.Debug("posts: Get: request", "server_id", server_id, "id", id)
-	post, err := h.Store.GetPost(server_id, id)
+	logger.DebugContext(r.Context(), "posts: Get: request", "server_id", server_id, "id", id)
+	post, err := h.Store.GetPost(r.Context(), server_id, id)
 	if err != nil {
-		logger.Error("posts: Get: not found", "server_id", server_id, "id", id, "error", err)
+		logger.ErrorContext(r.Context(), "posts: Get: not found", "server_id", server_id, "id", id, "error", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	logger.Debug("posts: Get: success", "id", id, "title", post.Title)
+	if notModified(w, r, etag(post.ModifiedAt, ""), post.ModifiedAt) {
+		logger.DebugContext(r.Context(), "posts: Get: not modified", "id", id)
+		return
+	}
+	logger.DebugContext(r.Context(), "posts: Get: success", "id", id, "title", post.Title)
 	writeJSON(w, http.StatusOK, post)
 }
 
+// GetMany returns the posts listed in ?ids=, in that order. Unknown IDs are
+// left out.
+func (h *PostHandler) GetMany(w http.ResponseWriter, r *http.Request) {
+	ids, err := batchIDs(r)
+	if err != nil {
+		logger.WarnContext(r.Context(), "posts: GetMany: invalid ids", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	posts, err := h.Store.GetPosts(r.Context(), ids)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "posts: GetMany: store error", "count", len(ids), "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugContext(r.Context(), "posts: GetMany: success", "requested", len(ids), "found", len(posts))
+	writeJSON(w, http.StatusOK, posts)
+}
+
+// Update edits a post's title and body. With If-Match, the edit only applies
+// if the post still has one of the given ETags; otherwise it fails with 412
+// so that concurrent edits don't overwrite each other.
 func (h *PostHandler) Update(w http.ResponseWriter, r *http.Request) {
 	server_id := r.PathValue("server_id")
 	id := r.PathValue("id")
-	post, err := h.Store.GetPost(server_id, id)
+	versions, ok := ifMatch(r)
+	if !ok {
+		logger.WarnContext(r.Context(), "posts: Update: If-Match matches no version", "id", id, "if_match", r.Header.Get("If-Match"))
+		http.Error(w, store.ErrPreconditionFailed.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	post, err := h.Store.GetPost(r.Context(), server_id, id)
 	if err != nil {
-		logger.Error("posts: Update: post not found", "server_id", server_id, "id", id, "error", err)
+		logger.ErrorContext(r.Context(), "posts: Update: post not found", "server_id", server_id, "id", id, "error", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if !h.requireAuthorOrModerator(w, r, post, "posts: Update") {
+		return
+	}
 
 	var req struct {
-		Title *string `json:"title"`
-		Body  *string `json:"body"`
+		Title   *string `json:"title"`
+		Body    *string `json:"body"`
+		Version *int    `json:"version"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("posts: Update: failed to decode request body", "id", id, "error", err)
+		logger.ErrorContext(r.Context(), "posts: Update: failed to decode request body", "id", id, "error", err)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	if req.Title != nil {
-		post.Title = *req.Title
+	if req.Version == nil {
+		logger.WarnContext(r.Context(), "posts: Update: missing version", "id", id)
+		http.Error(w, "version is required", http.StatusBadRequest)
+		return
+	}
+
+	// Edits go through the content filter like new posts, so that clean
+	// text can't be edited into something the filter would have stopped.
+	var fields []*string
+	for _, f := range []*string{req.Title, req.Body} {
+		if f != nil {
+			fields = append(fields, f)
+		}
+	}
+	texts := make([]string, len(fields))
+	for i, f := range fields {
+		texts[i] = *f
+	}
+	screened, verdict, err := screenContent(r.Context(), h.Store, server_id, texts...)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "posts: Update: content filter error", "server_id", server_id, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verdict.Action == filter.Reject {
+		logger.InfoContext(r.Context(), "posts: Update: rejected by content filter", "id", id, "editor", callerID(r), "reasons", verdict.Reasons)
+		writeJSON(w, http.StatusUnprocessableEntity, FilterError{Error: "edit rejected by the server's content filter", Reasons: verdict.Reasons})
+		return
+	}
+	for i, f := range fields {
+		*f = screened[i]
+	}
+
+	edit := store.PostEdit{
+		ID:       id,
+		ServerID: server_id,
+		EditorID: callerID(r),
+		Version:  *req.Version,
+		Title:    req.Title,
+		Body:     req.Body,
+		IfMatch:  versions,
+	}
+	if flags := contentFlags(verdict, server_id, store.ContentPost, id, post.AuthorID, ""); len(flags) > 0 {
+		edit.Flag = &flags[0]
+	}
+	updated, err := h.Store.UpdatePost(r.Context(), edit)
+	if errors.Is(err, store.ErrVersionConflict) {
+		logger.InfoContext(r.Context(), "posts: Update: edit conflict", "id", id, "version", *req.Version, "current_version", updated.Version)
+		writeJSON(w, http.StatusConflict, PostConflictError{
+			Error:          err.Error(),
+			CurrentVersion: updated.Version,
+			Post:           updated,
+		})
+		return
+	}
+	if errors.Is(err, store.ErrPreconditionFailed) {
+		logger.InfoContext(r.Context(), "posts: Update: post changed since If-Match version", "id", id)
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
 	}
-	if req.Body != nil {
-		post.Body = *req.Body
+	if err != nil {
+		logger.ErrorContext(r.Context(), "posts: Update: store error", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	post.UpdatedAt = time.Now()
+	if edit.Flag != nil {
+		logger.InfoContext(r.Context(), "posts: Update: post flagged for review", "id", id, "reasons", verdict.Reasons)
+	}
+	logger.InfoContext(r.Context(), "posts: Update: post updated", "id", id, "title", updated.Title, "version", updated.Version)
+	w.Header().Set("ETag", etag(updated.ModifiedAt, ""))
+	w.Header().Set("Last-Modified", updated.ModifiedAt.UTC().Format(http.TimeFormat))
+	writeJSON(w, http.StatusOK, updated)
+}
 
-	if err := h.Store.UpdatePost(post); err != nil {
-		logger.Error("posts: Update: store error", "id", id, "error", err)
+// PostConflictError is the body of a 409 response to an edit made against
+// a version of the post that has since been replaced.
+type PostConflictError struct {
+	Error          string      `json:"error"`
+	CurrentVersion int         `json:"current_version"`
+	Post           models.Post `json:"post"`
+}
+
+// Revisions lists every saved version of a post. Only its author and the
+// server's moderators may see them.
+func (h *PostHandler) Revisions(w http.ResponseWriter, r *http.Request) {
+	server_id := r.PathValue("server_id")
+	id := r.PathValue("id")
+	post, err := h.Store.GetPost(r.Context(), server_id, id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "posts: Revisions: post not found", "server_id", server_id, "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !h.requireAuthorOrModerator(w, r, post, "posts: Revisions") {
+		return
+	}
+	revisions, err := h.Store.ListPostRevisions(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "posts: Revisions: store error", "id", id, "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	logger.Info("posts: Update: post updated", "id", id, "title", post.Title)
-	writeJSON(w, http.StatusOK, post)
+	logger.DebugContext(r.Context(), "posts: Revisions: returning revisions", "id", id, "count", len(revisions))
+	writeJSON(w, http.StatusOK, revisions)
 }
 
 func (h *PostHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	server_id := r.PathValue("server_id")
 	id := r.PathValue("id")
-	logger.Debug("posts: Delete: request", "id", id)
-	if err := h.Store.DeletePost(id); err != nil {
-		logger.Error("posts: Delete: store error", "id", id, "error", err)
+	logger.DebugContext(r.Context(), "posts: Delete: request", "server_id", server_id, "id", id)
+	post, err := h.Store.GetPost(r.Context(), server_id, id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "posts: Delete: post not found", "server_id", server_id, "id", id, "error", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	logger.Info("posts: Delete: post deleted", "id", id)
+	if !h.requireAuthorOrModerator(w, r, post, "posts: Delete") {
+		return
+	}
+	if err := h.Store.DeletePost(r.Context(), server_id, id, callerID(r)); err != nil {
+		logger.ErrorContext(r.Context(), "posts: Delete: store error", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.InfoContext(r.Context(), "posts: Delete: post deleted", "id", id)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// ListDeleted lists the server's deleted posts that can still be restored.
+// Only moderators may see them.
+func (h *PostHandler) ListDeleted(w http.ResponseWriter, r *http.Request) {
+	server_id := r.PathValue("server_id")
+	if !requireModerator(h.Store, w, r, server_id, "posts: ListDeleted") {
+		return
+	}
+	posts, err := h.Store.ListDeletedPosts(r.Context(), server_id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "posts: ListDeleted: store error", "server_id", server_id, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugContext(r.Context(), "posts: ListDeleted: returning posts", "server_id", server_id, "count", len(posts))
+	writeJSON(w, http.StatusOK, posts)
+}
+
+// Restore undeletes a post deleted within the retention window. Only
+// moderators may restore posts.
+func (h *PostHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	server_id := r.PathValue("server_id")
+	id := r.PathValue("id")
+	if !requireModerator(h.Store, w, r, server_id, "posts: Restore") {
+		return
+	}
+	post, err := h.Store.RestorePost(r.Context(), server_id, id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "posts: Restore: store error", "server_id", server_id, "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.InfoContext(r.Context(), "posts: Restore: post restored", "server_id", server_id, "id", id, "caller", callerID(r))
+	writeJSON(w, http.StatusOK, post)
+}
+
+// requireAuthorOrModerator reports whether the caller may change post: its
+// author or one of its server's moderators. If not, it writes a 403 response.
+func (h *PostHandler) requireAuthorOrModerator(w http.ResponseWriter, r *http.Request, post models.Post, op string) bool {
+	caller := callerID(r)
+	if caller != "" && caller == post.AuthorID {
+		return true
+	}
+	isMod, err := h.Store.IsModerator(r.Context(), post.ServerID, caller)
+	if err != nil {
+		logger.ErrorContext(r.Context(), op+": moderator check failed", "server_id", post.ServerID, "caller", caller, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !isMod {
+		logger.WarnContext(r.Context(), op+": forbidden", "id", post.ID, "caller", caller)
+		http.Error(w, "only the author or a moderator can do that", http.StatusForbidden)
+		return false
+	}
+	return true
+}
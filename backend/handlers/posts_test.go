@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/tonitran/dischord/filter"
 	"github.com/tonitran/dischord/models"
 	"github.com/tonitran/dischord/store"
 )
@@ -15,11 +16,20 @@ func setupPostsTest(t *testing.T) (*store.Database, *http.ServeMux) {
 	s := testStore(t)
 	h := &PostHandler{Store: s}
 
+	// alice owns s1 and so moderates it, bob is a member and carol is not.
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u3", Username: "carol", Email: "c@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1", MemberIDs: []string{"u1", "u2"}})
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /posts", h.Create)
-	mux.HandleFunc("GET /posts/{id}", h.Get)
-	mux.HandleFunc("PATCH /posts/{id}", h.Update)
-	mux.HandleFunc("DELETE /posts/{id}", h.Delete)
+	mux.HandleFunc("POST /servers/{server_id}/posts", h.Create)
+	mux.HandleFunc("GET /servers/{server_id}/posts/{id}", h.Get)
+	mux.HandleFunc("PATCH /servers/{server_id}/posts/{id}", h.Update)
+	mux.HandleFunc("DELETE /servers/{server_id}/posts/{id}", h.Delete)
+	mux.HandleFunc("GET /servers/{server_id}/posts/{id}/revisions", h.Revisions)
+	mux.HandleFunc("GET /servers/{server_id}/posts/deleted", h.ListDeleted)
+	mux.HandleFunc("POST /servers/{server_id}/posts/{id}/restore", h.Restore)
 	return s, mux
 }
 
@@ -28,34 +38,58 @@ func TestPostHandler_Create(t *testing.T) {
 
 	tests := []struct {
 		name       string
+		caller     string
 		body       string
 		wantStatus int
 	}{
 		{
 			name:       "valid post",
+			caller:     "u1",
 			body:       `{"author_id":"u1","title":"Hello","body":"World"}`,
 			wantStatus: http.StatusCreated,
 		},
 		{
 			name:       "missing title",
+			caller:     "u1",
 			body:       `{"author_id":"u1"}`,
 			wantStatus: http.StatusBadRequest,
 		},
 		{
 			name:       "missing author_id",
+			caller:     "u1",
 			body:       `{"title":"Hello"}`,
 			wantStatus: http.StatusBadRequest,
 		},
 		{
 			name:       "invalid json",
+			caller:     "u1",
 			body:       `{bad`,
 			wantStatus: http.StatusBadRequest,
 		},
+		{
+			name:       "author not a member",
+			caller:     "u3",
+			body:       `{"author_id":"u3","title":"Hello","body":"World"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "author is not the caller",
+			caller:     "u2",
+			body:       `{"author_id":"u1","title":"Hello","body":"World"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "unknown author",
+			caller:     "nobody",
+			body:       `{"author_id":"nobody","title":"Hello","body":"World"}`,
+			wantStatus: http.StatusNotFound,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodPost, "/posts", strings.NewReader(tt.body))
+			req := httptest.NewRequest(http.MethodPost, "/servers/s1/posts", strings.NewReader(tt.body))
+			req.Header.Set(UserIDHeader, tt.caller)
 			w := httptest.NewRecorder()
 			mux.ServeHTTP(w, req)
 
@@ -78,10 +112,10 @@ func TestPostHandler_Create(t *testing.T) {
 
 func TestPostHandler_Get(t *testing.T) {
 	s, mux := setupPostsTest(t)
-	s.CreatePost(models.Post{ID: "p1", AuthorID: "u1", Title: "Hello", Body: "World"})
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello", Body: "World"})
 
 	t.Run("existing post", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/posts/p1", nil)
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1", nil)
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -98,8 +132,18 @@ func TestPostHandler_Get(t *testing.T) {
 		}
 	})
 
+	t.Run("wrong server", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/servers/s2/posts/p1", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
 	t.Run("nonexistent post", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/posts/missing", nil)
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/missing", nil)
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -111,10 +155,11 @@ func TestPostHandler_Get(t *testing.T) {
 
 func TestPostHandler_Update(t *testing.T) {
 	s, mux := setupPostsTest(t)
-	s.CreatePost(models.Post{ID: "p1", AuthorID: "u1", Title: "Hello", Body: "World"})
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u2", Title: "Hello", Body: "World"})
 
 	t.Run("update title", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPatch, "/posts/p1", strings.NewReader(`{"title":"Updated"}`))
+		req := httptest.NewRequest(http.MethodPatch, "/servers/s1/posts/p1", strings.NewReader(`{"title":"Updated","version":1}`))
+		req.Header.Set(UserIDHeader, "u2")
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -132,7 +177,8 @@ func TestPostHandler_Update(t *testing.T) {
 	})
 
 	t.Run("update body", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPatch, "/posts/p1", strings.NewReader(`{"body":"New body"}`))
+		req := httptest.NewRequest(http.MethodPatch, "/servers/s1/posts/p1", strings.NewReader(`{"body":"New body","version":2}`))
+		req.Header.Set(UserIDHeader, "u2")
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -146,8 +192,58 @@ func TestPostHandler_Update(t *testing.T) {
 		}
 	})
 
+	t.Run("stale version", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/servers/s1/posts/p1", strings.NewReader(`{"title":"Stale","version":1}`))
+		req.Header.Set(UserIDHeader, "u2")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusConflict {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusConflict)
+		}
+		var conflict PostConflictError
+		json.NewDecoder(w.Body).Decode(&conflict)
+		if conflict.CurrentVersion != 3 || conflict.Post.Body != "New body" {
+			t.Errorf("got current version %d and body %q, want 3 and %q", conflict.CurrentVersion, conflict.Post.Body, "New body")
+		}
+	})
+
+	t.Run("missing version", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/servers/s1/posts/p1", strings.NewReader(`{"title":"X"}`))
+		req.Header.Set(UserIDHeader, "u2")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
 	t.Run("nonexistent post", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPatch, "/posts/missing", strings.NewReader(`{"title":"X"}`))
+		req := httptest.NewRequest(http.MethodPatch, "/servers/s1/posts/missing", strings.NewReader(`{"title":"X","version":1}`))
+		req.Header.Set(UserIDHeader, "u2")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("not the author", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/servers/s1/posts/p1", strings.NewReader(`{"title":"Mine now","version":3}`))
+		req.Header.Set(UserIDHeader, "u3")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("wrong server", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/servers/s2/posts/p1", strings.NewReader(`{"title":"X","version":3}`))
+		req.Header.Set(UserIDHeader, "u2")
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -157,7 +253,8 @@ func TestPostHandler_Update(t *testing.T) {
 	})
 
 	t.Run("invalid json", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPatch, "/posts/p1", strings.NewReader(`{bad`))
+		req := httptest.NewRequest(http.MethodPatch, "/servers/s1/posts/p1", strings.NewReader(`{bad`))
+		req.Header.Set(UserIDHeader, "u2")
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -167,27 +264,247 @@ func TestPostHandler_Update(t *testing.T) {
 	})
 }
 
+func TestPostHandler_UpdateFiltered(t *testing.T) {
+	s, mux := setupPostsTest(t)
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u2", Title: "Hello", Body: "World"})
+	s.SetFilterRules(t.Context(), "s1", filter.Rules{
+		BlockedWords:    []string{"darn"},
+		BlocklistAction: filter.Mask,
+		MaxLength:       50,
+		MaxMentions:     2,
+		MentionAction:   filter.Flag,
+	})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "masked", body: `{"body":"oh darn","version":1}`, wantStatus: http.StatusOK, wantBody: "oh ****"},
+		{name: "rejected", body: `{"body":"` + strings.Repeat("x", 51) + `","version":2}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "flagged", body: `{"body":"@a @b @c look","version":2}`, wantStatus: http.StatusOK, wantBody: "@a @b @c look"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/servers/s1/posts/p1", strings.NewReader(tt.body))
+			req.Header.Set(UserIDHeader, "u2")
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			var post models.Post
+			json.NewDecoder(w.Body).Decode(&post)
+			if tt.wantBody != "" && post.Body != tt.wantBody {
+				t.Errorf("got body %q, want %q", post.Body, tt.wantBody)
+			}
+		})
+	}
+
+	flags, err := s.ListFlags(t.Context(), "s1", store.FlagPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flags) != 1 || flags[0].ContentID != "p1" || flags[0].ContentType != store.ContentPost {
+		t.Errorf("got flags %+v, want the edited post queued", flags)
+	}
+}
+
+func TestPostHandler_Revisions(t *testing.T) {
+	s, mux := setupPostsTest(t)
+	ctx := t.Context()
+	s.CreatePost(ctx, models.Post{ID: "p1", ServerID: "s1", AuthorID: "u2", Title: "Hello"})
+	title := "Edited"
+	if _, err := s.UpdatePost(ctx, store.PostEdit{ID: "p1", ServerID: "s1", EditorID: "u2", Version: 1, Title: &title}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		caller     string
+		wantStatus int
+	}{
+		{"author", "u2", http.StatusOK},
+		{"moderator", "u1", http.StatusOK},
+		{"anyone else", "u3", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/revisions", nil)
+			req.Header.Set(UserIDHeader, tt.caller)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var revs []models.PostRevision
+			json.NewDecoder(w.Body).Decode(&revs)
+			if len(revs) != 2 || revs[0].Title != "Hello" || revs[1].Title != "Edited" {
+				t.Errorf("got revisions %+v, want the original and the edit", revs)
+			}
+		})
+	}
+}
+
 func TestPostHandler_Delete(t *testing.T) {
 	s, mux := setupPostsTest(t)
-	s.CreatePost(models.Post{ID: "p1", AuthorID: "u1", Title: "Hello"})
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u2", Title: "Hello"})
+	s.CreatePost(t.Context(), models.Post{ID: "p2", ServerID: "s1", AuthorID: "u2", Title: "Second"})
 
-	t.Run("existing post", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodDelete, "/posts/p1", nil)
+	tests := []struct {
+		name       string
+		path       string
+		caller     string
+		wantStatus int
+	}{
+		{"not the author", "/servers/s1/posts/p1", "u3", http.StatusForbidden},
+		{"wrong server", "/servers/s2/posts/p1", "u2", http.StatusNotFound},
+		{"author", "/servers/s1/posts/p1", "u2", http.StatusNoContent},
+		{"moderator", "/servers/s1/posts/p2", "u1", http.StatusNoContent},
+		{"nonexistent post", "/servers/s1/posts/missing", "u2", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, tt.path, nil)
+			req.Header.Set(UserIDHeader, tt.caller)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestPostHandler_Restore(t *testing.T) {
+	s, mux := setupPostsTest(t)
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u2", Title: "Hello"})
+	if err := s.DeletePost(t.Context(), "s1", "p1", "u2"); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, caller string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(UserIDHeader, caller)
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
+		return w
+	}
 
-		if w.Code != http.StatusNoContent {
-			t.Errorf("got status %d, want %d", w.Code, http.StatusNoContent)
+	if w := do(http.MethodGet, "/servers/s1/posts/deleted", "u2"); w.Code != http.StatusForbidden {
+		t.Errorf("list as member: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w := do(http.MethodGet, "/servers/s1/posts/deleted", "u1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("list as moderator: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var deleted []models.Post
+	json.NewDecoder(w.Body).Decode(&deleted)
+	if len(deleted) != 1 || deleted[0].ID != "p1" || deleted[0].DeletedBy != "u2" {
+		t.Errorf("got deleted posts %+v, want p1 deleted by u2", deleted)
+	}
+
+	if w := do(http.MethodPost, "/servers/s1/posts/p1/restore", "u2"); w.Code != http.StatusForbidden {
+		t.Errorf("restore as member: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := do(http.MethodPost, "/servers/s1/posts/p1/restore", "u1"); w.Code != http.StatusOK {
+		t.Errorf("restore as moderator: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := do(http.MethodGet, "/servers/s1/posts/p1", ""); w.Code != http.StatusOK {
+		t.Errorf("get restored post: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := do(http.MethodPost, "/servers/s1/posts/p1/restore", "u1"); w.Code != http.StatusNotFound {
+		t.Errorf("restore live post: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestPostHandler_GetMany(t *testing.T) {
+	s, mux := setupPostsTest(t)
+	mux.HandleFunc("GET /posts", (&PostHandler{Store: s}).GetMany)
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "First"})
+	s.CreatePost(t.Context(), models.Post{ID: "p2", ServerID: "s1", AuthorID: "u1", Title: "Second"})
+
+	t.Run("keeps request order and skips unknown ids", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/posts?ids=p2,missing,p1", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+		var posts []models.Post
+		json.NewDecoder(w.Body).Decode(&posts)
+		if len(posts) != 2 || posts[0].ID != "p2" || posts[1].ID != "p1" {
+			t.Errorf("got %+v, want p2 then p1", posts)
 		}
 	})
 
-	t.Run("nonexistent post", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodDelete, "/posts/missing", nil)
+	t.Run("missing ids", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/posts", nil)
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
-		if w.Code != http.StatusNotFound {
-			t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
 		}
 	})
 }
+
+func TestPostHandler_Conditional(t *testing.T) {
+	s, mux := setupPostsTest(t)
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello"})
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	update := func(ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/servers/s1/posts/p1", strings.NewReader(body))
+		req.Header.Set("If-Match", ifMatch)
+		req.Header.Set(UserIDHeader, "u1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("", "")
+	tag := first.Header().Get("ETag")
+	if tag == "" || first.Header().Get("Last-Modified") == "" {
+		t.Fatalf("expected validators, got %v", first.Header())
+	}
+	if w := get("If-None-Match", tag); w.Code != http.StatusNotModified {
+		t.Errorf("unchanged post: got status %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	w := update(tag, `{"title":"First edit","version":1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("edit with current tag: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	newTag := w.Header().Get("ETag")
+	if newTag == "" || newTag == tag {
+		t.Errorf("expected a new ETag after editing, got %q", newTag)
+	}
+	if w := update(tag, `{"title":"Lost update","version":2}`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("edit with stale tag: got status %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+	if w := get("If-None-Match", tag); w.Code != http.StatusOK {
+		t.Errorf("changed post: got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var post models.Post
+	json.NewDecoder(get("", "").Body).Decode(&post)
+	if post.Title != "First edit" {
+		t.Errorf("got title %q, want %q", post.Title, "First edit")
+	}
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tonitran/dischord/models"
+	"github.com/tonitran/dischord/store"
+)
+
+type ReportHandler struct {
+	Store *store.Database
+}
+
+// reportReasons are the categories a report may be filed under.
+var reportReasons = map[string]bool{"spam": true, "harassment": true, "hate": true, "nsfw": true, "other": true}
+
+const maxReportDetailsLen = 1000
+
+// Create files a report about a post, message or user in the server. The
+// reporter is the calling user, who must be a member.
+func (h *ReportHandler) Create(w http.ResponseWriter, r *http.Request) {
+	serverID := r.PathValue("id")
+	reporter := callerID(r)
+	if reporter == "" {
+		logger.WarnContext(r.Context(), "reports: Create: anonymous caller", "server_id", serverID)
+		http.Error(w, UserIDHeader+" header is required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		TargetType string `json:"target_type"`
+		TargetID   string `json:"target_id"`
+		Reason     string `json:"reason"`
+		Details    string `json:"details"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.ErrorContext(r.Context(), "reports: Create: failed to decode request body", "server_id", serverID, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	switch req.TargetType {
+	case store.ContentPost, store.ContentMessage, store.ContentUser:
+	default:
+		http.Error(w, "target_type must be post, message or user", http.StatusBadRequest)
+		return
+	}
+	if req.TargetID == "" {
+		http.Error(w, "target_id is required", http.StatusBadRequest)
+		return
+	}
+	if !reportReasons[req.Reason] {
+		http.Error(w, "reason must be one of spam, harassment, hate, nsfw or other", http.StatusBadRequest)
+		return
+	}
+	req.Details = strings.TrimSpace(req.Details)
+	if len(req.Details) > maxReportDetailsLen {
+		http.Error(w, "details is too long", http.StatusBadRequest)
+		return
+	}
+
+	member, err := h.Store.IsMember(r.Context(), serverID, reporter)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "reports: Create: membership check failed", "server_id", serverID, "reporter", reporter, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		logger.WarnContext(r.Context(), "reports: Create: reporter is not a member", "server_id", serverID, "reporter", reporter)
+		http.Error(w, "only server members can file reports", http.StatusForbidden)
+		return
+	}
+
+	report := models.Report{
+		ID:         generateID(),
+		ServerID:   serverID,
+		ReporterID: reporter,
+		TargetType: req.TargetType,
+		TargetID:   req.TargetID,
+		Reason:     req.Reason,
+		Details:    req.Details,
+		Status:     store.ReportOpen,
+		CreatedAt:  time.Now(),
+	}
+	if err := h.Store.CreateReport(r.Context(), report); err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, store.ErrDuplicateReport) {
+			status = http.StatusConflict
+		}
+		logger.ErrorContext(r.Context(), "reports: Create: store error", "server_id", serverID, "target_type", req.TargetType, "target_id", req.TargetID, "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger.InfoContext(r.Context(), "reports: Create: report filed", "id", report.ID, "server_id", serverID, "target_type", report.TargetType, "target_id", report.TargetID, "reason", report.Reason)
+	writeJSON(w, http.StatusCreated, report)
+}
+
+// List returns the server's report queue for moderators. It defaults to open
+// reports; ?status=resolved, ?status=dismissed or ?status=all select others.
+func (h *ReportHandler) List(w http.ResponseWriter, r *http.Request) {
+	serverID := r.PathValue("id")
+	if !requireModerator(h.Store, w, r, serverID, "reports: List") {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = store.ReportOpen
+	case "all":
+		status = ""
+	case store.ReportOpen, store.ReportResolved, store.ReportDismissed:
+	default:
+		http.Error(w, "status must be open, resolved, dismissed or all", http.StatusBadRequest)
+		return
+	}
+	reports, err := h.Store.ListReports(r.Context(), serverID, status)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "reports: List: store error", "server_id", serverID, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugContext(r.Context(), "reports: List: success", "server_id", serverID, "count", len(reports))
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// Resolve closes a report. The action is one of dismiss, none (resolve
+// without further action), delete_content, kick or ban; kick and ban apply
+// to the reported user or the author of the reported content.
+func (h *ReportHandler) Resolve(w http.ResponseWriter, r *http.Request) {
+	serverID := r.PathValue("id")
+	reportID := r.PathValue("report_id")
+	if !requireModerator(h.Store, w, r, serverID, "reports: Resolve") {
+		return
+	}
+	var req struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.ErrorContext(r.Context(), "reports: Resolve: failed to decode request body", "report_id", reportID, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	status, action := store.ReportResolved, req.Action
+	switch req.Action {
+	case "dismiss":
+		status, action = store.ReportDismissed, ""
+	case store.ActionNone, store.ActionDeleteContent, store.ActionKick, store.ActionBan:
+	default:
+		http.Error(w, "action must be dismiss, none, delete_content, kick or ban", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.ResolveReport(r.Context(), serverID, reportID, callerID(r), status, action); err != nil {
+		code := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, store.ErrReportNotFound):
+			code = http.StatusNotFound
+		case errors.Is(err, store.ErrInvalidReportTarget):
+			code = http.StatusBadRequest
+		case errors.Is(err, store.ErrOutranked):
+			code = http.StatusForbidden
+		}
+		logger.ErrorContext(r.Context(), "reports: Resolve: store error", "server_id", serverID, "report_id", reportID, "action", req.Action, "error", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+	logger.InfoContext(r.Context(), "reports: Resolve: report closed", "server_id", serverID, "report_id", reportID, "status", status, "action", action, "caller", callerID(r))
+	writeJSON(w, http.StatusOK, map[string]string{"status": status, "action": action})
+}
+
+// Counts summarizes open and closed reports and pending flags for moderators.
+func (h *ReportHandler) Counts(w http.ResponseWriter, r *http.Request) {
+	serverID := r.PathValue("id")
+	if !requireModerator(h.Store, w, r, serverID, "reports: Counts") {
+		return
+	}
+	counts, err := h.Store.GetReportCounts(r.Context(), serverID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "reports: Counts: store error", "server_id", serverID, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugContext(r.Context(), "reports: Counts: success", "server_id", serverID, "open", counts.Open)
+	writeJSON(w, http.StatusOK, counts)
+}
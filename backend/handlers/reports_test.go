@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tonitran/dischord/models"
+	"github.com/tonitran/dischord/store"
+)
+
+func setupReportsTest(t *testing.T) (*store.Database, *http.ServeMux) {
+	s := testStore(t)
+	h := &ReportHandler{Store: s}
+	servers := &ServerHandler{Store: s}
+
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u3", Username: "carol", Email: "c@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1"})
+	s.JoinServer(t.Context(), "s1", "u1")
+	s.JoinServer(t.Context(), "s1", "u2")
+	s.JoinServer(t.Context(), "s1", "u3")
+	s.CreateMessage(t.Context(), models.Message{ID: "m1", ServerID: "s1", AuthorID: "u3", Content: "buy now", CreatedAt: time.Now()}, 0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /servers/{id}/reports", h.Create)
+	mux.HandleFunc("GET /servers/{id}/reports", h.List)
+	mux.HandleFunc("GET /servers/{id}/reports/counts", h.Counts)
+	mux.HandleFunc("POST /servers/{id}/reports/{report_id}/resolve", h.Resolve)
+	mux.HandleFunc("POST /servers/{id}/members", servers.Join)
+	return s, mux
+}
+
+func fileReport(t *testing.T, mux *http.ServeMux, caller, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/servers/s1/reports", strings.NewReader(body))
+	req.Header.Set(UserIDHeader, caller)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestReportHandler_Create(t *testing.T) {
+	_, mux := setupReportsTest(t)
+
+	tests := []struct {
+		name       string
+		caller     string
+		body       string
+		wantStatus int
+	}{
+		{name: "report message", caller: "u2", body: `{"target_type":"message","target_id":"m1","reason":"spam"}`, wantStatus: http.StatusCreated},
+		{name: "duplicate open report", caller: "u2", body: `{"target_type":"message","target_id":"m1","reason":"other"}`, wantStatus: http.StatusConflict},
+		{name: "report user", caller: "u2", body: `{"target_type":"user","target_id":"u3","reason":"harassment","details":"see DMs"}`, wantStatus: http.StatusCreated},
+		{name: "unknown target", caller: "u2", body: `{"target_type":"post","target_id":"nope","reason":"spam"}`, wantStatus: http.StatusNotFound},
+		{name: "invalid reason", caller: "u2", body: `{"target_type":"message","target_id":"m1","reason":"boring"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid target type", caller: "u2", body: `{"target_type":"server","target_id":"s1","reason":"spam"}`, wantStatus: http.StatusBadRequest},
+		{name: "anonymous caller", caller: "", body: `{"target_type":"message","target_id":"m1","reason":"spam"}`, wantStatus: http.StatusUnauthorized},
+		{name: "non-member", caller: "ghost", body: `{"target_type":"message","target_id":"m1","reason":"spam"}`, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := fileReport(t, mux, tt.caller, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReportHandler_ResolveBan(t *testing.T) {
+	s, mux := setupReportsTest(t)
+
+	w := fileReport(t, mux, "u2", `{"target_type":"message","target_id":"m1","reason":"spam"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("file report: got status %d: %s", w.Code, w.Body.String())
+	}
+	var report models.Report
+	json.NewDecoder(w.Body).Decode(&report)
+
+	// Members cannot see or act on the queue.
+	req := httptest.NewRequest(http.MethodGet, "/servers/s1/reports", nil)
+	req.Header.Set(UserIDHeader, "u2")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("member list: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/servers/s1/reports", nil)
+	req.Header.Set(UserIDHeader, "u1")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var open []models.Report
+	json.NewDecoder(w.Body).Decode(&open)
+	if w.Code != http.StatusOK || len(open) != 1 {
+		t.Fatalf("owner list: got status %d and %d reports, want 200 and 1", w.Code, len(open))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/servers/s1/reports/"+report.ID+"/resolve", strings.NewReader(`{"action":"ban"}`))
+	req.Header.Set(UserIDHeader, "u1")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("resolve: got status %d: %s", w.Code, w.Body.String())
+	}
+
+	// A report can only be closed once.
+	req = httptest.NewRequest(http.MethodPost, "/servers/s1/reports/"+report.ID+"/resolve", strings.NewReader(`{"action":"dismiss"}`))
+	req.Header.Set(UserIDHeader, "u1")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("re-resolve: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	members, _ := s.GetServerMembers(t.Context(), "s1")
+	for _, m := range members {
+		if m.ID == "u3" {
+			t.Error("banned user is still a member")
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/servers/s1/members", strings.NewReader(`{"user_id":"u3"}`))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("banned rejoin: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	counts, err := s.GetReportCounts(t.Context(), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Open != 0 || counts.Resolved != 1 {
+		t.Errorf("unexpected counts %+v", counts)
+	}
+}
+
+func TestReportHandler_ResolveOutranked(t *testing.T) {
+	s, mux := setupReportsTest(t)
+	s.CreateMessage(t.Context(), models.Message{ID: "m2", ServerID: "s1", AuthorID: "u1", Content: "hello", CreatedAt: time.Now()}, 0)
+
+	w := fileReport(t, mux, "u2", `{"target_type":"message","target_id":"m2","reason":"spam"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("file report: got status %d: %s", w.Code, w.Body.String())
+	}
+	var report models.Report
+	json.NewDecoder(w.Body).Decode(&report)
+
+	// The owner cannot ban themselves through a report against their own message.
+	req := httptest.NewRequest(http.MethodPost, "/servers/s1/reports/"+report.ID+"/resolve", strings.NewReader(`{"action":"ban"}`))
+	req.Header.Set(UserIDHeader, "u1")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("self ban: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	members, _ := s.GetServerMembers(t.Context(), "s1")
+	found := false
+	for _, m := range members {
+		found = found || m.ID == "u1"
+	}
+	if !found {
+		t.Error("owner was removed from the server")
+	}
+}
+
+func TestReportHandler_ResolveInvalidTarget(t *testing.T) {
+	_, mux := setupReportsTest(t)
+
+	w := fileReport(t, mux, "u2", `{"target_type":"user","target_id":"u3","reason":"harassment"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("file report: got status %d: %s", w.Code, w.Body.String())
+	}
+	var report models.Report
+	json.NewDecoder(w.Body).Decode(&report)
+
+	resolve := func(id, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/servers/s1/reports/"+id+"/resolve", strings.NewReader(body))
+		req.Header.Set(UserIDHeader, "u1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := resolve(report.ID, `{"action":"delete_content"}`); code != http.StatusBadRequest {
+		t.Errorf("delete user: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := resolve("missing", `{"action":"dismiss"}`); code != http.StatusNotFound {
+		t.Errorf("unknown report: got status %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -2,8 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/tonitran/dischord/models"
 	"github.com/tonitran/dischord/store"
@@ -15,54 +21,223 @@ type ServerHandler struct {
 
 func (h *ServerHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Name    string `json:"name"`
-		OwnerID string `json:"owner_id"`
+		Name        string   `json:"name"`
+		OwnerID     string   `json:"owner_id"`
+		Description string   `json:"description"`
+		IconURL     string   `json:"icon_url"`
+		Tags        []string `json:"tags"`
+		Visibility  string   `json:"visibility"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("servers: Create: failed to decode request body", "error", err)
+		logger.ErrorContext(r.Context(), "servers: Create: failed to decode request body", "error", err)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	req.Name = strings.TrimSpace(req.Name)
 	if req.Name == "" || req.OwnerID == "" {
-		logger.Warn("servers: Create: missing required fields", "name", req.Name, "owner_id", req.OwnerID)
+		logger.WarnContext(r.Context(), "servers: Create: missing required fields", "name", req.Name, "owner_id", req.OwnerID)
 		http.Error(w, "name and owner_id are required", http.StatusBadRequest)
 		return
 	}
 
+	owner, err := h.Store.GetUser(r.Context(), req.OwnerID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "servers: Create: owner not found", "owner_id", req.OwnerID, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !owner.EmailVerified {
+		logger.WarnContext(r.Context(), "servers: Create: owner email not verified", "owner_id", req.OwnerID)
+		http.Error(w, "verify your email address before creating a server", http.StatusForbidden)
+		return
+	}
+
 	srv := models.Server{
-		ID:        generateID(),
-		Name:      req.Name,
-		OwnerID:   req.OwnerID,
-		MemberIDs: []string{req.OwnerID},
-		CreatedAt: time.Now(),
-	}
-	if err := h.Store.CreateServer(srv); err != nil {
-		logger.Error("servers: Create: store error", "name", req.Name, "owner_id", req.OwnerID, "error", err)
-		http.Error(w, err.Error(), http.StatusConflict)
+		ID:          generateID(),
+		Name:        req.Name,
+		Description: strings.TrimSpace(req.Description),
+		IconURL:     strings.TrimSpace(req.IconURL),
+		Tags:        normalizeTags(req.Tags),
+		Visibility:  req.Visibility,
+		OwnerID:     req.OwnerID,
+		MemberIDs:   []string{req.OwnerID},
+		CreatedAt:   time.Now(),
+	}
+	if srv.Visibility == "" {
+		srv.Visibility = store.VisibilityPrivate
+	}
+	if err := validateServerSettings(srv); err != nil {
+		logger.WarnContext(r.Context(), "servers: Create: invalid settings", "name", req.Name, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := h.Store.JoinServer(srv.ID, srv.OwnerID); err != nil {
-		logger.Error("servers: Create: failed to auto-join owner", "server_id", srv.ID, "owner_id", srv.OwnerID, "error", err)
+	if err := h.Store.CreateServer(r.Context(), srv); err != nil {
+		logger.ErrorContext(r.Context(), "servers: Create: store error", "name", req.Name, "owner_id", req.OwnerID, "error", err)
 		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
-	logger.Info("servers: Create: server created", "id", srv.ID, "name", srv.Name, "owner_id", srv.OwnerID)
+	logger.InfoContext(r.Context(), "servers: Create: server created", "id", srv.ID, "name", srv.Name, "owner_id", srv.OwnerID)
 	writeJSON(w, http.StatusCreated, srv)
 }
 
 func (h *ServerHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	logger.Debug("servers: Get: request", "id", id)
-	srv, err := h.Store.GetServer(id)
+	logger.DebugContext(r.Context(), "servers: Get: request", "id", id)
+	srv, err := h.Store.GetServer(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "servers: Get: not found", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if notModified(w, r, etag(srv.UpdatedAt, ""), srv.UpdatedAt) {
+		logger.DebugContext(r.Context(), "servers: Get: not modified", "id", id)
+		return
+	}
+	logger.DebugContext(r.Context(), "servers: Get: success", "id", id, "name", srv.Name)
+	writeJSON(w, http.StatusOK, srv)
+}
+
+// maxDiscoverResults caps the servers returned by Discover.
+const maxDiscoverResults = 50
+
+// List serves GET /servers: the servers named in ?ids= if it is present,
+// otherwise discovery of public servers.
+func (h *ServerHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("ids") {
+		h.GetMany(w, r)
+		return
+	}
+	h.Discover(w, r)
+}
+
+// Discover lists public servers, busiest first. ?query= matches the name or
+// description and ?tag= a tag; both are optional.
+func (h *ServerHandler) Discover(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("query"))
+	tag := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag")))
+	servers, err := h.Store.DiscoverServers(r.Context(), query, tag, maxDiscoverResults)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "servers: Discover: store error", "query", query, "tag", tag, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugContext(r.Context(), "servers: Discover: success", "query", query, "tag", tag, "count", len(servers))
+	writeJSON(w, http.StatusOK, servers)
+}
+
+// GetMany returns the servers listed in ?ids=, in that order. Unknown IDs
+// are left out.
+func (h *ServerHandler) GetMany(w http.ResponseWriter, r *http.Request) {
+	ids, err := batchIDs(r)
+	if err != nil {
+		logger.WarnContext(r.Context(), "servers: GetMany: invalid ids", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	servers, err := h.Store.GetServers(r.Context(), ids)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "servers: GetMany: store error", "count", len(ids), "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugContext(r.Context(), "servers: GetMany: success", "requested", len(ids), "found", len(servers))
+	writeJSON(w, http.StatusOK, servers)
+}
+
+// Update changes a server's settings. Only fields present in the request
+// body are modified. Only the owner and admins may change them.
+func (h *ServerHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !requireAdmin(h.Store, w, r, id, "servers: Update") {
+		return
+	}
+	srv, err := h.Store.GetServer(r.Context(), id)
 	if err != nil {
-		logger.Error("servers: Get: not found", "id", id, "error", err)
+		logger.ErrorContext(r.Context(), "servers: Update: not found", "id", id, "error", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	logger.Debug("servers: Get: success", "id", id, "name", srv.Name)
+
+	var req struct {
+		Name        *string   `json:"name"`
+		Description *string   `json:"description"`
+		IconURL     *string   `json:"icon_url"`
+		Tags        *[]string `json:"tags"`
+		Visibility  *string   `json:"visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.ErrorContext(r.Context(), "servers: Update: failed to decode request body", "id", id, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	// Only the provided fields are written, so a concurrent update to other
+	// fields isn't lost. Each field is checked on its own, so validating
+	// them against the server as last read is enough.
+	patch := store.ServerSettings{ID: id}
+	if req.Name != nil {
+		srv.Name = strings.TrimSpace(*req.Name)
+		patch.Name = &srv.Name
+	}
+	if req.Description != nil {
+		srv.Description = strings.TrimSpace(*req.Description)
+		patch.Description = &srv.Description
+	}
+	if req.IconURL != nil {
+		srv.IconURL = strings.TrimSpace(*req.IconURL)
+		patch.IconURL = &srv.IconURL
+	}
+	if req.Tags != nil {
+		srv.Tags = normalizeTags(*req.Tags)
+		patch.Tags = &srv.Tags
+	}
+	if req.Visibility != nil {
+		srv.Visibility = *req.Visibility
+		patch.Visibility = &srv.Visibility
+	}
+	if err := validateServerSettings(srv); err != nil {
+		logger.WarnContext(r.Context(), "servers: Update: invalid settings", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.UpdateServerSettings(r.Context(), patch); err != nil {
+		logger.ErrorContext(r.Context(), "servers: Update: store error", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if srv, err = h.Store.GetServer(r.Context(), id); err != nil {
+		logger.ErrorContext(r.Context(), "servers: Update: reload failed", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.InfoContext(r.Context(), "servers: Update: settings updated", "id", id, "caller", callerID(r))
 	writeJSON(w, http.StatusOK, srv)
 }
 
+// Delete removes a server and everything in it. Only the owner may delete
+// a server.
+func (h *ServerHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	srv, err := h.Store.GetServer(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "servers: Delete: not found", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if caller := callerID(r); caller != srv.OwnerID {
+		logger.WarnContext(r.Context(), "servers: Delete: forbidden", "id", id, "caller", caller)
+		http.Error(w, "only the owner can delete a server", http.StatusForbidden)
+		return
+	}
+	if err := h.Store.DeleteServer(r.Context(), id); err != nil {
+		logger.ErrorContext(r.Context(), "servers: Delete: store error", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.InfoContext(r.Context(), "servers: Delete: server deleted", "id", id, "name", srv.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *ServerHandler) Join(w http.ResponseWriter, r *http.Request) {
 	serverID := r.PathValue("id")
 
@@ -70,33 +245,159 @@ func (h *ServerHandler) Join(w http.ResponseWriter, r *http.Request) {
 		UserID string `json:"user_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("servers: Join: failed to decode request body", "server_id", serverID, "error", err)
+		logger.ErrorContext(r.Context(), "servers: Join: failed to decode request body", "server_id", serverID, "error", err)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 	if req.UserID == "" {
-		logger.Warn("servers: Join: missing user_id", "server_id", serverID)
+		logger.WarnContext(r.Context(), "servers: Join: missing user_id", "server_id", serverID)
 		http.Error(w, "user_id is required", http.StatusBadRequest)
 		return
 	}
-	if err := h.Store.JoinServer(serverID, req.UserID); err != nil {
-		logger.Error("servers: Join: store error", "server_id", serverID, "user_id", req.UserID, "error", err)
-		http.Error(w, err.Error(), http.StatusNotFound)
+	if err := h.Store.JoinServer(r.Context(), serverID, req.UserID); err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, store.ErrBanned) {
+			status = http.StatusForbidden
+		}
+		logger.ErrorContext(r.Context(), "servers: Join: store error", "server_id", serverID, "user_id", req.UserID, "error", err)
+		http.Error(w, err.Error(), status)
 		return
 	}
-	logger.Info("servers: Join: user joined server", "server_id", serverID, "user_id", req.UserID)
+	logger.InfoContext(r.Context(), "servers: Join: user joined server", "server_id", serverID, "user_id", req.UserID)
 	writeJSON(w, http.StatusOK, map[string]string{"status": "joined"})
 }
 
 func (h *ServerHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
 	serverID := r.PathValue("id")
-	logger.Debug("servers: ListMembers: request", "server_id", serverID)
-	members, err := h.Store.GetServerMembers(serverID)
+	logger.DebugContext(r.Context(), "servers: ListMembers: request", "server_id", serverID)
+	members, err := h.Store.GetServerMembers(r.Context(), serverID)
 	if err != nil {
-		logger.Error("servers: ListMembers: store error", "server_id", serverID, "error", err)
+		logger.ErrorContext(r.Context(), "servers: ListMembers: store error", "server_id", serverID, "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	logger.Debug("servers: ListMembers: success", "server_id", serverID, "count", len(members))
+	logger.DebugContext(r.Context(), "servers: ListMembers: success", "server_id", serverID, "count", len(members))
 	writeJSON(w, http.StatusOK, members)
 }
+
+// SetMemberRole gives a member the moderator, admin or plain member role.
+// Only the owner and admins may, and only for members and roles below their
+// own.
+func (h *ServerHandler) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	serverID, userID := r.PathValue("id"), r.PathValue("user_id")
+	if !requireAdmin(h.Store, w, r, serverID, "servers: SetMemberRole") {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.ErrorContext(r.Context(), "servers: SetMemberRole: failed to decode request body", "server_id", serverID, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	switch req.Role {
+	case store.RoleMember, store.RoleModerator, store.RoleAdmin:
+	default:
+		logger.WarnContext(r.Context(), "servers: SetMemberRole: invalid role", "server_id", serverID, "role", req.Role)
+		http.Error(w, "role must be member, moderator or admin", http.StatusBadRequest)
+		return
+	}
+	if err := h.Store.SetMemberRole(r.Context(), serverID, callerID(r), userID, req.Role); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, store.ErrNotMember):
+			status = http.StatusNotFound
+		case errors.Is(err, store.ErrRoleOutranked):
+			status = http.StatusForbidden
+		}
+		logger.ErrorContext(r.Context(), "servers: SetMemberRole: store error", "server_id", serverID, "user_id", userID, "role", req.Role, "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger.InfoContext(r.Context(), "servers: SetMemberRole: role changed", "server_id", serverID, "user_id", userID, "role", req.Role, "caller", callerID(r))
+	writeJSON(w, http.StatusOK, map[string]string{"user_id": userID, "role": req.Role})
+}
+
+// maxSlowModeSeconds caps slow mode at six hours.
+const maxSlowModeSeconds = 6 * 60 * 60
+
+// SetSlowMode lets a moderator set how long members must wait between
+// messages in the server.
+func (h *ServerHandler) SetSlowMode(w http.ResponseWriter, r *http.Request) {
+	serverID := r.PathValue("id")
+	if !requireModerator(h.Store, w, r, serverID, "servers: SetSlowMode") {
+		return
+	}
+
+	var req struct {
+		Seconds int `json:"seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.ErrorContext(r.Context(), "servers: SetSlowMode: failed to decode request body", "server_id", serverID, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Seconds < 0 || req.Seconds > maxSlowModeSeconds {
+		logger.WarnContext(r.Context(), "servers: SetSlowMode: out of range", "server_id", serverID, "seconds", req.Seconds)
+		http.Error(w, fmt.Sprintf("seconds must be between 0 and %d", maxSlowModeSeconds), http.StatusBadRequest)
+		return
+	}
+	if err := h.Store.SetSlowMode(r.Context(), serverID, req.Seconds); err != nil {
+		logger.ErrorContext(r.Context(), "servers: SetSlowMode: store error", "server_id", serverID, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.InfoContext(r.Context(), "servers: SetSlowMode: updated", "server_id", serverID, "seconds", req.Seconds, "caller", callerID(r))
+	writeJSON(w, http.StatusOK, map[string]int{"slow_mode_seconds": req.Seconds})
+}
+
+// Server settings limits.
+const (
+	maxServerNameLen        = 100
+	maxServerDescriptionLen = 1000
+	maxServerTags           = 10
+	maxServerTagLen         = 32
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// normalizeTags trims and lowercases tags, dropping empty and repeated ones.
+func normalizeTags(tags []string) []string {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+func validateServerSettings(srv models.Server) error {
+	if srv.Name == "" {
+		return errors.New("name is required")
+	}
+	if utf8.RuneCountInString(srv.Name) > maxServerNameLen {
+		return fmt.Errorf("name must be at most %d characters", maxServerNameLen)
+	}
+	if utf8.RuneCountInString(srv.Description) > maxServerDescriptionLen {
+		return fmt.Errorf("description must be at most %d characters", maxServerDescriptionLen)
+	}
+	if srv.IconURL != "" && !isHTTPURL(srv.IconURL) {
+		return errors.New("icon_url must be an http or https URL")
+	}
+	if len(srv.Tags) > maxServerTags {
+		return fmt.Errorf("a server can have at most %d tags", maxServerTags)
+	}
+	for _, tag := range srv.Tags {
+		if len(tag) > maxServerTagLen || !tagPattern.MatchString(tag) {
+			return fmt.Errorf("tag %q must be at most %d letters, digits and '-'", tag, maxServerTagLen)
+		}
+	}
+	if srv.Visibility != store.VisibilityPublic && srv.Visibility != store.VisibilityPrivate {
+		return errors.New("visibility must be public or private")
+	}
+	return nil
+}
@@ -25,7 +25,8 @@ func setupServersTest(t *testing.T) (*store.Database, *http.ServeMux) {
 
 func TestServerHandler_Create(t *testing.T) {
 	s, mux := setupServersTest(t)
-	s.CreateUser(models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com", EmailVerified: true})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
 
 	tests := []struct {
 		name       string
@@ -37,6 +38,16 @@ func TestServerHandler_Create(t *testing.T) {
 			body:       `{"name":"general","owner_id":"u1"}`,
 			wantStatus: http.StatusCreated,
 		},
+		{
+			name:       "unverified owner",
+			body:       `{"name":"general","owner_id":"u2"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "nonexistent owner",
+			body:       `{"name":"general","owner_id":"missing"}`,
+			wantStatus: http.StatusNotFound,
+		},
 		{
 			name:       "missing name",
 			body:       `{"owner_id":"u1"}`,
@@ -82,8 +93,8 @@ func TestServerHandler_Create(t *testing.T) {
 
 func TestServerHandler_Join(t *testing.T) {
 	s, mux := setupServersTest(t)
-	s.CreateUser(models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
-	s.CreateServer(models.Server{ID: "s1", Name: "general", OwnerID: "u1"})
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1"})
 
 	tests := []struct {
 		name       string
@@ -144,11 +155,11 @@ func TestServerHandler_Join(t *testing.T) {
 
 func TestServerHandler_ListMembers(t *testing.T) {
 	s, mux := setupServersTest(t)
-	s.CreateUser(models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
-	s.CreateUser(models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
-	s.CreateServer(models.Server{ID: "s1", Name: "general", OwnerID: "u1"})
-	s.JoinServer("s1", "u1")
-	s.JoinServer("s1", "u2")
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1"})
+	s.JoinServer(t.Context(), "s1", "u1")
+	s.JoinServer(t.Context(), "s1", "u2")
 
 	t.Run("lists all members", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/servers/s1/members", nil)
@@ -166,7 +177,7 @@ func TestServerHandler_ListMembers(t *testing.T) {
 	})
 
 	t.Run("server with no members returns empty list", func(t *testing.T) {
-		s.CreateServer(models.Server{ID: "s2", Name: "empty", OwnerID: "u1"})
+		s.CreateServer(t.Context(), models.Server{ID: "s2", Name: "empty", OwnerID: "u1"})
 
 		req := httptest.NewRequest(http.MethodGet, "/servers/s2/members", nil)
 		w := httptest.NewRecorder()
@@ -185,7 +196,8 @@ func TestServerHandler_ListMembers(t *testing.T) {
 
 func TestServerHandler_Get(t *testing.T) {
 	s, mux := setupServersTest(t)
-	s.CreateServer(models.Server{ID: "s1", Name: "general", OwnerID: "u1", MemberIDs: []string{"u1"}})
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1", MemberIDs: []string{"u1"}})
 
 	t.Run("existing server", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/servers/s1", nil)
@@ -212,3 +224,257 @@ func TestServerHandler_Get(t *testing.T) {
 		}
 	})
 }
+
+func TestServerHandler_SetSlowMode(t *testing.T) {
+	s, mux := setupServersTest(t)
+	mux.HandleFunc("PUT /servers/{id}/slow-mode", (&ServerHandler{Store: s}).SetSlowMode)
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1"})
+	s.JoinServer(t.Context(), "s1", "u2")
+
+	tests := []struct {
+		name       string
+		caller     string
+		body       string
+		wantStatus int
+	}{
+		{name: "owner enables", caller: "u1", body: `{"seconds":30}`, wantStatus: http.StatusOK},
+		{name: "member forbidden", caller: "u2", body: `{"seconds":0}`, wantStatus: http.StatusForbidden},
+		{name: "too long", caller: "u1", body: `{"seconds":999999}`, wantStatus: http.StatusBadRequest},
+		{name: "negative", caller: "u1", body: `{"seconds":-1}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid json", caller: "u1", body: `{bad`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/servers/s1/slow-mode", strings.NewReader(tt.body))
+			req.Header.Set(UserIDHeader, tt.caller)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	srv, err := s.GetServer(t.Context(), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.SlowModeSeconds != 30 {
+		t.Errorf("got slow mode %d, want 30", srv.SlowModeSeconds)
+	}
+}
+
+func TestServerHandler_SetMemberRole(t *testing.T) {
+	s, mux := setupServersTest(t)
+	mux.HandleFunc("PUT /servers/{id}/members/{user_id}/role", (&ServerHandler{Store: s}).SetMemberRole)
+	for _, u := range []models.User{
+		{ID: "u1", Username: "alice", Email: "a@example.com"},
+		{ID: "u2", Username: "bob", Email: "b@example.com"},
+		{ID: "u3", Username: "carol", Email: "c@example.com"},
+		{ID: "u4", Username: "dave", Email: "d@example.com"},
+	} {
+		s.CreateUser(t.Context(), u)
+	}
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1", MemberIDs: []string{"u1", "u2", "u3", "u4"}})
+
+	tests := []struct {
+		name       string
+		caller     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{name: "owner makes admin", caller: "u1", target: "u2", body: `{"role":"admin"}`, wantStatus: http.StatusOK},
+		{name: "member forbidden", caller: "u3", target: "u4", body: `{"role":"moderator"}`, wantStatus: http.StatusForbidden},
+		{name: "admin makes moderator", caller: "u2", target: "u3", body: `{"role":"moderator"}`, wantStatus: http.StatusOK},
+		{name: "admin cannot grant admin", caller: "u2", target: "u4", body: `{"role":"admin"}`, wantStatus: http.StatusForbidden},
+		{name: "admin cannot demote owner", caller: "u2", target: "u1", body: `{"role":"member"}`, wantStatus: http.StatusForbidden},
+		{name: "admin cannot change self", caller: "u2", target: "u2", body: `{"role":"moderator"}`, wantStatus: http.StatusForbidden},
+		{name: "moderator cannot change roles", caller: "u3", target: "u4", body: `{"role":"moderator"}`, wantStatus: http.StatusForbidden},
+		{name: "non-member target", caller: "u1", target: "ghost", body: `{"role":"moderator"}`, wantStatus: http.StatusNotFound},
+		{name: "unknown role", caller: "u1", target: "u4", body: `{"role":"king"}`, wantStatus: http.StatusBadRequest},
+		{name: "admin revokes moderator", caller: "u2", target: "u3", body: `{"role":"member"}`, wantStatus: http.StatusOK},
+		{name: "owner makes moderator", caller: "u1", target: "u4", body: `{"role":"moderator"}`, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/servers/s1/members/"+tt.target+"/role", strings.NewReader(tt.body))
+			req.Header.Set(UserIDHeader, tt.caller)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	for _, c := range []struct {
+		user    string
+		wantMod bool
+	}{{"u2", true}, {"u3", false}, {"u4", true}} {
+		if isMod, _ := s.IsModerator(t.Context(), "s1", c.user); isMod != c.wantMod {
+			t.Errorf("%s: got moderator %v, want %v", c.user, isMod, c.wantMod)
+		}
+	}
+	if isAdmin, _ := s.IsAdmin(t.Context(), "s1", "u2"); !isAdmin {
+		t.Error("expected u2 to be an admin")
+	}
+}
+
+func TestServerHandler_GetMany(t *testing.T) {
+	s, mux := setupServersTest(t)
+	mux.HandleFunc("GET /servers", (&ServerHandler{Store: s}).List)
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1", MemberIDs: []string{"u1", "u2"}})
+	s.CreateServer(t.Context(), models.Server{ID: "s2", Name: "random", OwnerID: "u2", MemberIDs: []string{"u2"}})
+
+	req := httptest.NewRequest(http.MethodGet, "/servers?ids=s2,s1,missing", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var servers []models.Server
+	json.NewDecoder(w.Body).Decode(&servers)
+	if len(servers) != 2 || servers[0].ID != "s2" || servers[1].ID != "s1" {
+		t.Fatalf("got %+v, want s2 then s1", servers)
+	}
+	if len(servers[1].MemberIDs) != 2 {
+		t.Errorf("got members %v, want u1 and u2", servers[1].MemberIDs)
+	}
+}
+
+func TestServerHandler_Update(t *testing.T) {
+	s, mux := setupServersTest(t)
+	mux.HandleFunc("PATCH /servers/{id}", (&ServerHandler{Store: s}).Update)
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1", MemberIDs: []string{"u1", "u2"}})
+
+	tests := []struct {
+		name       string
+		caller     string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "owner updates",
+			caller:     "u1",
+			body:       `{"description":"All things Go","icon_url":"https://example.com/go.png","tags":["Go"," gaming ","go"],"visibility":"public"}`,
+			wantStatus: http.StatusOK,
+		},
+		{name: "member forbidden", caller: "u2", body: `{"name":"mine"}`, wantStatus: http.StatusForbidden},
+		{name: "empty name", caller: "u1", body: `{"name":" "}`, wantStatus: http.StatusBadRequest},
+		{name: "bad visibility", caller: "u1", body: `{"visibility":"secret"}`, wantStatus: http.StatusBadRequest},
+		{name: "bad icon", caller: "u1", body: `{"icon_url":"javascript:alert(1)"}`, wantStatus: http.StatusBadRequest},
+		{name: "bad tag", caller: "u1", body: `{"tags":["no spaces"]}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid json", caller: "u1", body: `{bad`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/servers/s1", strings.NewReader(tt.body))
+			req.Header.Set(UserIDHeader, tt.caller)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	srv, err := s.GetServer(t.Context(), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Name != "general" || srv.Description != "All things Go" || srv.Visibility != store.VisibilityPublic ||
+		strings.Join(srv.Tags, ",") != "go,gaming" {
+		t.Errorf("got %+v, want only the owner's update applied", srv)
+	}
+}
+
+func TestServerHandler_Delete(t *testing.T) {
+	s, mux := setupServersTest(t)
+	mux.HandleFunc("DELETE /servers/{id}", (&ServerHandler{Store: s}).Delete)
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1", MemberIDs: []string{"u1", "u2"}})
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u2", Title: "Hello"})
+
+	tests := []struct {
+		name       string
+		caller     string
+		id         string
+		wantStatus int
+	}{
+		{name: "member forbidden", caller: "u2", id: "s1", wantStatus: http.StatusForbidden},
+		{name: "owner deletes", caller: "u1", id: "s1", wantStatus: http.StatusNoContent},
+		{name: "already gone", caller: "u1", id: "s1", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/servers/"+tt.id, nil)
+			req.Header.Set(UserIDHeader, tt.caller)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	if _, err := s.GetPost(t.Context(), "s1", "p1"); err == nil {
+		t.Error("expected the server's posts to be deleted with it")
+	}
+	if u, err := s.GetUser(t.Context(), "u2"); err != nil || len(u.ServerIDs) != 0 {
+		t.Errorf("got %+v, %v, want u2 to be in no servers", u, err)
+	}
+}
+
+func TestServerHandler_Discover(t *testing.T) {
+	s, mux := setupServersTest(t)
+	mux.HandleFunc("GET /servers", (&ServerHandler{Store: s}).List)
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "Gophers", Tags: []string{"go"}, Visibility: store.VisibilityPublic, OwnerID: "u1", MemberIDs: []string{"u1"}})
+	s.CreateServer(t.Context(), models.Server{ID: "s2", Name: "Go games", Description: "Board games", Tags: []string{"go", "games"}, Visibility: store.VisibilityPublic, OwnerID: "u1", MemberIDs: []string{"u1", "u2"}})
+	s.CreateServer(t.Context(), models.Server{ID: "s3", Name: "Go secrets", Tags: []string{"go"}, OwnerID: "u1", MemberIDs: []string{"u1", "u2"}})
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "all public by members", query: "", want: "s2,s1"},
+		{name: "name or description", query: "?query=BOARD", want: "s2"},
+		{name: "tag", query: "?tag=games", want: "s2"},
+		{name: "no match", query: "?query=go&tag=chess", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/servers"+tt.query, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+			}
+			var servers []models.ServerSummary
+			json.NewDecoder(w.Body).Decode(&servers)
+			var ids []string
+			for _, srv := range servers {
+				ids = append(ids, srv.ID)
+			}
+			if got := strings.Join(ids, ","); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
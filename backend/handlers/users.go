@@ -1,64 +1,481 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/tonitran/dischord/mailer"
 	"github.com/tonitran/dischord/models"
 	"github.com/tonitran/dischord/store"
 )
 
 type UserHandler struct {
 	Store *store.Database
+	// Mailer delivers verification emails. Nil logs them instead.
+	Mailer mailer.Sender
 }
 
+// verificationTTL is how long an email verification token stays valid.
+const verificationTTL = 24 * time.Hour
+
 func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Username string `json:"username"`
 		Email    string `json:"email"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("users: Create: failed to decode request body", "error", err)
+		logger.ErrorContext(r.Context(), "users: Create: failed to decode request body", "error", err)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 	if req.Username == "" || req.Email == "" {
-		logger.Warn("users: Create: missing required fields", "username", req.Username, "email", req.Email)
+		logger.WarnContext(r.Context(), "users: Create: missing required fields", "username", req.Username, "email", redactEmail(req.Email))
 		http.Error(w, "username and email are required", http.StatusBadRequest)
 		return
 	}
+	if err := validateUsername(req.Username); err != nil {
+		logger.WarnContext(r.Context(), "users: Create: invalid username", "username", req.Username, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		logger.WarnContext(r.Context(), "users: Create: invalid email", "email", redactEmail(req.Email), "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	user := models.User{
 		ID:        generateID(),
 		Username:  req.Username,
-		Email:     req.Email,
+		Email:     email,
 		CreatedAt: time.Now(),
 	}
-	if err := h.Store.CreateUser(user); err != nil {
-		logger.Error("users: Create: store error", "username", req.Username, "email", req.Email, "error", err)
+	if err := h.Store.CreateUser(r.Context(), user); err != nil {
+		logger.ErrorContext(r.Context(), "users: Create: store error", "username", req.Username, "email", redactEmail(email), "error", err)
 		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
-	logger.Info("users: Create: user created", "id", user.ID, "username", user.Username)
+	logger.InfoContext(r.Context(), "users: Create: user created", "id", user.ID, "username", user.Username)
+	if err := h.sendVerification(r.Context(), user); err != nil {
+		// The account exists either way; the user can ask for another email.
+		logger.ErrorContext(r.Context(), "users: Create: failed to send verification email", "id", user.ID, "error", err)
+	}
 	writeJSON(w, http.StatusCreated, user)
 }
 
+// VerifyEmail confirms a user's email address with the token they were sent.
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.ErrorContext(r.Context(), "users: VerifyEmail: failed to decode request body", "id", id, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		logger.WarnContext(r.Context(), "users: VerifyEmail: missing token", "id", id)
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	if err := h.Store.VerifyEmail(r.Context(), id, req.Token); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, store.ErrInvalidToken) {
+			status = http.StatusBadRequest
+		}
+		logger.WarnContext(r.Context(), "users: VerifyEmail: verification failed", "id", id, "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger.InfoContext(r.Context(), "users: VerifyEmail: email verified", "id", id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "verified"})
+}
+
+// ResendVerification sends the caller a fresh verification email.
+func (h *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if caller := callerID(r); caller != id {
+		logger.WarnContext(r.Context(), "users: ResendVerification: forbidden", "id", id, "caller", caller)
+		http.Error(w, "you can only request verification for your own account", http.StatusForbidden)
+		return
+	}
+	user, err := h.Store.GetUser(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "users: ResendVerification: not found", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if user.EmailVerified {
+		logger.DebugContext(r.Context(), "users: ResendVerification: already verified", "id", id)
+		http.Error(w, "email is already verified", http.StatusConflict)
+		return
+	}
+	if err := h.sendVerification(r.Context(), user); err != nil {
+		logger.ErrorContext(r.Context(), "users: ResendVerification: send failed", "id", id, "error", err)
+		http.Error(w, "failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	logger.InfoContext(r.Context(), "users: ResendVerification: email sent", "id", id)
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "sent"})
+}
+
+// ChangeEmail sets the caller's email address. A new address starts out
+// unverified and is sent a verification email.
+func (h *UserHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if caller := callerID(r); caller != id {
+		logger.WarnContext(r.Context(), "users: ChangeEmail: forbidden", "id", id, "caller", caller)
+		http.Error(w, "you can only change your own email", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.ErrorContext(r.Context(), "users: ChangeEmail: failed to decode request body", "id", id, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		logger.WarnContext(r.Context(), "users: ChangeEmail: invalid email", "id", id, "email", redactEmail(req.Email), "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.Store.ChangeEmail(r.Context(), id, email); err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, store.ErrEmailTaken) {
+			status = http.StatusConflict
+		}
+		logger.ErrorContext(r.Context(), "users: ChangeEmail: store error", "id", id, "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	user, err := h.Store.GetUser(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "users: ChangeEmail: reload failed", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !user.EmailVerified {
+		if err := h.sendVerification(r.Context(), user); err != nil {
+			// The address is saved either way; the user can ask for another email.
+			logger.ErrorContext(r.Context(), "users: ChangeEmail: failed to send verification email", "id", id, "error", err)
+		}
+	}
+	logger.InfoContext(r.Context(), "users: ChangeEmail: email changed", "id", id, "verified", user.EmailVerified)
+	writeJSON(w, http.StatusOK, user)
+}
+
+// sendVerification issues a new verification token for the user's email and
+// mails it to them.
+func (h *UserHandler) sendVerification(ctx context.Context, u models.User) error {
+	token := generateID() + generateID()
+	if err := h.Store.CreateEmailVerification(ctx, u.ID, u.Email, token, time.Now().Add(verificationTTL)); err != nil {
+		return err
+	}
+	sender := h.Mailer
+	if sender == nil {
+		sender = mailer.LogSender{Logger: logger}
+	}
+	return sender.Send(mailer.Message{
+		To:      u.Email,
+		Subject: "Verify your Dischord email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by sending this token to "+
+			"POST /users/%s/email/verify within %d hours:\n\n%s\n",
+			u.Username, u.ID, int(verificationTTL.Hours()), token),
+		Secrets: []string{token},
+	})
+}
+
 func (h *UserHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	logger.Debug("users: Get: request", "id", id)
-	user, err := h.Store.GetUser(id)
+	logger.DebugContext(r.Context(), "users: Get: request", "id", id)
+	user, err := h.Store.GetUser(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "users: Get: not found", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// The caller decides which view is returned, so caches must key on it.
+	w.Header().Add("Vary", UserIDHeader)
+	self := callerID(r) == id
+	view := "public"
+	if self {
+		view = "self"
+	}
+	if notModified(w, r, etag(user.UpdatedAt, view), user.UpdatedAt) {
+		logger.DebugContext(r.Context(), "users: Get: not modified", "id", id, "view", view)
+		return
+	}
+	if !self {
+		logger.DebugContext(r.Context(), "users: Get: success (public)", "id", id, "username", user.Username)
+		writeJSON(w, http.StatusOK, user.Public())
+		return
+	}
+	logger.DebugContext(r.Context(), "users: Get: success", "id", id, "username", user.Username)
+	writeJSON(w, http.StatusOK, user)
+}
+
+// GetMany returns the public profiles of the users listed in ?ids=, in that
+// order. Unknown IDs are left out.
+func (h *UserHandler) GetMany(w http.ResponseWriter, r *http.Request) {
+	ids, err := batchIDs(r)
+	if err != nil {
+		logger.WarnContext(r.Context(), "users: GetMany: invalid ids", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	users, err := h.Store.GetUsers(r.Context(), ids)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "users: GetMany: store error", "count", len(ids), "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	profiles := make([]models.PublicUser, len(users))
+	for i, u := range users {
+		profiles[i] = u.Public()
+	}
+	logger.DebugContext(r.Context(), "users: GetMany: success", "requested", len(ids), "found", len(profiles))
+	writeJSON(w, http.StatusOK, profiles)
+}
+
+// Update changes the caller's own profile. Only fields present in the request
+// body are modified.
+func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if caller := callerID(r); caller != id {
+		logger.WarnContext(r.Context(), "users: Update: forbidden", "id", id, "caller", caller)
+		http.Error(w, "you can only update your own profile", http.StatusForbidden)
+		return
+	}
+	user, err := h.Store.GetUser(r.Context(), id)
 	if err != nil {
-		logger.Error("users: Get: not found", "id", id, "error", err)
+		logger.ErrorContext(r.Context(), "users: Update: not found", "id", id, "error", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	logger.Debug("users: Get: success", "id", id, "username", user.Username)
+
+	var req profileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.ErrorContext(r.Context(), "users: Update: failed to decode request body", "id", id, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	for _, field := range []*string{req.DisplayName, req.AvatarURL, req.Bio} {
+		if field != nil {
+			*field = strings.TrimSpace(*field)
+		}
+	}
+	// Only the fields being changed are validated, so accounts whose stored
+	// username predates the current rules can still edit the rest.
+	if err := validateProfile(req); err != nil {
+		logger.WarnContext(r.Context(), "users: Update: invalid profile", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Username != nil {
+		user.Username = *req.Username
+	}
+	if req.DisplayName != nil {
+		user.DisplayName = *req.DisplayName
+	}
+	if req.AvatarURL != nil {
+		user.AvatarURL = *req.AvatarURL
+	}
+	if req.Bio != nil {
+		user.Bio = *req.Bio
+	}
+	if req.Status != nil {
+		user.Status = *req.Status
+	}
+
+	if err := h.Store.UpdateUser(r.Context(), user); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, store.ErrUsernameTaken) {
+			status = http.StatusConflict
+		}
+		logger.ErrorContext(r.Context(), "users: Update: store error", "id", id, "username", user.Username, "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger.InfoContext(r.Context(), "users: Update: profile updated", "id", id, "username", user.Username)
 	writeJSON(w, http.StatusOK, user)
 }
 
+// Delete removes the caller's own account. Authored posts and messages are
+// kept but anonymized.
+func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if caller := callerID(r); caller != id {
+		logger.WarnContext(r.Context(), "users: Delete: forbidden", "id", id, "caller", caller)
+		http.Error(w, "you can only delete your own account", http.StatusForbidden)
+		return
+	}
+	logger.DebugContext(r.Context(), "users: Delete: request", "id", id)
+	if err := h.Store.DeleteUser(r.Context(), id); err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, store.ErrOwnsServers) {
+			status = http.StatusConflict
+		}
+		logger.ErrorContext(r.Context(), "users: Delete: store error", "id", id, "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger.InfoContext(r.Context(), "users: Delete: account deleted", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListServers returns summaries of the caller's servers, in the order they
+// arranged them.
+func (h *UserHandler) ListServers(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if caller := callerID(r); caller != id {
+		logger.WarnContext(r.Context(), "users: ListServers: forbidden", "id", id, "caller", caller)
+		http.Error(w, "you can only list your own servers", http.StatusForbidden)
+		return
+	}
+	servers, err := h.Store.ListUserServers(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "users: ListServers: store error", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugContext(r.Context(), "users: ListServers: success", "id", id, "count", len(servers))
+	writeJSON(w, http.StatusOK, servers)
+}
+
+// ReorderServers rearranges the caller's server list. The body must list
+// each of their servers exactly once.
+func (h *UserHandler) ReorderServers(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if caller := callerID(r); caller != id {
+		logger.WarnContext(r.Context(), "users: ReorderServers: forbidden", "id", id, "caller", caller)
+		http.Error(w, "you can only reorder your own servers", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		ServerIDs []string `json:"server_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.ErrorContext(r.Context(), "users: ReorderServers: failed to decode request body", "id", id, "error", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := h.Store.ReorderServers(r.Context(), id, req.ServerIDs); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, store.ErrServerOrder) {
+			status = http.StatusBadRequest
+		}
+		logger.ErrorContext(r.Context(), "users: ReorderServers: store error", "id", id, "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger.InfoContext(r.Context(), "users: ReorderServers: servers reordered", "id", id, "count", len(req.ServerIDs))
+	h.ListServers(w, r)
+}
+
+// Profile field limits.
+const (
+	maxUsernameLen    = 32
+	minUsernameLen    = 2
+	maxDisplayNameLen = 32
+	maxBioLen         = 190
+	maxURLLen         = 2048
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validStatuses are the presence values a user may set; "" clears it.
+var validStatuses = map[string]bool{"": true, "online": true, "idle": true, "dnd": true, "invisible": true}
+
+// normalizeEmail trims and lowercases an address and checks that it is a bare
+// addr-spec such as "alice@example.com".
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", errors.New("email must be a valid address such as name@example.com")
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 1 || !strings.Contains(email[at+1:], ".") {
+		return "", errors.New("email must be a valid address such as name@example.com")
+	}
+	return email, nil
+}
+
+// redactEmail hides the local part of an address for logging, keeping the
+// domain: "alice@example.com" becomes "***@example.com".
+func redactEmail(email string) string {
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		return "***" + email[at:]
+	}
+	return "[redacted]"
+}
+
+func validateUsername(username string) error {
+	if n := utf8.RuneCountInString(username); n < minUsernameLen || n > maxUsernameLen {
+		return fmt.Errorf("username must be between %d and %d characters", minUsernameLen, maxUsernameLen)
+	}
+	if !usernamePattern.MatchString(username) {
+		return errors.New("username may only contain letters, digits, '_', '.' and '-'")
+	}
+	return nil
+}
+
+// profileUpdate is the body of a profile PATCH. Nil fields are left as they
+// are.
+type profileUpdate struct {
+	Username    *string `json:"username"`
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Bio         *string `json:"bio"`
+	Status      *string `json:"status"`
+}
+
+// validateProfile checks the fields set in p.
+func validateProfile(p profileUpdate) error {
+	if p.Username != nil {
+		if err := validateUsername(*p.Username); err != nil {
+			return err
+		}
+	}
+	if p.DisplayName != nil && utf8.RuneCountInString(*p.DisplayName) > maxDisplayNameLen {
+		return fmt.Errorf("display_name must be at most %d characters", maxDisplayNameLen)
+	}
+	if p.Bio != nil && utf8.RuneCountInString(*p.Bio) > maxBioLen {
+		return fmt.Errorf("bio must be at most %d characters", maxBioLen)
+	}
+	if p.AvatarURL != nil && *p.AvatarURL != "" && !isHTTPURL(*p.AvatarURL) {
+		return errors.New("avatar_url must be an http or https URL")
+	}
+	if p.Status != nil && !validStatuses[*p.Status] {
+		return errors.New("status must be one of online, idle, dnd or invisible")
+	}
+	return nil
+}
+
+// isHTTPURL reports whether raw is an absolute http or https URL of at most
+// maxURLLen bytes.
+func isHTTPURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && len(raw) <= maxURLLen &&
+		(parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
 func generateID() string {
 	b := make([]byte, 16)
 	rand.Read(b)
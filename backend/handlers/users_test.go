@@ -6,8 +6,11 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/tonitran/dischord/mailer"
 	"github.com/tonitran/dischord/models"
+	"github.com/tonitran/dischord/store"
 )
 
 func TestUserHandler_Create(t *testing.T) {
@@ -23,6 +26,16 @@ func TestUserHandler_Create(t *testing.T) {
 			body:       `{"username":"alice","email":"alice@example.com"}`,
 			wantStatus: http.StatusCreated,
 		},
+		{
+			name:       "duplicate email ignoring case",
+			body:       `{"username":"alice2","email":" Alice@Example.com "}`,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "invalid email",
+			body:       `{"username":"carol","email":"not-an-email"}`,
+			wantStatus: http.StatusBadRequest,
+		},
 		{
 			name:       "missing username",
 			body:       `{"email":"alice@example.com"}`,
@@ -71,7 +84,7 @@ func TestUserHandler_Get(t *testing.T) {
 	h := &UserHandler{Store: s}
 
 	user := models.User{ID: "u1", Username: "alice", Email: "alice@example.com"}
-	s.CreateUser(user)
+	s.CreateUser(t.Context(), user)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /users/{id}", h.Get)
@@ -91,6 +104,34 @@ func TestUserHandler_Get(t *testing.T) {
 		}
 	})
 
+	t.Run("public view hides email", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/users/u1", nil)
+		req.Header.Set(UserIDHeader, "someone-else")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		var got map[string]any
+		json.NewDecoder(w.Body).Decode(&got)
+		for _, field := range []string{"email", "email_verified", "server_ids"} {
+			if _, ok := got[field]; ok {
+				t.Errorf("public profile includes %q: %v", field, got)
+			}
+		}
+	})
+
+	t.Run("own view includes email", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/users/u1", nil)
+		req.Header.Set(UserIDHeader, "u1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		var got models.User
+		json.NewDecoder(w.Body).Decode(&got)
+		if got.Email != "alice@example.com" {
+			t.Errorf("got email %q, want %q", got.Email, "alice@example.com")
+		}
+	})
+
 	t.Run("nonexistent user", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/users/missing", nil)
 		w := httptest.NewRecorder()
@@ -101,3 +142,375 @@ func TestUserHandler_Get(t *testing.T) {
 		}
 	})
 }
+
+func TestUserHandler_Update(t *testing.T) {
+	s := testStore(t)
+	h := &UserHandler{Store: s}
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "alice@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "bob@example.com"})
+	// Created before usernames were restricted.
+	s.CreateUser(t.Context(), models.User{ID: "u3", Username: "old name!", Email: "old@example.com"})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PATCH /users/{id}", h.Update)
+
+	tests := []struct {
+		name       string
+		userID     string
+		caller     string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "update profile",
+			userID:     "u1",
+			caller:     "u1",
+			body:       `{"display_name":"Alice","bio":"hi","status":"idle","avatar_url":"https://example.com/a.png"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "rename",
+			userID:     "u1",
+			caller:     "u1",
+			body:       `{"username":"alice2"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "username taken ignoring case",
+			userID:     "u1",
+			caller:     "u1",
+			body:       `{"username":"BOB"}`,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "invalid username",
+			userID:     "u1",
+			caller:     "u1",
+			body:       `{"username":"a b"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "legacy username can edit the rest",
+			userID:     "u3",
+			caller:     "u3",
+			body:       `{"bio":"still here"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid status",
+			userID:     "u1",
+			caller:     "u1",
+			body:       `{"status":"busy"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid avatar url",
+			userID:     "u1",
+			caller:     "u1",
+			body:       `{"avatar_url":"javascript:alert(1)"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "someone else's profile",
+			userID:     "u1",
+			caller:     "u2",
+			body:       `{"bio":"hacked"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid json",
+			userID:     "u1",
+			caller:     "u1",
+			body:       `{bad`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/users/"+tt.userID, strings.NewReader(tt.body))
+			req.Header.Set(UserIDHeader, tt.caller)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	got, err := s.GetUser(t.Context(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != "alice2" || got.DisplayName != "Alice" || got.Status != "idle" || got.Bio != "hi" {
+		t.Errorf("unexpected profile after updates: %+v", got)
+	}
+}
+
+func TestUserHandler_Delete(t *testing.T) {
+	s := testStore(t)
+	h := &UserHandler{Store: s}
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "alice@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "bob@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u2"})
+	s.JoinServer(t.Context(), "s1", "u1")
+	s.AddFriend(t.Context(), "u1", "u2")
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello"})
+	s.CreateMessage(t.Context(), models.Message{ID: "m1", ServerID: "s1", AuthorID: "u1", Content: "hi"}, 0)
+	edited := "Hello again"
+	if _, err := s.UpdatePost(t.Context(), store.PostEdit{ID: "p1", ServerID: "s1", EditorID: "u1", Version: 1, Title: &edited}); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /users/{id}", h.Delete)
+
+	del := func(id, caller string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+id, nil)
+		req.Header.Set(UserIDHeader, caller)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := del("u1", "u2"); code != http.StatusForbidden {
+		t.Errorf("deleting another user: got status %d, want %d", code, http.StatusForbidden)
+	}
+	if code := del("u2", "u2"); code != http.StatusConflict {
+		t.Errorf("deleting a server owner: got status %d, want %d", code, http.StatusConflict)
+	}
+	if code := del("u1", "u1"); code != http.StatusNoContent {
+		t.Fatalf("deleting own account: got status %d, want %d", code, http.StatusNoContent)
+	}
+	if code := del("u1", "u1"); code != http.StatusNotFound {
+		t.Errorf("deleting twice: got status %d, want %d", code, http.StatusNotFound)
+	}
+
+	if _, err := s.GetUser(t.Context(), "u1"); err == nil {
+		t.Error("expected user to be gone")
+	}
+	post, err := s.GetPost(t.Context(), "s1", "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.AuthorID != store.DeletedUserID {
+		t.Errorf("got post author %q, want %q", post.AuthorID, store.DeletedUserID)
+	}
+	msgs := s.GetMessagesByServer(t.Context(), "s1")
+	if len(msgs) != 1 || msgs[0].AuthorID != store.DeletedUserID {
+		t.Errorf("expected anonymized message, got %+v", msgs)
+	}
+	revisions, err := s.ListPostRevisions(t.Context(), "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revisions))
+	}
+	for _, rev := range revisions {
+		if rev.EditedBy != store.DeletedUserID {
+			t.Errorf("revision %d: got editor %q, want %q", rev.Version, rev.EditedBy, store.DeletedUserID)
+		}
+	}
+	friends, _ := s.GetFriends(t.Context(), "u2")
+	if len(friends) != 0 {
+		t.Errorf("expected friendship removed, got %+v", friends)
+	}
+	members, _ := s.GetServerMembers(t.Context(), "s1")
+	for _, m := range members {
+		if m.ID == "u1" {
+			t.Error("expected membership removed")
+		}
+	}
+}
+
+func TestUserHandler_Servers(t *testing.T) {
+	s := testStore(t)
+	h := &UserHandler{Store: s}
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "alice@example.com"})
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob", Email: "bob@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "u1", MemberIDs: []string{"u1"}})
+	s.CreateServer(t.Context(), models.Server{ID: "s2", Name: "random", OwnerID: "u2", MemberIDs: []string{"u2"}})
+	s.JoinServer(t.Context(), "s2", "u1")
+	s.CreateMessage(t.Context(), models.Message{ID: "m1", ServerID: "s2", AuthorID: "u2", Content: "hi", CreatedAt: time.Now().Add(time.Minute)}, 0)
+	s.CreateMessage(t.Context(), models.Message{ID: "m2", ServerID: "s2", AuthorID: "u1", Content: "hello", CreatedAt: time.Now().Add(time.Minute)}, 0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}/servers", h.ListServers)
+	mux.HandleFunc("PUT /users/{id}/servers/order", h.ReorderServers)
+
+	list := func(t *testing.T) []models.UserServer {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/users/u1/servers", nil)
+		req.Header.Set(UserIDHeader, "u1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+		var servers []models.UserServer
+		json.NewDecoder(w.Body).Decode(&servers)
+		return servers
+	}
+
+	servers := list(t)
+	if len(servers) != 2 || servers[0].ID != "s1" || servers[1].ID != "s2" {
+		t.Fatalf("got %+v, want s1 then s2 in the order they were joined", servers)
+	}
+	if servers[1].MemberCount != 2 || servers[1].UnreadCount != 1 {
+		t.Errorf("got %d members and %d unread, want 2 and 1", servers[1].MemberCount, servers[1].UnreadCount)
+	}
+
+	tests := []struct {
+		name       string
+		caller     string
+		body       string
+		wantStatus int
+	}{
+		{name: "other user", caller: "u2", body: `{"server_ids":["s2","s1"]}`, wantStatus: http.StatusForbidden},
+		{name: "missing server", caller: "u1", body: `{"server_ids":["s2"]}`, wantStatus: http.StatusBadRequest},
+		{name: "repeated server", caller: "u1", body: `{"server_ids":["s2","s2","s1"]}`, wantStatus: http.StatusBadRequest},
+		{name: "not a member", caller: "u1", body: `{"server_ids":["s2","s3"]}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid json", caller: "u1", body: `{bad`, wantStatus: http.StatusBadRequest},
+		{name: "reorder", caller: "u1", body: `{"server_ids":["s2","s1"]}`, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/users/u1/servers/order", strings.NewReader(tt.body))
+			req.Header.Set(UserIDHeader, tt.caller)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	servers = list(t)
+	if len(servers) != 2 || servers[0].ID != "s2" || servers[1].ID != "s1" {
+		t.Errorf("got %+v, want s2 then s1 after reordering", servers)
+	}
+}
+
+// recordingSender captures sent messages for inspection.
+type recordingSender struct {
+	sent []mailer.Message
+}
+
+func (r *recordingSender) Send(msg mailer.Message) error {
+	r.sent = append(r.sent, msg)
+	return nil
+}
+
+func TestUserHandler_VerifyEmail(t *testing.T) {
+	s := testStore(t)
+	sender := &recordingSender{}
+	h := &UserHandler{Store: s, Mailer: sender}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /users", h.Create)
+	mux.HandleFunc("POST /users/{id}/email/verify", h.VerifyEmail)
+	mux.HandleFunc("POST /users/{id}/email/verification", h.ResendVerification)
+
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"username":"alice","email":"Alice@Example.com"}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d, want %d", w.Code, http.StatusCreated)
+	}
+	var user models.User
+	json.NewDecoder(w.Body).Decode(&user)
+	if user.Email != "alice@example.com" {
+		t.Errorf("got email %q, want it normalized", user.Email)
+	}
+	if user.EmailVerified {
+		t.Error("new user should not be verified")
+	}
+	if len(sender.sent) != 1 || sender.sent[0].To != "alice@example.com" {
+		t.Fatalf("expected one verification email, got %+v", sender.sent)
+	}
+	body := sender.sent[0].Body
+	token := strings.TrimSpace(body[strings.LastIndex(strings.TrimSpace(body), "\n"):])
+
+	verify := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/users/"+user.ID+"/email/verify", strings.NewReader(`{"token":"`+token+`"}`))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := verify("wrong"); code != http.StatusBadRequest {
+		t.Errorf("wrong token: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := verify(token); code != http.StatusOK {
+		t.Fatalf("valid token: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := verify(token); code != http.StatusBadRequest {
+		t.Errorf("reused token: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	got, _ := s.GetUser(t.Context(), user.ID)
+	if !got.EmailVerified {
+		t.Error("expected email to be verified")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/users/"+user.ID+"/email/verification", nil)
+	req.Header.Set(UserIDHeader, user.ID)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("resend after verifying: got status %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestUserHandler_ChangeEmail(t *testing.T) {
+	s := testStore(t)
+	sender := &recordingSender{}
+	h := &UserHandler{Store: s, Mailer: sender}
+	s.CreateUser(t.Context(), models.User{ID: "u1", Username: "alice", Email: "alice@example.com", EmailVerified: true})
+	// Lost its address when duplicate emails were cleaned up.
+	s.CreateUser(t.Context(), models.User{ID: "u2", Username: "bob"})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /users/{id}/email", h.ChangeEmail)
+
+	change := func(id, caller, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/users/"+id+"/email", strings.NewReader(body))
+		req.Header.Set(UserIDHeader, caller)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := change("u2", "u1", `{"email":"bob@example.com"}`); w.Code != http.StatusForbidden {
+		t.Errorf("other user: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := change("u2", "u2", `{"email":"not an email"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid email: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := change("u2", "u2", `{"email":"ALICE@example.com"}`); w.Code != http.StatusConflict {
+		t.Errorf("taken email: got status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	w := change("u2", "u2", `{"email":"Bob@Example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("change: got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var user models.User
+	json.NewDecoder(w.Body).Decode(&user)
+	if user.Email != "bob@example.com" || user.EmailVerified {
+		t.Errorf("got %q verified=%v, want the new address unverified", user.Email, user.EmailVerified)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].To != "bob@example.com" {
+		t.Errorf("expected a verification email to the new address, got %+v", sender.sent)
+	}
+
+	// Setting a verified address again keeps it verified.
+	if w := change("u1", "u1", `{"email":"alice@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("same email: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if got, _ := s.GetUser(t.Context(), "u1"); !got.EmailVerified {
+		t.Error("expected an unchanged address to stay verified")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/tonitran/dischord/models"
 	"github.com/tonitran/dischord/store"
 )
 
@@ -11,37 +12,102 @@ type VoteHandler struct {
 	Store *store.Database
 }
 
+// GetVote returns a single user's vote on a post. The voter is taken from the
+// author_id query parameter, falling back to the calling user. Only server
+// moderators may look up someone else's vote, as with the voter list.
 func (h *VoteHandler) GetVote(w http.ResponseWriter, r *http.Request) {
+	server_id := r.PathValue("server_id")
 	post_id := r.PathValue("id")
-
-	var req struct {
-		Author string `json:"author"`
-		Vote   int    `json:"vote"`
+	caller := callerID(r)
+	author := r.URL.Query().Get("author_id")
+	if author == "" {
+		author = caller
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("votes: GetVote: failed to decode request body", "post_id", post_id, "error", err)
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if author == "" {
+		logger.WarnContext(r.Context(), "votes: GetVote: missing author_id", "post_id", post_id)
+		http.Error(w, "author_id query parameter or "+UserIDHeader+" header is required", http.StatusBadRequest)
+		return
+	}
+	if author != caller && !requireModerator(h.Store, w, r, server_id, "votes: GetVote") {
 		return
 	}
 
-	logger.Debug("votes: GetVote: request", "post_id", post_id, "author", req.Author)
-	vote, err := h.Store.GetVote(post_id, req.Author)
+	logger.DebugContext(r.Context(), "votes: GetVote: request", "server_id", server_id, "post_id", post_id, "author", author)
+	if _, err := h.Store.GetPost(r.Context(), server_id, post_id); err != nil {
+		logger.ErrorContext(r.Context(), "votes: GetVote: post not found", "server_id", server_id, "post_id", post_id, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	vote, err := h.Store.GetVote(r.Context(), post_id, author)
 	if err != nil {
-		logger.Error("votes: GetVote: not found", "post_id", post_id, "author", req.Author, "error", err)
+		logger.ErrorContext(r.Context(), "votes: GetVote: not found", "post_id", post_id, "author", author, "error", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	logger.Debug("votes: GetVote: success", "post_id", post_id, "author", req.Author, "vote", vote.Vote)
+	logger.DebugContext(r.Context(), "votes: GetVote: success", "post_id", post_id, "author", author, "vote", vote.Vote)
 	writeJSON(w, http.StatusOK, vote)
 }
 
+// ListVotes returns the vote breakdown for a post. Server moderators also get
+// the IDs of everyone who voted each way.
+func (h *VoteHandler) ListVotes(w http.ResponseWriter, r *http.Request) {
+	server_id := r.PathValue("server_id")
+	post_id := r.PathValue("id")
+	logger.DebugContext(r.Context(), "votes: ListVotes: request", "server_id", server_id, "post_id", post_id)
+	post, err := h.Store.GetPost(r.Context(), server_id, post_id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "votes: ListVotes: post not found", "server_id", server_id, "post_id", post_id, "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	summary := models.VoteSummary{
+		PostID:    post.ID,
+		Score:     post.Votes,
+		Upvotes:   post.Upvotes,
+		Downvotes: post.Downvotes,
+	}
+
+	caller := callerID(r)
+	if caller != "" {
+		isMod, err := h.Store.IsModerator(r.Context(), post.ServerID, caller)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "votes: ListVotes: moderator check failed", "server_id", post.ServerID, "caller", caller, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if isMod {
+			voters, err := h.Store.GetVoters(r.Context(), post_id)
+			if err != nil {
+				logger.ErrorContext(r.Context(), "votes: ListVotes: store error", "post_id", post_id, "error", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			summary.Upvoters = []string{}
+			summary.Downvoters = []string{}
+			for _, v := range voters {
+				if v.Vote > 0 {
+					summary.Upvoters = append(summary.Upvoters, v.AuthorID)
+				} else {
+					summary.Downvoters = append(summary.Downvoters, v.AuthorID)
+				}
+			}
+		}
+	}
+
+	logger.DebugContext(r.Context(), "votes: ListVotes: success", "post_id", post_id, "score", summary.Score, "voters_included", summary.Upvoters != nil)
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// PutVote records a vote of -1, 0 or 1 on a post. The voter is the author in
+// the request body, falling back to the calling user.
 func (h *VoteHandler) PutVote(w http.ResponseWriter, r *http.Request) {
 	server_id := r.PathValue("server_id")
 	post_id := r.PathValue("id")
-	post, err := h.Store.GetPost(server_id, post_id)
+	post, err := h.Store.GetPost(r.Context(), server_id, post_id)
 	if err != nil {
-		logger.Error("votes: PutVote: post not found", "server_id", server_id, "post_id", post_id, "error", err)
+		logger.ErrorContext(r.Context(), "votes: PutVote: post not found", "server_id", server_id, "post_id", post_id, "error", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
@@ -51,21 +117,35 @@ func (h *VoteHandler) PutVote(w http.ResponseWriter, r *http.Request) {
 		Vote   int    `json:"vote"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("votes: PutVote: failed to decode request body", "post_id", post_id, "error", err)
+		logger.ErrorContext(r.Context(), "votes: PutVote: failed to decode request body", "post_id", post_id, "error", err)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if req.Author == "" {
+		req.Author = callerID(r)
+	}
 
-	vote, _ := h.Store.GetVote(post_id, req.Author)
-	if req.Author != "" && req.Vote >= -1 && req.Vote <= 1 && req.Vote != vote.Vote {
-		if err := h.Store.PostVote(post_id, req.Author, req.Vote); err != nil {
-			logger.Error("votes: PutVote: store error", "post_id", post_id, "author", req.Author, "vote", req.Vote, "error", err)
+	if req.Author == "" || req.Vote < -1 || req.Vote > 1 {
+		logger.DebugContext(r.Context(), "votes: PutVote: invalid vote", "post_id", post_id, "author", req.Author, "requested_vote", req.Vote)
+		writeJSON(w, http.StatusOK, post)
+		return
+	}
+	changed, err := h.Store.PostVote(r.Context(), post_id, req.Author, req.Vote)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "votes: PutVote: store error", "post_id", post_id, "author", req.Author, "vote", req.Vote, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if changed {
+		logger.InfoContext(r.Context(), "votes: PutVote: vote recorded", "post_id", post_id, "author", req.Author, "vote", req.Vote)
+		votesCast.Inc(voteLabel(req.Vote))
+		if post, err = h.Store.GetPost(r.Context(), server_id, post_id); err != nil {
+			logger.ErrorContext(r.Context(), "votes: PutVote: failed to reload post", "post_id", post_id, "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		logger.Info("votes: PutVote: vote recorded", "post_id", post_id, "author", req.Author, "vote", req.Vote)
 	} else {
-		logger.Debug("votes: PutVote: vote unchanged or invalid", "post_id", post_id, "author", req.Author, "requested_vote", req.Vote, "existing_vote", vote.Vote)
+		logger.DebugContext(r.Context(), "votes: PutVote: vote unchanged", "post_id", post_id, "author", req.Author, "vote", req.Vote)
 	}
 	writeJSON(w, http.StatusOK, post)
 }
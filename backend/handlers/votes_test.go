@@ -18,16 +18,20 @@ func setupVotesTest(t *testing.T) (*store.Database, *http.ServeMux) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /servers/{server_id}/posts/{id}/vote", h.GetVote)
 	mux.HandleFunc("PUT /servers/{server_id}/posts/{id}/vote", h.PutVote)
+	mux.HandleFunc("GET /servers/{server_id}/posts/{id}/votes", h.ListVotes)
 	return s, mux
 }
 
 func TestPostHandler_GetVote(t *testing.T) {
 	s, mux := setupVotesTest(t)
-	s.CreatePost(models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello", Body: "World"})
-	s.PostVote("p1", "u1", 1)
+	s.CreateUser(t.Context(), models.User{ID: "owner", Username: "owner", Email: "o@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "owner"})
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello", Body: "World"})
+	s.PostVote(t.Context(), "p1", "u1", 1)
 
 	t.Run("existing vote", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote", strings.NewReader(`{"author":"u1"}`))
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote?author_id=u1", nil)
+		req.Header.Set(UserIDHeader, "u1")
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -41,8 +45,47 @@ func TestPostHandler_GetVote(t *testing.T) {
 		}
 	})
 
+	t.Run("caller's own vote", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote", nil)
+		req.Header.Set(UserIDHeader, "u1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+		var vote models.Vote
+		json.NewDecoder(w.Body).Decode(&vote)
+		if vote.AuthorID != "u1" || vote.Vote != 1 {
+			t.Errorf("got %+v, want u1's upvote", vote)
+		}
+	})
+
+	t.Run("other user's vote", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote?author_id=u1", nil)
+		req.Header.Set(UserIDHeader, "u2")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("moderator reads another user's vote", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote?author_id=u1", nil)
+		req.Header.Set(UserIDHeader, "owner")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+
 	t.Run("nonexistent vote", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote", strings.NewReader(`{"author":"u2"}`))
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote?author_id=u2", nil)
+		req.Header.Set(UserIDHeader, "u2")
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -51,8 +94,19 @@ func TestPostHandler_GetVote(t *testing.T) {
 		}
 	})
 
-	t.Run("invalid json", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote", strings.NewReader(`{bad`))
+	t.Run("nonexistent post", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/missing/vote?author_id=u1", nil)
+		req.Header.Set(UserIDHeader, "u1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("missing author", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote", nil)
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -62,9 +116,64 @@ func TestPostHandler_GetVote(t *testing.T) {
 	})
 }
 
+func TestPostHandler_ListVotes(t *testing.T) {
+	s, mux := setupVotesTest(t)
+	s.CreateUser(t.Context(), models.User{ID: "owner", Username: "owner", Email: "o@example.com"})
+	s.CreateServer(t.Context(), models.Server{ID: "s1", Name: "general", OwnerID: "owner"})
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello", Body: "World"})
+	s.PostVote(t.Context(), "p1", "u1", 1)
+	s.PostVote(t.Context(), "p1", "u2", 1)
+	s.PostVote(t.Context(), "p1", "u3", -1)
+
+	t.Run("counts only for members", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/votes", nil)
+		req.Header.Set(UserIDHeader, "u1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+		var summary models.VoteSummary
+		json.NewDecoder(w.Body).Decode(&summary)
+		if summary.Score != 1 || summary.Upvotes != 2 || summary.Downvotes != 1 {
+			t.Errorf("got %+v, want score=1 up=2 down=1", summary)
+		}
+		if summary.Upvoters != nil || summary.Downvoters != nil {
+			t.Errorf("expected no voter lists for a non-moderator, got %+v", summary)
+		}
+	})
+
+	t.Run("voter lists for moderators", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/votes", nil)
+		req.Header.Set(UserIDHeader, "owner")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+		var summary models.VoteSummary
+		json.NewDecoder(w.Body).Decode(&summary)
+		if len(summary.Upvoters) != 2 || len(summary.Downvoters) != 1 || summary.Downvoters[0] != "u3" {
+			t.Errorf("got upvoters %v downvoters %v", summary.Upvoters, summary.Downvoters)
+		}
+	})
+
+	t.Run("nonexistent post", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/missing/votes", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+}
+
 func TestPostHandler_PutVote(t *testing.T) {
 	s, mux := setupVotesTest(t)
-	s.CreatePost(models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello", Body: "World"})
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello", Body: "World"})
 
 	t.Run("upvote", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPut, "/servers/s1/posts/p1/vote", strings.NewReader(`{"author":"u1","vote":1}`))
@@ -112,7 +221,8 @@ func TestPostHandler_PutVote(t *testing.T) {
 		}
 
 		// Confirm the vote switched via GetVote
-		req2 := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote", strings.NewReader(`{"author":"u1"}`))
+		req2 := httptest.NewRequest(http.MethodGet, "/servers/s1/posts/p1/vote?author_id=u1", nil)
+		req2.Header.Set(UserIDHeader, "u1")
 		w2 := httptest.NewRecorder()
 		mux.ServeHTTP(w2, req2)
 
@@ -143,3 +253,38 @@ func TestPostHandler_PutVote(t *testing.T) {
 		}
 	})
 }
+
+func TestPostHandler_PutVote_UpdatesScore(t *testing.T) {
+	s, mux := setupVotesTest(t)
+	s.CreatePost(t.Context(), models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello", Body: "World"})
+
+	for _, body := range []string{
+		`{"author":"u1","vote":1}`,
+		`{"author":"u2","vote":1}`,
+		`{"author":"u3","vote":-1}`,
+		`{"author":"u2","vote":-1}`,
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/servers/s1/posts/p1/vote", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("vote %s: got status %d, want %d", body, w.Code, http.StatusOK)
+		}
+	}
+
+	post, err := s.GetPost(t.Context(), "s1", "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Votes != -1 || post.Upvotes != 1 || post.Downvotes != 2 {
+		t.Errorf("got score=%d up=%d down=%d, want score=-1 up=1 down=2", post.Votes, post.Upvotes, post.Downvotes)
+	}
+
+	drifts, err := s.ReconcileVoteScores(t.Context(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Errorf("expected no drift, got %+v", drifts)
+	}
+}
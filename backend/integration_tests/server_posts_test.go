@@ -8,19 +8,20 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/tonitran/dischord/handlers"
 	"github.com/tonitran/dischord/models"
 	"github.com/tonitran/dischord/router"
 )
 
 func TestServerPostIntegration(t *testing.T) {
 	s := testStore(t)
-	handler := router.New(s)
+	handler := router.New(s, router.Options{})
 
 	// Step 0: Seed the owner user required by the FK constraint on server_user.
-	if err := s.CreateUser(models.User{ID: "user-1", Username: "user1", Email: "user1@example.com"}); err != nil {
+	if err := s.CreateUser(t.Context(), models.User{ID: "user-1", Username: "user1", Email: "user1@example.com", EmailVerified: true}); err != nil {
 		t.Fatalf("seed user: %v", err)
 	}
-	if err := s.CreateUser(models.User{ID: "user-2", Username: "user2", Email: "user2@example.com"}); err != nil {
+	if err := s.CreateUser(t.Context(), models.User{ID: "user-2", Username: "user2", Email: "user2@example.com"}); err != nil {
 		t.Fatalf("seed user-2: %v", err)
 	}
 
@@ -45,6 +46,7 @@ func TestServerPostIntegration(t *testing.T) {
 	// Step 2: Add a post to the server.
 	createPostBody := `{"author_id":"user-1","title":"Hello World","body":"This is the first post."}`
 	req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/servers/%s/posts", createdServer.ID), strings.NewReader(createPostBody))
+	req.Header.Set(handlers.UserIDHeader, "user-1")
 	w = httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
 
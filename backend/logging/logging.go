@@ -0,0 +1,202 @@
+// Package logging builds the backend's structured logger and the HTTP
+// middleware that logs each request. Every request gets an ID that is
+// returned in the X-Request-ID response header and attached to any record
+// logged with the request's context.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config selects the level, format and destination of the logger.
+type Config struct {
+	// Level is one of debug, info, warn or error. Empty means info.
+	Level string `json:"level"`
+	// Format is json or text. Empty means json.
+	Format string `json:"format"`
+	// Output is stdout, stderr or a file path, which is created if needed
+	// and appended to. Empty means stdout.
+	Output string `json:"output"`
+}
+
+// New returns a logger for cfg. The returned Closer releases the log file,
+// if any, and must be called when the logger is no longer used.
+func New(cfg Config) (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, nil, fmt.Errorf("invalid log level %q", cfg.Level)
+		}
+	}
+
+	var w io.Writer
+	var closer io.Closer = io.NopCloser(nil)
+	switch cfg.Output {
+	case "", "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closer = f, f
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("invalid log format %q", cfg.Format)
+	}
+	return slog.New(NewHandler(h)), closer, nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request ID from the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+// NewHandler wraps h so that records logged with a request's context carry
+// a request_id attribute.
+func NewHandler(h slog.Handler) slog.Handler {
+	return contextHandler{h}
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// RequestIDHeader carries the request ID. A well-formed ID sent by the
+// client (for example from a proxy) is reused; otherwise one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLen = 64
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder captures the status code and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Middleware assigns each request an ID, makes it available to handlers via
+// the request context and logs one record per request with its method,
+// path, status, latency and response size. Server errors are logged at
+// error level and client errors at warn level. A nil logger uses
+// slog.Default.
+func Middleware(logger *slog.Logger, next http.Handler) http.Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := WithRequestID(r.Context(), id)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400:
+			level = slog.LevelWarn
+		}
+		logger.Log(ctx, level, "http: request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", rec.bytes,
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
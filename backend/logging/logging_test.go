@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		records = append(records, m)
+	}
+	return records
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	var seen string
+	h := Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+		logger.InfoContext(r.Context(), "handler: called")
+		http.Error(w, "nope", http.StatusNotFound)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/servers/s1", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	id := w.Header().Get(RequestIDHeader)
+	if id == "" || id != seen {
+		t.Fatalf("got header request ID %q and context ID %q, want the same non-empty ID", id, seen)
+	}
+
+	records := decodeLines(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("got %d log records, want 2", len(records))
+	}
+	for _, rec := range records {
+		if rec["request_id"] != id {
+			t.Errorf("record %v: got request_id %v, want %q", rec["msg"], rec["request_id"], id)
+		}
+	}
+	access := records[1]
+	if access["msg"] != "http: request" || access["level"] != "WARN" {
+		t.Errorf("unexpected access record %v", access)
+	}
+	if access["status"] != float64(http.StatusNotFound) || access["method"] != "GET" || access["path"] != "/servers/s1" {
+		t.Errorf("unexpected access record %v", access)
+	}
+	if access["bytes"] != float64(len("nope\n")) {
+		t.Errorf("got bytes %v, want %d", access["bytes"], len("nope\n"))
+	}
+}
+
+func TestMiddleware_RequestIDHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		wantSame bool
+	}{
+		{name: "reuses well-formed ID", incoming: "abc-123.def_4", wantSame: true},
+		{name: "replaces malformed ID", incoming: "bad id\n", wantSame: false},
+		{name: "replaces overlong ID", incoming: strings.Repeat("a", maxRequestIDLen+1), wantSame: false},
+		{name: "generates missing ID", incoming: "", wantSame: false},
+	}
+	logger := slog.New(slog.DiscardHandler)
+	h := Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			got := w.Header().Get(RequestIDHeader)
+			if got == "" {
+				t.Fatal("no request ID in response")
+			}
+			if (got == tt.incoming) != tt.wantSame {
+				t.Errorf("got request ID %q for incoming %q", got, tt.incoming)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	logger, closer, err := New(Config{Level: "warn", Format: "text", Output: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept")
+	closer.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "dropped") || !strings.Contains(string(data), "msg=kept") {
+		t.Errorf("unexpected log file contents %q", data)
+	}
+
+	for _, cfg := range []Config{{Level: "loud"}, {Format: "xml"}} {
+		if _, _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v): expected error", cfg)
+		}
+	}
+}
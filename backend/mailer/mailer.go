@@ -0,0 +1,83 @@
+// Package mailer sends transactional email such as address verification
+// links. Production deployments plug in their own Sender; LogSender and
+// FileSender are meant for local development.
+package mailer
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	// Secrets are parts of Body, such as verification tokens, that must not
+	// end up in logs.
+	Secrets []string
+}
+
+// Sender delivers a Message.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// LogSender writes each message to a structured logger instead of sending
+// it. Secrets in the body are redacted, since logs are often shipped and
+// kept elsewhere; use FileSender to read the full message.
+type LogSender struct {
+	Logger *slog.Logger
+}
+
+func (s LogSender) Send(msg Message) error {
+	l := s.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	body := msg.Body
+	for _, secret := range msg.Secrets {
+		if secret != "" {
+			body = strings.ReplaceAll(body, secret, "[redacted]")
+		}
+	}
+	l.Info("mailer: message", "to", msg.To, "subject", msg.Subject, "body", body)
+	return nil
+}
+
+// FileSender writes each message to its own file in Dir, which is created if
+// it does not exist.
+type FileSender struct {
+	Dir string
+}
+
+var fileSeq atomic.Uint64
+
+func (s FileSender) Send(msg Message) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%d-%s.eml",
+		time.Now().UTC().Format("20060102T150405.000000000"), fileSeq.Add(1), sanitize(msg.To))
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n",
+		msg.To, msg.Subject, time.Now().UTC().Format(time.RFC1123Z), msg.Body)
+	return os.WriteFile(filepath.Join(s.Dir, name), []byte(content), 0644)
+}
+
+// sanitize makes an address safe to use in a file name.
+func sanitize(addr string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		case r == '@':
+			return '_'
+		}
+		return -1
+	}, addr)
+}
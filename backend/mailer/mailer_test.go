@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSender_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	s := FileSender{Dir: dir}
+
+	for i := 0; i < 2; i++ {
+		if err := s.Send(Message{To: "alice@example.com", Subject: "Verify", Body: "token abc"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d files, want 2", len(entries))
+	}
+	if !strings.HasSuffix(entries[0].Name(), "alice_example.com.eml") {
+		t.Errorf("unexpected file name %q", entries[0].Name())
+	}
+	b, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: alice@example.com", "Subject: Verify", "token abc"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("message file missing %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestLogSender_RedactsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	s := LogSender{Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	if err := s.Send(Message{To: "alice@example.com", Subject: "Verify", Body: "your token is abc123", Secrets: []string{"abc123"}}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "abc123") || !strings.Contains(buf.String(), "[redacted]") {
+		t.Errorf("got log %q, want the token redacted", buf.String())
+	}
+}
@@ -1,27 +1,140 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/tonitran/dischord/cache"
+	"github.com/tonitran/dischord/config"
+	"github.com/tonitran/dischord/handlers"
+	"github.com/tonitran/dischord/logging"
+	"github.com/tonitran/dischord/mailer"
+	"github.com/tonitran/dischord/metrics"
+	"github.com/tonitran/dischord/ratelimit"
 	"github.com/tonitran/dischord/router"
 	"github.com/tonitran/dischord/store"
 )
 
 func main() {
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		connStr = "postgres://localhost/dischord?sslmode=disable"
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		config.Usage(os.Stdout)
+		return
 	}
-	s, err := store.Open(connStr)
 	if err != nil {
-		log.Fatal("failed to connect to database: ", err)
+		fmt.Fprintln(os.Stderr, "invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	handler := router.New(s)
-	log.Println("DisChord server starting on :8080")
-	if err := http.ListenAndServe(":8080", handler); err != nil {
-		log.Fatal(err)
+	logger, closer, err := logging.New(cfg.Log)
+	if err != nil {
+		log.Fatal("failed to configure logging: ", err)
+	}
+	defer closer.Close()
+	slog.SetDefault(logger)
+	handlers.SetLogger(logger)
+
+	s, err := store.Open(cfg.Database.URL, cfg.Database.ReplicaURLs...)
+	if err != nil {
+		logger.Error("failed to connect to database", "error", err)
+		os.Exit(1)
+	}
+	defer s.Close()
+	s.QueryTimeout = time.Duration(cfg.Database.QueryTimeout)
+	s.ReadYourWrites = time.Duration(cfg.Database.ReadYourWrites)
+	s.PostRetention = time.Duration(cfg.Posts.Retention)
+	s.SetPoolLimits(cfg.Database.MaxOpenConns, cfg.Database.MaxIdleConns, time.Duration(cfg.Database.ConnMaxLifetime))
+	s.RegisterMetrics(metrics.Default)
+	if cfg.Cache.Size > 0 {
+		s.Cache = cache.NewLRU(cfg.Cache.Size, time.Duration(cfg.Cache.TTL))
+	}
+
+	var m mailer.Sender = mailer.LogSender{Logger: logger}
+	if cfg.MailDir != "" {
+		m = mailer.FileSender{Dir: cfg.MailDir}
+	}
+
+	var limiter *ratelimit.Limiter
+	if cfg.Limits.RateLimiting {
+		limiter = ratelimit.New(ratelimit.NewMemoryBackend(), cfg.Limits.RateLimitTable())
+		limiter.TrustForwardedFor = cfg.Limits.TrustForwardedFor
+	}
+
+	var draining atomic.Bool
+	var handler http.Handler = router.New(s, router.Options{
+		Mailer:      m,
+		RateLimiter: limiter,
+		Logger:      logger,
+		Draining:    draining.Load,
+		CORSOrigins: cfg.CORSOrigins,
+	})
+	if cfg.Limits.MaxBodyBytes > 0 {
+		handler = http.MaxBytesHandler(handler, cfg.Limits.MaxBodyBytes)
+	}
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.HTTP.ReadTimeout),
+		ReadTimeout:       time.Duration(cfg.HTTP.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.HTTP.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.HTTP.IdleTimeout),
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Posts.PurgeInterval > 0 {
+		go s.PurgeEvery(ctx, time.Duration(cfg.Posts.PurgeInterval))
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("DisChord server starting", "addr", srv.Addr, "tls", cfg.TLS.Enabled())
+		if cfg.TLS.Enabled() {
+			serveErr <- srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Fail readiness first and keep serving for the drain delay so load
+	// balancers notice and stop routing new requests, then wait for
+	// in-flight requests to finish.
+	draining.Store(true)
+	if drainDelay := time.Duration(cfg.HTTP.DrainDelay); drainDelay > 0 {
+		logger.Info("draining", "delay", drainDelay.String())
+		time.Sleep(drainDelay)
+	}
+	shutdownTimeout := time.Duration(cfg.HTTP.ShutdownTimeout)
+	logger.Info("shutting down", "timeout", shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("graceful shutdown failed", "error", err)
+		srv.Close()
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("server stopped", "error", err)
 	}
+	logger.Info("server stopped")
 }
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	httpRequests = Default.Counter("dischord_http_requests_total",
+		"HTTP requests served, by route pattern, method and status code.", "route", "method", "status")
+	httpDuration = Default.Histogram("dischord_http_request_duration_seconds",
+		"HTTP request latency, by route pattern and method.", DefBuckets, "route", "method")
+	httpInFlight = Default.Gauge("dischord_http_requests_in_flight",
+		"HTTP requests currently being served.")
+)
+
+// statusRecorder captures the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Middleware records request counts and latency on Default. It must wrap
+// the *http.ServeMux directly: the route label is the pattern the mux
+// matched (for example /servers/{id}), so that label values stay bounded.
+// Requests that match no pattern are labelled "unmatched".
+func Middleware(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		httpInFlight.Add(1)
+		defer httpInFlight.Add(-1)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		route := "unmatched"
+		if r.Pattern != "" {
+			// Patterns carry their method ("GET /servers/{id}"), which
+			// already has its own label.
+			_, path, found := strings.Cut(r.Pattern, " ")
+			if !found {
+				path = r.Pattern
+			}
+			route = path
+		}
+		httpRequests.Inc(route, r.Method, strconv.Itoa(rec.status))
+		httpDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
@@ -0,0 +1,322 @@
+// Package metrics implements counters, gauges and histograms that are
+// exposed in the Prometheus text format. Collectors are registered once, at
+// package initialization, on a Registry (usually Default) and served by
+// Registry.Handler.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are latency histogram bounds in seconds, from 1ms to 10s.
+var DefBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector is a metric family that can write itself in the text format.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds metric families and serves them.
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{collectors: map[string]collector{}}
+}
+
+// Default is the registry used by the backend's packages and served at
+// /metrics.
+var Default = NewRegistry()
+
+// register adds c, replacing any collector with the same name so that
+// GaugeFunc can be re-registered against a new source.
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors[c.name()] = c
+}
+
+// WriteText writes all metric families in the Prometheus text format, sorted
+// by name.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	cs := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		cs = append(cs, c)
+	}
+	r.mu.Unlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].name() < cs[j].name() })
+	for _, c := range cs {
+		c.write(w)
+	}
+}
+
+// Handler serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// desc is the name, help text and label names shared by a metric family.
+type desc struct {
+	metric string
+	help   string
+	labels []string
+}
+
+func (d desc) name() string { return d.metric }
+
+func (d desc) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metric, escapeHelp(d.help), d.metric, typ)
+}
+
+// key joins label values into a map key.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s: got %d label values, want %d", d.metric, len(values), len(d.labels)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats label values as {a="x",b="y"}, with extra appended.
+func (d desc) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.labels[i]+`="`+escapeLabel(v)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a monotonically increasing value per label set.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Counter registers a counter family with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, labels}, values: map[string]float64{}}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter for the label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter for the label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: " + c.metric + ": counter cannot decrease")
+	}
+	k := c.key(labelValues)
+	c.mu.Lock()
+	c.values[k] += v
+	c.mu.Unlock()
+}
+
+// Value returns the counter for the label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	k := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[k]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.header(w, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.metric)
+	}
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metric, c.labelPairs(k), formatFloat(c.values[k]))
+	}
+}
+
+// Gauge is a value per label set that can go up and down.
+type Gauge struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Gauge registers a gauge family with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name, help, labels}, values: map[string]float64{}}
+	r.register(g)
+	return g
+}
+
+// Add adds v to the gauge for the label values.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	k := g.key(labelValues)
+	g.mu.Lock()
+	g.values[k] += v
+	g.mu.Unlock()
+}
+
+// Set sets the gauge for the label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	k := g.key(labelValues)
+	g.mu.Lock()
+	g.values[k] = v
+	g.mu.Unlock()
+}
+
+// Value returns the gauge for the label values.
+func (g *Gauge) Value(labelValues ...string) float64 {
+	k := g.key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[k]
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.header(w, "gauge")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.labels) == 0 && len(g.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", g.metric)
+	}
+	for _, k := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.metric, g.labelPairs(k), formatFloat(g.values[k]))
+	}
+}
+
+// gaugeFunc is an unlabelled gauge whose value is read when scraped.
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// GaugeFunc registers a gauge whose value is computed by fn on each scrape.
+// Registering the same name again replaces the previous function.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{desc: desc{metric: name, help: help}, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metric, formatFloat(g.fn()))
+}
+
+// Histogram counts observations in cumulative buckets per label set.
+type Histogram struct {
+	desc
+	bounds []float64
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // per bucket, not cumulative; last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// Histogram registers a histogram family with the given upper bucket bounds,
+// which must be sorted, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name, help, labels}, bounds: buckets, series: map[string]*histogramSeries{}}
+	r.register(h)
+	return h
+}
+
+// Observe records v for the label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	k := h.key(labelValues)
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.bounds)+1)}
+		h.series[k] = s
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// Count returns the number of observations for the label values.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	k := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[k]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.header(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+		var cumulative uint64
+		for i, n := range s.counts {
+			cumulative += n
+			le := math.Inf(1)
+			if i < len(h.bounds) {
+				le = h.bounds[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metric, h.labelPairs(k, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metric, h.labelPairs(k), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metric, h.labelPairs(k), s.count)
+	}
+}
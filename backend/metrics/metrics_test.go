@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_events_total", "Events seen.", "kind")
+	c.Inc("a")
+	c.Add(2, `quote"d`)
+	g := r.Gauge("test_level", "Current level.")
+	g.Set(3)
+	g.Add(-1)
+	r.GaugeFunc("test_func", "Computed.", func() float64 { return 1.5 })
+	h := r.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+	h.Observe(0.05, "get")
+	h.Observe(0.1, "get")
+	h.Observe(5, "get")
+
+	var b strings.Builder
+	r.WriteText(&b)
+	got := b.String()
+
+	want := []string{
+		"# HELP test_events_total Events seen.\n# TYPE test_events_total counter\n",
+		`test_events_total{kind="a"} 1` + "\n",
+		`test_events_total{kind="quote\"d"} 2` + "\n",
+		"# TYPE test_level gauge\ntest_level 2\n",
+		"test_func 1.5\n",
+		"# TYPE test_latency_seconds histogram\n",
+		`test_latency_seconds_bucket{op="get",le="0.1"} 2` + "\n",
+		`test_latency_seconds_bucket{op="get",le="1"} 2` + "\n",
+		`test_latency_seconds_bucket{op="get",le="+Inf"} 3` + "\n",
+		`test_latency_seconds_sum{op="get"} 5.15` + "\n",
+		`test_latency_seconds_count{op="get"} 3` + "\n",
+	}
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("output missing %q:\n%s", w, got)
+		}
+	}
+	if strings.Index(got, "test_events_total") > strings.Index(got, "test_level") {
+		t.Error("families are not sorted by name")
+	}
+}
+
+func TestCounter_WrongLabelCount(t *testing.T) {
+	c := NewRegistry().Counter("test_total", "Test.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for wrong number of label values")
+		}
+	}()
+	c.Inc("only-one")
+}
+
+func TestMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "missing", http.StatusNotFound)
+	})
+	h := Middleware(mux)
+
+	before := httpRequests.Value("/things/{id}", "GET", "404")
+	unmatched := httpRequests.Value("unmatched", "GET", "404")
+	for _, path := range []string{"/things/1", "/things/2", "/elsewhere"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := httpRequests.Value("/things/{id}", "GET", "404") - before; got != 2 {
+		t.Errorf("got %v requests for the route pattern, want 2", got)
+	}
+	if got := httpRequests.Value("unmatched", "GET", "404") - unmatched; got != 1 {
+		t.Errorf("got %v unmatched requests, want 1", got)
+	}
+	if httpDuration.Count("/things/{id}", "GET") < 2 {
+		t.Error("latency was not observed")
+	}
+	if httpInFlight.Value() != 0 {
+		t.Errorf("got %v requests in flight, want 0", httpInFlight.Value())
+	}
+}
@@ -3,11 +3,49 @@ package models
 import "time"
 
 type User struct {
-	ID        string    `json:"user_id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	ServerIDs []string  `json:"server_ids"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            string    `json:"user_id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	DisplayName   string    `json:"display_name"`
+	AvatarURL     string    `json:"avatar_url"`
+	Bio           string    `json:"bio"`
+	Status        string    `json:"status"`
+	ServerIDs     []string  `json:"server_ids"`
+	CreatedAt     time.Time `json:"created_at"`
+	// UpdatedAt changes with anything in this view of the account; it backs
+	// the user's ETag and Last-Modified.
+	UpdatedAt time.Time `json:"-"`
+}
+
+// PublicUser is the profile anyone may see. Email addresses and server
+// memberships are only exposed to the user themselves through User.
+type PublicUser struct {
+	ID          string    `json:"user_id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+	Bio         string    `json:"bio"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Public returns the publicly visible part of u. Users who set themselves
+// invisible are shown without a status.
+func (u User) Public() PublicUser {
+	status := u.Status
+	if status == "invisible" {
+		status = ""
+	}
+	return PublicUser{
+		ID:          u.ID,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		AvatarURL:   u.AvatarURL,
+		Bio:         u.Bio,
+		Status:      status,
+		CreatedAt:   u.CreatedAt,
+	}
 }
 
 type FriendRequest struct {
@@ -15,6 +53,32 @@ type FriendRequest struct {
 	FriendID string `json:"friend_id"`
 }
 
+// ServerSummary describes a server in listings without its member and post
+// IDs.
+type ServerSummary struct {
+	ID          string   `json:"server_id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	IconURL     string   `json:"icon_url"`
+	Tags        []string `json:"tags"`
+	MemberCount int      `json:"member_count"`
+	// LastActivityAt is when the latest post or message was made, or when
+	// the server was created if it has none.
+	LastActivityAt time.Time `json:"last_activity_at"`
+}
+
+// UserServer is a server as listed for one of its members, in the order
+// they have arranged their servers.
+type UserServer struct {
+	ServerSummary
+	Position int `json:"position"`
+	// UnreadCount is the number of messages from other members since the
+	// user last read the server, and MentionCount how many of those
+	// mention them.
+	UnreadCount  int `json:"unread_count"`
+	MentionCount int `json:"mention_count"`
+}
+
 type Post struct {
 	ID        string    `json:"post_id"`
 	ServerID  string    `json:"server_id"`
@@ -22,8 +86,28 @@ type Post struct {
 	Title     string    `json:"title"`
 	Body      string    `json:"body"`
 	Votes     int       `json:"votes"`
+	Upvotes   int       `json:"upvotes"`
+	Downvotes int       `json:"downvotes"`
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt and DeletedBy are set while the post is deleted but can
+	// still be restored.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+	// ModifiedAt changes with every edit and vote; it backs the post's ETag
+	// and Last-Modified.
+	ModifiedAt time.Time `json:"-"`
+}
+
+// PostRevision is the title and body of one version of a post.
+type PostRevision struct {
+	PostID   string    `json:"post_id"`
+	Version  int       `json:"version"`
+	Title    string    `json:"title"`
+	Body     string    `json:"body"`
+	EditedBy string    `json:"edited_by"`
+	EditedAt time.Time `json:"edited_at"`
 }
 
 type Vote struct {
@@ -32,13 +116,33 @@ type Vote struct {
 	Vote     int    `json:"vote"`
 }
 
+// VoteSummary is the vote breakdown for a single post. Upvoters and
+// Downvoters are only populated for server moderators.
+type VoteSummary struct {
+	PostID     string   `json:"post_id"`
+	Score      int      `json:"score"`
+	Upvotes    int      `json:"upvotes"`
+	Downvotes  int      `json:"downvotes"`
+	Upvoters   []string `json:"upvoters,omitempty"`
+	Downvoters []string `json:"downvoters,omitempty"`
+}
+
 type Server struct {
-	ID        string    `json:"server_id"`
-	Name      string    `json:"name"`
-	OwnerID   string    `json:"owner_id"`
-	MemberIDs []string  `json:"member_ids"`
-	Posts     []string  `json:"post_ids"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          string   `json:"server_id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	IconURL     string   `json:"icon_url"`
+	Tags        []string `json:"tags"`
+	// Visibility is "public" for servers listed in discovery, or "private".
+	Visibility string   `json:"visibility"`
+	OwnerID    string   `json:"owner_id"`
+	MemberIDs  []string `json:"member_ids"`
+	Posts      []string `json:"post_ids"`
+	// SlowModeSeconds is the minimum gap between a member's messages; 0 is off.
+	SlowModeSeconds int       `json:"slow_mode_seconds"`
+	CreatedAt       time.Time `json:"created_at"`
+	// UpdatedAt changes with the server's settings, members and post list.
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type Message struct {
@@ -48,3 +152,52 @@ type Message struct {
 	Content   string    `json:"content"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// ReadState is how far a member has read a server's messages.
+type ReadState struct {
+	ServerID          string    `json:"server_id"`
+	LastReadMessageID string    `json:"last_read_message_id"`
+	LastReadAt        time.Time `json:"last_read_at"`
+	UnreadCount       int       `json:"unread_count"`
+	MentionCount      int       `json:"mention_count"`
+}
+
+// Flag is a post or message the server's content filter queued for review.
+type Flag struct {
+	ID          string     `json:"flag_id"`
+	ServerID    string     `json:"server_id"`
+	ContentType string     `json:"content_type"`
+	ContentID   string     `json:"content_id"`
+	AuthorID    string     `json:"author_id"`
+	Content     string     `json:"content"`
+	Reasons     []string   `json:"reasons"`
+	Status      string     `json:"status"`
+	ReviewedBy  string     `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Report is a member's complaint about a post, message or user in a server.
+type Report struct {
+	ID         string     `json:"report_id"`
+	ServerID   string     `json:"server_id"`
+	ReporterID string     `json:"reporter_id"`
+	TargetType string     `json:"target_type"`
+	TargetID   string     `json:"target_id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details"`
+	Status     string     `json:"status"`
+	Action     string     `json:"action,omitempty"`
+	ResolvedBy string     `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ReportCounts summarizes a server's moderation workload.
+type ReportCounts struct {
+	Open         int            `json:"open"`
+	OpenByReason map[string]int `json:"open_by_reason"`
+	Resolved     int            `json:"resolved"`
+	Dismissed    int            `json:"dismissed"`
+	PendingFlags int            `json:"pending_flags"`
+}
@@ -0,0 +1,196 @@
+// Package ratelimit throttles requests with token buckets keyed by user and
+// client IP. Buckets live in a pluggable Backend; MemoryBackend keeps them in
+// process, which is enough for a single backend instance.
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket budget: up to Burst requests at once, refilled at
+// one request per Interval.
+type Limit struct {
+	Burst    int
+	Interval time.Duration
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+	// Limit is the bucket's burst size.
+	Limit int
+	// Remaining is the number of whole tokens left after this request.
+	Remaining int
+	// RetryAfter is how long until a token is available; zero when Allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Backend stores token buckets.
+type Backend interface {
+	// Take removes one token from the bucket for key, creating it full if
+	// it does not exist yet.
+	Take(key string, limit Limit) Result
+}
+
+// Class groups routes that share a budget.
+type Class string
+
+const (
+	Chat    Class = "chat"
+	Posting Class = "posting"
+	Voting  Class = "voting"
+	Account Class = "account"
+)
+
+// DefaultLimits are the per-class budgets used by the backend binary.
+var DefaultLimits = map[Class]Limit{
+	Chat:    {Burst: 10, Interval: time.Second},
+	Posting: {Burst: 5, Interval: 10 * time.Second},
+	Voting:  {Burst: 30, Interval: 500 * time.Millisecond},
+	Account: {Burst: 3, Interval: time.Minute},
+}
+
+// UserIDHeader identifies the calling user; it matches handlers.UserIDHeader.
+const UserIDHeader = "X-User-ID"
+
+// Limiter applies per-class limits to handlers. Each request takes a token
+// from its client IP's bucket and, when the caller is identified, from the
+// user's bucket too; either running dry rejects the request with 429.
+type Limiter struct {
+	Backend Backend
+	Limits  map[Class]Limit
+	// TrustForwardedFor takes the client IP from X-Forwarded-For instead of
+	// the connection. Only enable behind a proxy that sets it.
+	TrustForwardedFor bool
+}
+
+// New returns a Limiter backed by b with the given limits.
+func New(b Backend, limits map[Class]Limit) *Limiter {
+	return &Limiter{Backend: b, Limits: limits}
+}
+
+// Wrap rate limits next under class. A nil Limiter, or a class without a
+// configured limit, passes requests straight through.
+func (l *Limiter) Wrap(class Class, next http.HandlerFunc) http.Handler {
+	if l == nil {
+		return next
+	}
+	limit, ok := l.Limits[class]
+	if !ok || limit.Burst <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := l.Backend.Take(string(class)+":ip:"+l.clientIP(r), limit)
+		if user := strings.TrimSpace(r.Header.Get(UserIDHeader)); res.Allowed && user != "" {
+			res = l.Backend.Take(string(class)+":user:"+user, limit)
+		}
+		setHeaders(w, res)
+		if !res.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (l *Limiter) clientIP(r *http.Request) string {
+	if l.TrustForwardedFor {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func setHeaders(w http.ResponseWriter, res Result) {
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryBackend keeps buckets in memory. Buckets that have refilled
+// completely are swept periodically so idle clients don't accumulate.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// now is overridable for tests.
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// sweepInterval is how often MemoryBackend drops full buckets.
+const sweepInterval = time.Minute
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (m *MemoryBackend) Take(key string, limit Limit) Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		m.buckets[key] = b
+	}
+	b.refill(now)
+
+	res := Result{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) * float64(limit.Interval))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((float64(limit.Burst) - b.tokens) * float64(limit.Interval))
+	return res
+}
+
+func (m *MemoryBackend) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 && b.limit.Interval > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+float64(elapsed)/float64(b.limit.Interval))
+	}
+	b.last = now
+}
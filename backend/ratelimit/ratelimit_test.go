@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestBackend() (*MemoryBackend, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewMemoryBackend()
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestMemoryBackend_Take(t *testing.T) {
+	b, now := newTestBackend()
+	limit := Limit{Burst: 2, Interval: time.Second}
+
+	for i, want := range []bool{true, true, false} {
+		if got := b.Take("k", limit); got.Allowed != want {
+			t.Fatalf("take %d: got allowed=%v, want %v", i, got.Allowed, want)
+		}
+	}
+
+	res := b.Take("k", limit)
+	if res.RetryAfter != time.Second {
+		t.Errorf("got RetryAfter %v, want 1s", res.RetryAfter)
+	}
+
+	*now = now.Add(1500 * time.Millisecond)
+	res = b.Take("k", limit)
+	if !res.Allowed {
+		t.Fatal("expected a token after refilling")
+	}
+	if res.Remaining != 0 {
+		t.Errorf("got Remaining %d, want 0", res.Remaining)
+	}
+
+	if got := b.Take("other", limit); !got.Allowed || got.Remaining != 1 {
+		t.Errorf("separate key: got %+v", got)
+	}
+}
+
+func TestMemoryBackend_SweepsFullBuckets(t *testing.T) {
+	b, now := newTestBackend()
+	limit := Limit{Burst: 1, Interval: time.Second}
+	b.Take("a", limit)
+
+	*now = now.Add(2 * sweepInterval)
+	b.Take("b", limit)
+	if _, ok := b.buckets["a"]; ok {
+		t.Error("expected refilled bucket to be swept")
+	}
+}
+
+func TestLimiter_Wrap(t *testing.T) {
+	backend, _ := newTestBackend()
+	l := New(backend, map[Class]Limit{Chat: {Burst: 1, Interval: time.Minute}})
+	h := l.Wrap(Chat, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	send := func(user, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/servers/s1/messages", nil)
+		req.RemoteAddr = addr
+		if user != "" {
+			req.Header.Set(UserIDHeader, user)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("u1", "10.0.0.1:1234")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("first request: got status %d, want %d", w.Code, http.StatusCreated)
+	}
+	if got := w.Header().Get("X-RateLimit-Limit"); got != "1" {
+		t.Errorf("got X-RateLimit-Limit %q, want 1", got)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("got X-RateLimit-Remaining %q, want 0", got)
+	}
+
+	w = send("u1", "10.0.0.2:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("same user from another IP: got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("got Retry-After %q, want 60", got)
+	}
+
+	if w := send("u2", "10.0.0.1:1234"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("other user from a throttled IP: got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w := send("", "10.0.0.3:1234"); w.Code != http.StatusCreated {
+		t.Errorf("anonymous from a fresh IP: got status %d, want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestLimiter_WrapUnconfigured(t *testing.T) {
+	var l *Limiter
+	called := false
+	l.Wrap(Chat, func(w http.ResponseWriter, r *http.Request) { called = true }).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !called {
+		t.Error("nil limiter should pass requests through")
+	}
+}
@@ -0,0 +1,34 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/tonitran/dischord/handlers"
+	"github.com/tonitran/dischord/store"
+)
+
+// readYourWrites tells the store which user each request is made by. A
+// request that may change something reads from the primary throughout, so
+// it sees its own writes, and afterwards the write is noted so that the
+// user's following reads go to the primary too (see store.ReadYourWrites).
+func readYourWrites(s *store.Database, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		write := false
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			write = true
+			ctx = store.WithPrimary(ctx)
+		}
+		user := strings.TrimSpace(r.Header.Get(handlers.UserIDHeader))
+		if user != "" {
+			ctx = store.WithUser(ctx, user)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+		if write {
+			s.NoteWrite(user)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		name       string
+		origins    []string
+		method     string
+		origin     string
+		wantStatus int
+		wantAllow  string
+	}{
+		{name: "allowed origin", origins: []string{"https://app.example"}, method: http.MethodGet, origin: "https://app.example", wantStatus: http.StatusTeapot, wantAllow: "https://app.example"},
+		{name: "other origin", origins: []string{"https://app.example"}, method: http.MethodGet, origin: "https://evil.example", wantStatus: http.StatusTeapot},
+		{name: "wildcard", origins: []string{"*"}, method: http.MethodGet, origin: "https://any.example", wantStatus: http.StatusTeapot, wantAllow: "https://any.example"},
+		{name: "preflight", origins: []string{"*"}, method: http.MethodOptions, origin: "https://any.example", wantStatus: http.StatusNoContent, wantAllow: "https://any.example"},
+		{name: "disabled", origins: nil, method: http.MethodGet, origin: "https://app.example", wantStatus: http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/servers", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			cors(tt.origins, next).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
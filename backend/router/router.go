@@ -1,49 +1,111 @@
 package router
 
 import (
+	"log/slog"
 	"net/http"
 
 	"github.com/tonitran/dischord/handlers"
+	"github.com/tonitran/dischord/logging"
+	"github.com/tonitran/dischord/mailer"
+	"github.com/tonitran/dischord/metrics"
+	"github.com/tonitran/dischord/ratelimit"
 	"github.com/tonitran/dischord/store"
 )
 
-func New(s *store.Database) http.Handler {
+// Options configures the optional collaborators of the router. The zero
+// value logs verification emails and requests to slog.Default and applies no
+// rate limits.
+type Options struct {
+	Mailer      mailer.Sender
+	RateLimiter *ratelimit.Limiter
+	Logger      *slog.Logger
+	// Draining reports whether the server is shutting down, which makes
+	// /readyz fail. Nil means never.
+	Draining func() bool
+	// CORSOrigins are the browser origins allowed to call the API; "*"
+	// allows any. Empty sends no CORS headers.
+	CORSOrigins []string
+}
+
+func New(s *store.Database, opts Options) http.Handler {
 	mux := http.NewServeMux()
+	limit := opts.RateLimiter
 
-	users := &handlers.UserHandler{Store: s}
+	users := &handlers.UserHandler{Store: s, Mailer: opts.Mailer}
 	friends := &handlers.FriendHandler{Store: s}
 	posts := &handlers.PostHandler{Store: s}
 	votes := &handlers.VoteHandler{Store: s}
 	servers := &handlers.ServerHandler{Store: s}
 	messages := &handlers.MessageHandler{Store: s}
+	moderation := &handlers.ModerationHandler{Store: s}
+	reports := &handlers.ReportHandler{Store: s}
+	health := &handlers.HealthHandler{Store: s, Draining: opts.Draining}
 
 	// Servers
-	mux.HandleFunc("POST /servers", servers.Create)
+	mux.Handle("POST /servers", limit.Wrap(ratelimit.Posting, servers.Create))
+	mux.HandleFunc("GET /servers", servers.List)
 	mux.HandleFunc("GET /servers/{id}", servers.Get)
+	mux.HandleFunc("PATCH /servers/{id}", servers.Update)
+	mux.HandleFunc("DELETE /servers/{id}", servers.Delete)
 	mux.HandleFunc("POST /servers/{id}/members", servers.Join)
 	mux.HandleFunc("GET /servers/{id}/members", servers.ListMembers)
+	mux.HandleFunc("PUT /servers/{id}/members/{user_id}/role", servers.SetMemberRole)
+	mux.HandleFunc("PUT /servers/{id}/slow-mode", servers.SetSlowMode)
 
 	// Posts
-	mux.HandleFunc("POST /servers/{server_id}/posts", posts.Create)
+	mux.HandleFunc("GET /posts", posts.GetMany)
+	mux.Handle("POST /servers/{server_id}/posts", limit.Wrap(ratelimit.Posting, posts.Create))
 	mux.HandleFunc("GET /servers/{server_id}/posts/{id}", posts.Get)
-	mux.HandleFunc("PUT /servers/{server_id}/posts/{id}", posts.Update)
+	mux.Handle("PUT /servers/{server_id}/posts/{id}", limit.Wrap(ratelimit.Posting, posts.Update))
 	mux.HandleFunc("DELETE /servers/{server_id}/posts/{id}", posts.Delete)
+	mux.HandleFunc("GET /servers/{server_id}/posts/{id}/revisions", posts.Revisions)
+	mux.HandleFunc("GET /servers/{server_id}/posts/deleted", posts.ListDeleted)
+	mux.HandleFunc("POST /servers/{server_id}/posts/{id}/restore", posts.Restore)
 
 	// Votes
 	mux.HandleFunc("GET /servers/{server_id}/posts/{id}/vote", votes.GetVote)
-	mux.HandleFunc("PUT /servers/{server_id}/posts/{id}/vote", votes.PutVote)
+	mux.Handle("PUT /servers/{server_id}/posts/{id}/vote", limit.Wrap(ratelimit.Voting, votes.PutVote))
+	mux.HandleFunc("GET /servers/{server_id}/posts/{id}/votes", votes.ListVotes)
 
 	// Users
-	mux.HandleFunc("POST /users", users.Create)
+	mux.Handle("POST /users", limit.Wrap(ratelimit.Account, users.Create))
+	mux.HandleFunc("GET /users", users.GetMany)
 	mux.HandleFunc("GET /users/{id}", users.Get)
+	mux.HandleFunc("PATCH /users/{id}", users.Update)
+	mux.HandleFunc("DELETE /users/{id}", users.Delete)
+	mux.HandleFunc("GET /users/{id}/servers", users.ListServers)
+	mux.HandleFunc("PUT /users/{id}/servers/order", users.ReorderServers)
+	mux.Handle("PUT /users/{id}/email", limit.Wrap(ratelimit.Account, users.ChangeEmail))
+	mux.HandleFunc("POST /users/{id}/email/verify", users.VerifyEmail)
+	mux.Handle("POST /users/{id}/email/verification", limit.Wrap(ratelimit.Account, users.ResendVerification))
 
 	// Friends
 	mux.HandleFunc("POST /users/{id}/friends", friends.Add)
 	mux.HandleFunc("GET /users/{id}/friends", friends.List)
 
 	// Messages
-	mux.HandleFunc("POST /servers/{server_id}/messages", messages.Create)
+	mux.Handle("POST /servers/{server_id}/messages", limit.Wrap(ratelimit.Chat, messages.Create))
 	mux.HandleFunc("GET /servers/{server_id}/messages", messages.ListByServer)
+	mux.HandleFunc("POST /servers/{server_id}/messages/{id}/ack", messages.Ack)
+
+	// Moderation
+	mux.HandleFunc("GET /servers/{id}/filter", moderation.GetFilter)
+	mux.HandleFunc("PUT /servers/{id}/filter", moderation.PutFilter)
+	mux.HandleFunc("GET /servers/{id}/flags", moderation.ListFlags)
+	mux.HandleFunc("POST /servers/{id}/flags/{flag_id}/resolve", moderation.ResolveFlag)
+
+	// Reports
+	mux.Handle("POST /servers/{id}/reports", limit.Wrap(ratelimit.Posting, reports.Create))
+	mux.HandleFunc("GET /servers/{id}/reports", reports.List)
+	mux.HandleFunc("GET /servers/{id}/reports/counts", reports.Counts)
+	mux.HandleFunc("POST /servers/{id}/reports/{report_id}/resolve", reports.Resolve)
+
+	// Health
+	mux.HandleFunc("GET /healthz", health.Healthz)
+	mux.HandleFunc("GET /readyz", health.Readyz)
+
+	// Metrics
+	mux.Handle("GET /metrics", metrics.Default.Handler())
 
-	return mux
+	return logging.Middleware(opts.Logger, cors(opts.CORSOrigins, readYourWrites(s, metrics.Middleware(mux))))
 }
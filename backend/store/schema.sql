@@ -1,27 +1,137 @@
 -- DisChord schema. Applied automatically on startup via store.ApplySchema.
 
 CREATE TABLE IF NOT EXISTS users (
-    id         TEXT PRIMARY KEY,
-    username   TEXT NOT NULL DEFAULT '',
-    email      TEXT NOT NULL DEFAULT '',
-    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    id           TEXT PRIMARY KEY,
+    username     TEXT NOT NULL DEFAULT '',
+    email        TEXT NOT NULL DEFAULT '',
+    display_name TEXT NOT NULL DEFAULT '',
+    avatar_url   TEXT NOT NULL DEFAULT '',
+    bio          TEXT NOT NULL DEFAULT '',
+    status       TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url   TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio          TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status       TEXT NOT NULL DEFAULT '';
+-- updated_at changes with anything in the user's own view of their account,
+-- including server memberships; it backs the user's ETag and Last-Modified.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Usernames are unique regardless of case.
+DO $$
+BEGIN
+    -- Databases from before usernames were unique may hold names that
+    -- differ only in case. Keep the oldest and suffix the rest with their
+    -- ID so the index can be built; those users can pick a new name.
+    IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'users_username_lower_idx') THEN
+        UPDATE users u SET username = LEFT(u.username, 23) || '-' || LEFT(u.id, 8), updated_at = NOW()
+        FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY LOWER(username) ORDER BY created_at, id) AS n
+              FROM users WHERE username <> '') dup
+        WHERE u.id = dup.id AND dup.n > 1;
+    END IF;
+END $$;
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_idx
+    ON users (LOWER(username)) WHERE username <> '';
+
+-- Accounts that predate verification are treated as verified: the column is
+-- added with a TRUE default, then new accounts default to FALSE.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT FALSE;
+
+-- Emails are stored lowercased and are unique regardless of case.
+DO $$
+BEGIN
+    -- Likewise for emails registered more than once in different cases:
+    -- the verified or oldest account keeps the address, the others lose it
+    -- and must register it again.
+    IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'users_email_lower_idx') THEN
+        UPDATE users u SET email = '', email_verified = FALSE, updated_at = NOW()
+        FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY LOWER(email) ORDER BY email_verified DESC, created_at, id) AS n
+              FROM users WHERE email <> '') dup
+        WHERE u.id = dup.id AND dup.n > 1;
+    END IF;
+END $$;
+UPDATE users SET email = LOWER(email) WHERE email <> LOWER(email);
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx
+    ON users (LOWER(email)) WHERE email <> '';
+
+-- Outstanding email verification tokens; only the SHA-256 of each token is stored.
+CREATE TABLE IF NOT EXISTS email_verifications (
+    token_hash TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email      TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS servers (
-    id         TEXT PRIMARY KEY,
-    name       TEXT NOT NULL DEFAULT '',
-    owner_id   TEXT NOT NULL DEFAULT '',
-    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    id                TEXT PRIMARY KEY,
+    name              TEXT NOT NULL DEFAULT '',
+    owner_id          TEXT NOT NULL DEFAULT '',
+    slow_mode_seconds INTEGER NOT NULL DEFAULT 0,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    description       TEXT NOT NULL DEFAULT '',
+    icon_url          TEXT NOT NULL DEFAULT '',
+    tags              TEXT[] NOT NULL DEFAULT '{}',
+    visibility        TEXT NOT NULL DEFAULT 'private'
 );
 
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS slow_mode_seconds INTEGER NOT NULL DEFAULT 0;
+-- updated_at changes with the server's settings, members and post list.
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS icon_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+-- Only public servers are listed in discovery.
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'private';
+CREATE INDEX IF NOT EXISTS servers_tags_idx ON servers USING GIN (tags);
+
 CREATE TABLE IF NOT EXISTS posts (
     id         TEXT PRIMARY KEY,
     server_id  TEXT NOT NULL DEFAULT '',
     author_id  TEXT NOT NULL DEFAULT '',
     title      TEXT NOT NULL DEFAULT '',
     body       TEXT NOT NULL DEFAULT '',
+    score      INTEGER NOT NULL DEFAULT 0,
+    upvotes    INTEGER NOT NULL DEFAULT 0,
+    downvotes  INTEGER NOT NULL DEFAULT 0,
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    version    INTEGER NOT NULL DEFAULT 1,
+    deleted_at TIMESTAMPTZ,
+    deleted_by TEXT NOT NULL DEFAULT ''
+);
+
+-- Columns added after the initial release; kept for databases created earlier.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS score     INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS upvotes   INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS downvotes INTEGER NOT NULL DEFAULT 0;
+-- updated_at is when the author last edited the post; modified_at also
+-- moves with votes and backs the post's ETag and Last-Modified.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS modified_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+-- version goes up with each edit; edits name the version they were made
+-- against so that concurrent edits conflict instead of overwriting.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+-- Deleted posts keep their row until they are purged after the retention
+-- window; until then moderators can restore them.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_by TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS posts_deleted_idx ON posts (server_id, deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Every version of each edited post. The version a post had before its
+-- first edit is saved when that edit is made.
+CREATE TABLE IF NOT EXISTS post_revisions (
+    post_id   TEXT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    version   INTEGER NOT NULL,
+    title     TEXT NOT NULL,
+    body      TEXT NOT NULL,
+    edited_by TEXT NOT NULL,
+    edited_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (post_id, version)
 );
 
 CREATE TABLE IF NOT EXISTS votes (
@@ -38,11 +148,23 @@ CREATE TABLE IF NOT EXISTS friends (
 );
 
 CREATE TABLE IF NOT EXISTS server_user (
-    server_id TEXT NOT NULL REFERENCES servers(id),
-    user_id   TEXT NOT NULL REFERENCES users(id),
+    server_id            TEXT NOT NULL REFERENCES servers(id),
+    user_id              TEXT NOT NULL REFERENCES users(id),
+    role                 TEXT NOT NULL DEFAULT 'member',
+    position             INTEGER NOT NULL DEFAULT 0,
+    last_read_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_read_message_id TEXT NOT NULL DEFAULT '',
     PRIMARY KEY (server_id, user_id)
 );
 
+ALTER TABLE server_user ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member';
+-- position orders a user's own server list. last_read_message_id is the
+-- latest message they have read and last_read_at when it was sent, or when
+-- they joined if they haven't read any.
+ALTER TABLE server_user ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE server_user ADD COLUMN IF NOT EXISTS last_read_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE server_user ADD COLUMN IF NOT EXISTS last_read_message_id TEXT NOT NULL DEFAULT '';
+
 CREATE TABLE IF NOT EXISTS messages (
     id         TEXT PRIMARY KEY,
     server_id  TEXT NOT NULL DEFAULT '',
@@ -50,3 +172,75 @@ CREATE TABLE IF NOT EXISTS messages (
     content    TEXT NOT NULL DEFAULT '',
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
+
+CREATE INDEX IF NOT EXISTS messages_server_created_idx ON messages (server_id, created_at);
+-- Slow mode looks up each author's latest message in a server.
+CREATE INDEX IF NOT EXISTS messages_server_author_created_idx ON messages (server_id, author_id, created_at);
+
+-- Members @mentioned in each message, copied from the message so mention
+-- counts don't need to scan message content.
+CREATE TABLE IF NOT EXISTS message_mentions (
+    message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id    TEXT NOT NULL,
+    server_id  TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_mentions_user_idx ON message_mentions (user_id, server_id, created_at);
+
+-- Per-server content filter configuration (see the filter package).
+CREATE TABLE IF NOT EXISTS server_filters (
+    server_id  TEXT PRIMARY KEY REFERENCES servers(id),
+    rules      JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Posts and messages the content filter flagged for moderator review.
+CREATE TABLE IF NOT EXISTS content_flags (
+    id           TEXT PRIMARY KEY,
+    server_id    TEXT NOT NULL,
+    content_type TEXT NOT NULL,            -- 'post' or 'message'
+    content_id   TEXT NOT NULL,
+    author_id    TEXT NOT NULL DEFAULT '',
+    content      TEXT NOT NULL DEFAULT '',
+    reasons      TEXT[] NOT NULL DEFAULT '{}',
+    status       TEXT NOT NULL DEFAULT 'pending', -- 'pending', 'approved' or 'removed'
+    reviewed_by  TEXT NOT NULL DEFAULT '',
+    reviewed_at  TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS content_flags_server_status_idx ON content_flags (server_id, status, created_at);
+
+-- Member reports about posts, messages and users.
+CREATE TABLE IF NOT EXISTS reports (
+    id          TEXT PRIMARY KEY,
+    server_id   TEXT NOT NULL,
+    reporter_id TEXT NOT NULL,
+    target_type TEXT NOT NULL,               -- 'post', 'message' or 'user'
+    target_id   TEXT NOT NULL,
+    reason      TEXT NOT NULL,               -- spam, harassment, hate, nsfw, other
+    details     TEXT NOT NULL DEFAULT '',
+    status      TEXT NOT NULL DEFAULT 'open', -- 'open', 'resolved' or 'dismissed'
+    action      TEXT NOT NULL DEFAULT '',     -- none, delete_content, kick, ban
+    resolved_by TEXT NOT NULL DEFAULT '',
+    resolved_at TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS reports_server_status_idx ON reports (server_id, status, created_at);
+
+-- A reporter can only have one open report per target.
+CREATE UNIQUE INDEX IF NOT EXISTS reports_open_unique_idx
+    ON reports (reporter_id, target_type, target_id) WHERE status = 'open';
+
+-- Users banned from a server cannot rejoin it.
+CREATE TABLE IF NOT EXISTS server_bans (
+    server_id  TEXT NOT NULL REFERENCES servers(id),
+    user_id    TEXT NOT NULL,
+    banned_by  TEXT NOT NULL DEFAULT '',
+    reason     TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (server_id, user_id)
+);
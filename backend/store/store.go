@@ -1,20 +1,60 @@
 package store
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/lib/pq"
+	"github.com/tonitran/dischord/cache"
+	"github.com/tonitran/dischord/filter"
+	"github.com/tonitran/dischord/metrics"
 	"github.com/tonitran/dischord/models"
 )
 
 type Database struct {
 	db *sql.DB
+	// replicas serve the read-only methods while healthy; see read.
+	replicas   []*replica
+	next       atomic.Uint32 // round-robin position in replicas
+	stopChecks context.CancelFunc
+	writes     writeTracker
+
+	// ReadYourWrites, when positive, sends a user's reads to the primary for
+	// this long after each of their writes (see WithUser and NoteWrite), so
+	// they see their own changes despite replication lag.
+	ReadYourWrites time.Duration
+	// QueryTimeout bounds each store method, on top of any deadline on the
+	// caller's context. Zero means no timeout.
+	QueryTimeout time.Duration
+	// Cache, when set, serves post and server reads and is invalidated by
+	// the writes that change them. Nil disables caching.
+	Cache cache.Cache
+	// PostRetention is how long a deleted post can be restored before
+	// PurgeDeletedPosts removes it for good.
+	PostRetention time.Duration
+
+	// failAt, when set by tests, is called at each step of a multi-statement
+	// operation; a non-nil error aborts the operation there.
+	failAt func(step string) error
 }
 
-// Open opens a Postgres connection, applies the schema, and returns a Store.
-func Open(connStr string) (*Database, error) {
+// Open opens a Postgres connection to the primary, applies the schema, and
+// returns a Store. Read-only methods are spread over the optional replicas.
+// A replica that cannot be reached yet is not an error; it is used once a
+// health check succeeds.
+func Open(connStr string, replicaConnStrs ...string) (*Database, error) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, err
@@ -27,37 +67,476 @@ func Open(connStr string) (*Database, error) {
 		db.Close()
 		return nil, err
 	}
-	return New(db), nil
+	replicas := make([]*sql.DB, 0, len(replicaConnStrs))
+	for _, connStr := range replicaConnStrs {
+		r, err := sql.Open("postgres", connStr)
+		if err != nil {
+			db.Close()
+			for _, r := range replicas {
+				r.Close()
+			}
+			return nil, err
+		}
+		replicas = append(replicas, r)
+	}
+	s := New(db, replicas...)
+	s.checkReplicas(context.Background())
+	return s, nil
+}
+
+// New wraps existing connection pools for the primary and any replicas.
+// Schema must be applied separately via ApplySchema. Replicas are assumed
+// healthy until the first health check says otherwise.
+func New(db *sql.DB, replicas ...*sql.DB) *Database {
+	s := &Database{db: db, PostRetention: DefaultPostRetention}
+	for _, r := range replicas {
+		rep := &replica{db: r}
+		rep.healthy.Store(true)
+		s.replicas = append(s.replicas, rep)
+	}
+	if len(s.replicas) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopChecks = cancel
+		go s.watchReplicas(ctx)
+	}
+	return s
+}
+
+// SetPoolLimits sizes the connection pool of the primary and of each
+// replica. Zero values keep the database/sql defaults.
+func (s *Database) SetPoolLimits(maxOpen, maxIdle int, maxLifetime time.Duration) {
+	pools := []*sql.DB{s.db}
+	for _, r := range s.replicas {
+		pools = append(pools, r.db)
+	}
+	for _, db := range pools {
+		if maxOpen > 0 {
+			db.SetMaxOpenConns(maxOpen)
+		}
+		if maxIdle > 0 {
+			db.SetMaxIdleConns(maxIdle)
+		}
+		if maxLifetime > 0 {
+			db.SetConnMaxLifetime(maxLifetime)
+		}
+	}
+}
+
+// --- Replicas ---
+
+// replica is a read-only connection pool and the result of its last health
+// check.
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+const (
+	// replicaCheckInterval is how often replicas are pinged.
+	replicaCheckInterval = 5 * time.Second
+	// replicaCheckTimeout bounds each ping.
+	replicaCheckTimeout = 2 * time.Second
+)
+
+var readsTotal = metrics.Default.Counter("dischord_store_reads_total",
+	"Read-only store queries, by the database that served them: primary or replica.", "target")
+
+// watchReplicas checks the replicas every replicaCheckInterval until ctx is
+// done.
+func (s *Database) watchReplicas(ctx context.Context) {
+	t := time.NewTicker(replicaCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.checkReplicas(ctx)
+		}
+	}
+}
+
+// checkReplicas pings every replica and records which ones answered,
+// logging each change of state.
+func (s *Database) checkReplicas(ctx context.Context) {
+	for i, r := range s.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+		err := r.db.PingContext(pingCtx)
+		cancel()
+		if was := r.healthy.Swap(err == nil); was != (err == nil) {
+			if err != nil {
+				slog.WarnContext(ctx, "store: replica unhealthy, reading from primary", "replica", i, "error", err)
+			} else {
+				slog.InfoContext(ctx, "store: replica healthy", "replica", i)
+			}
+		}
+	}
+}
+
+// pickReplica returns the next healthy replica to read from on behalf of
+// ctx, or nil if the read should go to the primary.
+func (s *Database) pickReplica(ctx context.Context) *replica {
+	if len(s.replicas) == 0 || s.pinnedToPrimary(ctx) {
+		return nil
+	}
+	start := int(s.next.Add(1))
+	for i := range s.replicas {
+		if r := s.replicas[(start+i)%len(s.replicas)]; r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// read runs the read-only query fn on a healthy replica, or on the primary
+// when there is none or the caller must read their own writes. If the
+// replica fails, it is marked unhealthy and fn is retried on the primary; so
+// is a replica that finds no rows, which may only be lagging behind.
+func (s *Database) read(ctx context.Context, fn func(db *sql.DB) error) error {
+	r := s.pickReplica(ctx)
+	if r == nil {
+		readsTotal.Inc("primary")
+		return fn(s.db)
+	}
+	err := fn(r.db)
+	if err == nil || ctx.Err() != nil {
+		readsTotal.Inc("replica")
+		return err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		r.healthy.Store(false)
+		slog.WarnContext(ctx, "store: replica read failed, retrying on primary", "error", err)
+	}
+	readsTotal.Inc("primary")
+	return fn(s.db)
+}
+
+type userKey struct{}
+
+// WithUser records on ctx the user a request is made by, so that store
+// reads made with it honour ReadYourWrites for that user.
+func WithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+type primaryKey struct{}
+
+// WithPrimary sends every read made with ctx to the primary, bypassing
+// replicas and the cache. Requests that write use it so that reads made
+// after their own writes see them.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// NoteWrite records that userID has just changed something. For the next
+// ReadYourWrites their reads go to the primary.
+func (s *Database) NoteWrite(userID string) {
+	if userID == "" || len(s.replicas) == 0 || s.ReadYourWrites <= 0 {
+		return
+	}
+	s.writes.note(userID, time.Now(), s.ReadYourWrites)
+}
+
+// pinnedToPrimary reports whether ctx was made by WithPrimary or the user
+// on it wrote within the last ReadYourWrites.
+func (s *Database) pinnedToPrimary(ctx context.Context) bool {
+	if primary, _ := ctx.Value(primaryKey{}).(bool); primary {
+		return true
+	}
+	userID, _ := ctx.Value(userKey{}).(string)
+	if userID == "" || s.ReadYourWrites <= 0 {
+		return false
+	}
+	return s.writes.since(userID, time.Now()) < s.ReadYourWrites
+}
+
+// writeTracker remembers when each user last wrote. Entries older than the
+// read-your-writes window are swept as new writes come in.
+type writeTracker struct {
+	mu        sync.Mutex
+	last      map[string]time.Time
+	lastSweep time.Time
+}
+
+func (w *writeTracker) note(userID string, now time.Time, window time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.last == nil {
+		w.last = make(map[string]time.Time)
+	}
+	if now.Sub(w.lastSweep) >= window {
+		for id, t := range w.last {
+			if now.Sub(t) >= window {
+				delete(w.last, id)
+			}
+		}
+		w.lastSweep = now
+	}
+	w.last[userID] = now
+}
+
+// since returns how long ago userID last wrote, or the maximum duration if
+// they have not.
+func (w *writeTracker) since(userID string, now time.Time) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t, ok := w.last[userID]
+	if !ok {
+		return time.Duration(math.MaxInt64)
+	}
+	return now.Sub(t)
+}
+
+// withTx runs fn in a transaction, committing if it returns nil and rolling
+// back otherwise.
+func (s *Database) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// step marks a point between the statements of a transaction where tests
+// can inject a failure through failAt.
+func (s *Database) step(name string) error {
+	if s.failAt == nil {
+		return nil
+	}
+	return s.failAt(name)
+}
+
+// Close stops the replica health checks and closes every connection pool.
+func (s *Database) Close() error {
+	if s.stopChecks != nil {
+		s.stopChecks()
+	}
+	errs := []error{s.db.Close()}
+	for _, r := range s.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Ping checks that the database is reachable.
+func (s *Database) Ping(ctx context.Context) error {
+	ctx, done := s.begin(ctx, "Ping")
+	defer done()
+	return s.db.PingContext(ctx)
+}
+
+var queryDuration = metrics.Default.Histogram("dischord_store_query_duration_seconds",
+	"Time spent in each store method, including all of its queries.", metrics.DefBuckets, "method")
+
+// begin starts a store method. It applies QueryTimeout to ctx and returns a
+// function, to be deferred, that releases the timeout and records how long
+// the method took.
+func (s *Database) begin(ctx context.Context, method string) (context.Context, func()) {
+	start := time.Now()
+	cancel := context.CancelFunc(func() {})
+	if s.QueryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.QueryTimeout)
+	}
+	return ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			slog.WarnContext(ctx, "store: deadline exceeded", "method", method, "elapsed", time.Since(start).String())
+		}
+		cancel()
+		queryDuration.Observe(time.Since(start).Seconds(), method)
+	}
+}
+
+// RegisterMetrics exposes the primary's connection pool statistics and the
+// number of healthy replicas of s on r.
+func (s *Database) RegisterMetrics(r *metrics.Registry) {
+	stats := func(f func(sql.DBStats) float64) func() float64 {
+		return func() float64 { return f(s.db.Stats()) }
+	}
+	r.GaugeFunc("dischord_db_open_connections", "Open database connections, in use or idle.",
+		stats(func(st sql.DBStats) float64 { return float64(st.OpenConnections) }))
+	r.GaugeFunc("dischord_db_in_use_connections", "Database connections currently in use.",
+		stats(func(st sql.DBStats) float64 { return float64(st.InUse) }))
+	r.GaugeFunc("dischord_db_idle_connections", "Idle database connections.",
+		stats(func(st sql.DBStats) float64 { return float64(st.Idle) }))
+	r.GaugeFunc("dischord_db_max_open_connections", "Maximum number of open database connections.",
+		stats(func(st sql.DBStats) float64 { return float64(st.MaxOpenConnections) }))
+	r.GaugeFunc("dischord_db_wait_count", "Total number of waits for a database connection.",
+		stats(func(st sql.DBStats) float64 { return float64(st.WaitCount) }))
+	r.GaugeFunc("dischord_db_wait_duration_seconds", "Total time spent waiting for a database connection.",
+		stats(func(st sql.DBStats) float64 { return st.WaitDuration.Seconds() }))
+	r.GaugeFunc("dischord_db_healthy_replicas", "Read replicas that passed their last health check.",
+		func() float64 {
+			n := 0
+			for _, rep := range s.replicas {
+				if rep.healthy.Load() {
+					n++
+				}
+			}
+			return float64(n)
+		})
+}
+
+var (
+	cacheHits = metrics.Default.Counter("dischord_cache_hits_total",
+		"Store reads answered from the cache, by kind: post or server.", "kind")
+	cacheMisses = metrics.Default.Counter("dischord_cache_misses_total",
+		"Store reads that missed the cache and went to the database, by kind.", "kind")
+)
+
+// Cache kinds, which prefix the cache keys.
+const (
+	cachePost   = "post"
+	cacheServer = "server"
+)
+
+func cacheKey(kind, id string) string {
+	return kind + ":" + id
+}
+
+// cached looks id up in s.Cache and counts the hit or miss. Callers who
+// must read their own writes bypass the cache, since a lagging replica may
+// have refilled it with an older value.
+func (s *Database) cached(ctx context.Context, kind, id string) (any, bool) {
+	if s.Cache == nil || s.pinnedToPrimary(ctx) {
+		return nil, false
+	}
+	v, ok := s.Cache.Get(cacheKey(kind, id))
+	if ok {
+		cacheHits.Inc(kind)
+	} else {
+		cacheMisses.Inc(kind)
+	}
+	return v, ok
+}
+
+// fill stores a value read from db in s.Cache. Only values read from the
+// primary are cached: a lagging replica could otherwise put back a value
+// that a write has just invalidated.
+func (s *Database) fill(db *sql.DB, kind, id string, v any) {
+	if s.Cache != nil && db == s.db {
+		s.Cache.Set(cacheKey(kind, id), v)
+	}
 }
 
-// New wraps an existing *sql.DB. Schema must be applied separately via ApplySchema.
-func New(db *sql.DB) *Database {
-	return &Database{db: db}
+// invalidate drops cached values of kind. It is called after a write has
+// committed, so a concurrent read can at worst re-cache the old value for
+// the cache's TTL.
+func (s *Database) invalidate(kind string, ids ...string) {
+	if s.Cache == nil || len(ids) == 0 {
+		return
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = cacheKey(kind, id)
+	}
+	s.Cache.Delete(keys...)
 }
 
 // ApplySchema creates all tables if they don't exist.
 func ApplySchema(db *sql.DB) error {
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS users (
-			id         TEXT PRIMARY KEY,
-			username   TEXT NOT NULL DEFAULT '',
-			email      TEXT NOT NULL DEFAULT '',
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			id           TEXT PRIMARY KEY,
+			username     TEXT NOT NULL DEFAULT '',
+			email        TEXT NOT NULL DEFAULT '',
+			display_name TEXT NOT NULL DEFAULT '',
+			avatar_url   TEXT NOT NULL DEFAULT '',
+			bio          TEXT NOT NULL DEFAULT '',
+			status       TEXT NOT NULL DEFAULT '',
+			created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url   TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS bio          TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS status       TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW();
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'users_username_lower_idx') THEN
+				UPDATE users u SET username = LEFT(u.username, 23) || '-' || LEFT(u.id, 8), updated_at = NOW()
+				FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY LOWER(username) ORDER BY created_at, id) AS n
+				      FROM users WHERE username <> '') dup
+				WHERE u.id = dup.id AND dup.n > 1;
+			END IF;
+		END $$;
+		CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_idx
+			ON users (LOWER(username)) WHERE username <> '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT TRUE;
+		ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT FALSE;
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'users_email_lower_idx') THEN
+				UPDATE users u SET email = '', email_verified = FALSE, updated_at = NOW()
+				FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY LOWER(email) ORDER BY email_verified DESC, created_at, id) AS n
+				      FROM users WHERE email <> '') dup
+				WHERE u.id = dup.id AND dup.n > 1;
+			END IF;
+		END $$;
+		UPDATE users SET email = LOWER(email) WHERE email <> LOWER(email);
+		CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx
+			ON users (LOWER(email)) WHERE email <> '';
+		CREATE TABLE IF NOT EXISTS email_verifications (
+			token_hash TEXT PRIMARY KEY,
+			user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email      TEXT NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
 		);
 		CREATE TABLE IF NOT EXISTS servers (
-			id         TEXT PRIMARY KEY,
-			name       TEXT NOT NULL DEFAULT '',
-			owner_id   TEXT NOT NULL DEFAULT '',
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			id                TEXT PRIMARY KEY,
+			name              TEXT NOT NULL DEFAULT '',
+			owner_id          TEXT NOT NULL DEFAULT '',
+			slow_mode_seconds INTEGER NOT NULL DEFAULT 0,
+			created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			description       TEXT NOT NULL DEFAULT '',
+			icon_url          TEXT NOT NULL DEFAULT '',
+			tags              TEXT[] NOT NULL DEFAULT '{}',
+			visibility        TEXT NOT NULL DEFAULT 'private'
 		);
+		ALTER TABLE servers ADD COLUMN IF NOT EXISTS slow_mode_seconds INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE servers ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+		ALTER TABLE servers ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+		ALTER TABLE servers ADD COLUMN IF NOT EXISTS icon_url TEXT NOT NULL DEFAULT '';
+		ALTER TABLE servers ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+		ALTER TABLE servers ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'private';
+		CREATE INDEX IF NOT EXISTS servers_tags_idx ON servers USING GIN (tags);
 		CREATE TABLE IF NOT EXISTS posts (
 			id         TEXT PRIMARY KEY,
 			server_id  TEXT NOT NULL DEFAULT '',
 			author_id  TEXT NOT NULL DEFAULT '',
 			title      TEXT NOT NULL DEFAULT '',
 			body       TEXT NOT NULL DEFAULT '',
+			score      INTEGER NOT NULL DEFAULT 0,
+			upvotes    INTEGER NOT NULL DEFAULT 0,
+			downvotes  INTEGER NOT NULL DEFAULT 0,
 			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			modified_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			version    INTEGER NOT NULL DEFAULT 1,
+			deleted_at TIMESTAMPTZ,
+			deleted_by TEXT NOT NULL DEFAULT ''
+		);
+		ALTER TABLE posts ADD COLUMN IF NOT EXISTS score     INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE posts ADD COLUMN IF NOT EXISTS upvotes   INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE posts ADD COLUMN IF NOT EXISTS downvotes INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE posts ADD COLUMN IF NOT EXISTS modified_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+		ALTER TABLE posts ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+		ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_by TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS posts_deleted_idx ON posts (server_id, deleted_at) WHERE deleted_at IS NOT NULL;
+		CREATE TABLE IF NOT EXISTS post_revisions (
+			post_id   TEXT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+			version   INTEGER NOT NULL,
+			title     TEXT NOT NULL,
+			body      TEXT NOT NULL,
+			edited_by TEXT NOT NULL,
+			edited_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (post_id, version)
 		);
 		CREATE TABLE IF NOT EXISTS votes (
 			post_id   TEXT NOT NULL,
@@ -77,9 +556,71 @@ func ApplySchema(db *sql.DB) error {
 			content    TEXT NOT NULL DEFAULT '',
 			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 		);
+		CREATE INDEX IF NOT EXISTS messages_server_created_idx ON messages (server_id, created_at);
+		CREATE INDEX IF NOT EXISTS messages_server_author_created_idx ON messages (server_id, author_id, created_at);
+		CREATE TABLE IF NOT EXISTS message_mentions (
+			message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			user_id    TEXT NOT NULL,
+			server_id  TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (message_id, user_id)
+		);
+		CREATE INDEX IF NOT EXISTS message_mentions_user_idx ON message_mentions (user_id, server_id, created_at);
 		CREATE TABLE IF NOT EXISTS server_user (
-			server_id TEXT NOT NULL REFERENCES servers(id),
-			user_id   TEXT NOT NULL REFERENCES users(id),
+			server_id            TEXT NOT NULL REFERENCES servers(id),
+			user_id              TEXT NOT NULL REFERENCES users(id),
+			role                 TEXT NOT NULL DEFAULT 'member',
+			position             INTEGER NOT NULL DEFAULT 0,
+			last_read_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_read_message_id TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (server_id, user_id)
+		);
+		ALTER TABLE server_user ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member';
+		ALTER TABLE server_user ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE server_user ADD COLUMN IF NOT EXISTS last_read_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+		ALTER TABLE server_user ADD COLUMN IF NOT EXISTS last_read_message_id TEXT NOT NULL DEFAULT '';
+		CREATE TABLE IF NOT EXISTS server_filters (
+			server_id  TEXT PRIMARY KEY REFERENCES servers(id),
+			rules      JSONB NOT NULL DEFAULT '{}',
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE TABLE IF NOT EXISTS content_flags (
+			id           TEXT PRIMARY KEY,
+			server_id    TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			content_id   TEXT NOT NULL,
+			author_id    TEXT NOT NULL DEFAULT '',
+			content      TEXT NOT NULL DEFAULT '',
+			reasons      TEXT[] NOT NULL DEFAULT '{}',
+			status       TEXT NOT NULL DEFAULT 'pending',
+			reviewed_by  TEXT NOT NULL DEFAULT '',
+			reviewed_at  TIMESTAMPTZ,
+			created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS content_flags_server_status_idx ON content_flags (server_id, status, created_at);
+		CREATE TABLE IF NOT EXISTS reports (
+			id          TEXT PRIMARY KEY,
+			server_id   TEXT NOT NULL,
+			reporter_id TEXT NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id   TEXT NOT NULL,
+			reason      TEXT NOT NULL,
+			details     TEXT NOT NULL DEFAULT '',
+			status      TEXT NOT NULL DEFAULT 'open',
+			action      TEXT NOT NULL DEFAULT '',
+			resolved_by TEXT NOT NULL DEFAULT '',
+			resolved_at TIMESTAMPTZ,
+			created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS reports_server_status_idx ON reports (server_id, status, created_at);
+		CREATE UNIQUE INDEX IF NOT EXISTS reports_open_unique_idx
+			ON reports (reporter_id, target_type, target_id) WHERE status = 'open';
+		CREATE TABLE IF NOT EXISTS server_bans (
+			server_id  TEXT NOT NULL REFERENCES servers(id),
+			user_id    TEXT NOT NULL,
+			banned_by  TEXT NOT NULL DEFAULT '',
+			reason     TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 			PRIMARY KEY (server_id, user_id)
 		);
 	`)
@@ -88,7 +629,7 @@ func ApplySchema(db *sql.DB) error {
 
 // TruncateAll removes all rows from every table. Intended for use in tests.
 func TruncateAll(db *sql.DB) error {
-	_, err := db.Exec(`TRUNCATE TABLE server_user, messages, votes, posts, friends, servers, users`)
+	_, err := db.Exec(`TRUNCATE TABLE message_mentions, post_revisions, server_bans, reports, content_flags, server_filters, email_verifications, server_user, messages, votes, posts, friends, servers, users`)
 	return err
 }
 
@@ -98,231 +639,1246 @@ func isDuplicateKey(err error) bool {
 	return errors.As(err, &pqErr) && pqErr.Code == "23505"
 }
 
+// violatesConstraint reports whether err is a unique-constraint violation of
+// the named constraint or index.
+func violatesConstraint(err error, name string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == name
+}
+
+var (
+	// ErrUsernameTaken is returned when a username is already in use,
+	// compared case-insensitively.
+	ErrUsernameTaken = errors.New("username is already taken")
+	// ErrEmailTaken is returned when an email address is already registered,
+	// compared case-insensitively.
+	ErrEmailTaken = errors.New("email is already registered")
+	// ErrOwnsServers is returned when deleting a user who still owns servers.
+	ErrOwnsServers = errors.New("user still owns one or more servers; transfer or delete them first")
+	// ErrInvalidToken is returned for unknown, expired or mismatched
+	// verification tokens.
+	ErrInvalidToken = errors.New("verification token is invalid or has expired")
+	// ErrBanned is returned when a banned user tries to join a server.
+	ErrBanned = errors.New("user is banned from this server")
+	// ErrDuplicateReport is returned when a user reports the same target
+	// again while their earlier report is still open.
+	ErrDuplicateReport = errors.New("you already have an open report for this")
+	// ErrReportNotFound is returned when resolving a report that doesn't
+	// exist in the server or has already been closed.
+	ErrReportNotFound = errors.New("open report not found")
+	// ErrInvalidReportTarget is returned when a report's target is gone or
+	// can't take the requested action.
+	ErrInvalidReportTarget = errors.New("invalid report target")
+	// ErrOutranked is returned when a moderator tries to kick or ban someone
+	// whose role is equal to or above their own, including themselves.
+	ErrOutranked = errors.New("you can only kick or ban members below your own role")
+	// ErrNotMember is returned when changing the role of someone who isn't a
+	// member of the server.
+	ErrNotMember = errors.New("user is not a member of this server")
+	// ErrRoleOutranked is returned when a member tries to change the role of
+	// someone at or above their own role, or to grant a role that isn't
+	// below their own.
+	ErrRoleOutranked = errors.New("you can only give roles below your own to members below your own role")
+	// ErrPreconditionFailed is returned when a conditional update finds the
+	// post changed since the version the caller expected.
+	ErrPreconditionFailed = errors.New("post has changed since it was last read")
+	// ErrVersionConflict is returned when a post edit names a version that
+	// has since been replaced by another edit.
+	ErrVersionConflict = errors.New("post has been edited since the version you changed")
+	// ErrServerOrder is returned when a new server order doesn't list each
+	// of the user's servers exactly once.
+	ErrServerOrder = errors.New("order must list each of your servers exactly once")
+)
+
+// DeletedUserID replaces the author of posts and messages whose account has
+// been deleted.
+const DeletedUserID = "deleted-user"
+
 // --- Users ---
 
-func (s *Database) CreateUser(u models.User) error {
-	_, err := s.db.Exec(
-		`INSERT INTO users (id, username, email, created_at) VALUES ($1, $2, $3, $4)`,
-		u.ID, u.Username, u.Email, u.CreatedAt,
+// userColumns is the column list scanned by scanUser.
+const userColumns = `u.id, u.username, u.email, u.email_verified, u.display_name, u.avatar_url, u.bio, u.status, u.created_at, u.updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (models.User, error) {
+	var u models.User
+	err := row.Scan(&u.ID, &u.Username, &u.Email, &u.EmailVerified, &u.DisplayName, &u.AvatarURL, &u.Bio, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+	return u, err
+}
+
+// userWithServersColumns adds the user's server IDs to userColumns; it is
+// scanned by scanUserWithServers.
+const userWithServersColumns = userColumns + `,
+	ARRAY(SELECT su.server_id FROM server_user su WHERE su.user_id = u.id ORDER BY su.server_id)`
+
+func scanUserWithServers(row rowScanner) (models.User, error) {
+	var u models.User
+	err := row.Scan(&u.ID, &u.Username, &u.Email, &u.EmailVerified, &u.DisplayName, &u.AvatarURL, &u.Bio, &u.Status, &u.CreatedAt, &u.UpdatedAt,
+		pq.Array(&u.ServerIDs))
+	return u, err
+}
+
+func (s *Database) CreateUser(ctx context.Context, u models.User) error {
+	ctx, done := s.begin(ctx, "CreateUser")
+	defer done()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (id, username, email, email_verified, display_name, avatar_url, bio, status, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		u.ID, u.Username, u.Email, u.EmailVerified, u.DisplayName, u.AvatarURL, u.Bio, u.Status, u.CreatedAt,
 	)
+	if violatesConstraint(err, "users_username_lower_idx") {
+		return ErrUsernameTaken
+	}
+	if violatesConstraint(err, "users_email_lower_idx") {
+		return ErrEmailTaken
+	}
 	if isDuplicateKey(err) {
 		return fmt.Errorf("user %s already exists", u.ID)
 	}
 	return err
 }
 
-func (s *Database) GetUser(id string) (models.User, error) {
-	var u models.User
-	err := s.db.QueryRow(
-		`SELECT id, username, email, created_at FROM users WHERE id = $1`, id,
-	).Scan(&u.ID, &u.Username, &u.Email, &u.CreatedAt)
+func (s *Database) GetUser(ctx context.Context, id string) (models.User, error) {
+	ctx, done := s.begin(ctx, "GetUser")
+	defer done()
+	u, err := scanUserWithServers(s.db.QueryRowContext(ctx,
+		`SELECT `+userWithServersColumns+` FROM users u WHERE u.id = $1`, id,
+	))
 	if errors.Is(err, sql.ErrNoRows) {
 		return models.User{}, fmt.Errorf("user %s not found", id)
 	}
+	return u, err
+}
+
+// GetUsers returns the users with the given IDs, in the order requested.
+// Unknown IDs are skipped.
+func (s *Database) GetUsers(ctx context.Context, ids []string) ([]models.User, error) {
+	ctx, done := s.begin(ctx, "GetUsers")
+	defer done()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+userWithServersColumns+`
+		FROM unnest($1::text[]) WITH ORDINALITY AS req(id, n)
+		JOIN users u ON u.id = req.id
+		ORDER BY req.n
+	`, pq.Array(ids))
 	if err != nil {
-		return models.User{}, err
-	}
-	rows, err := s.db.Query(`SELECT server_id FROM server_user WHERE user_id = $1`, id)
-	if err != nil {
-		return models.User{}, err
+		return nil, err
 	}
 	defer rows.Close()
+	users := []models.User{}
 	for rows.Next() {
-		var serverID string
-		if err := rows.Scan(&serverID); err != nil {
-			return models.User{}, err
+		u, err := scanUserWithServers(rows)
+		if err != nil {
+			return nil, err
 		}
-		u.ServerIDs = append(u.ServerIDs, serverID)
+		users = append(users, u)
 	}
-	return u, rows.Err()
+	return users, rows.Err()
 }
 
-// --- Friends ---
-
-func (s *Database) AddFriend(userID, friendID string) error {
-	var count int
-	if err := s.db.QueryRow(
-		`SELECT COUNT(*) FROM users WHERE id = $1 OR id = $2`, userID, friendID,
-	).Scan(&count); err != nil {
+// UpdateUser writes the profile fields of u: username, display name, avatar,
+// bio and status.
+func (s *Database) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, done := s.begin(ctx, "UpdateUser")
+	defer done()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users SET username = $1, display_name = $2, avatar_url = $3, bio = $4, status = $5, updated_at = NOW()
+		WHERE id = $6
+	`, u.Username, u.DisplayName, u.AvatarURL, u.Bio, u.Status, u.ID)
+	if violatesConstraint(err, "users_username_lower_idx") {
+		return ErrUsernameTaken
+	}
+	if err != nil {
 		return err
 	}
-	if count < 2 {
-		return fmt.Errorf("one or more users not found")
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %s not found", u.ID)
 	}
-	_, err := s.db.Exec(`
-		INSERT INTO friends (user_id, friend_id) VALUES ($1, $2), ($2, $1)
-		ON CONFLICT DO NOTHING
-	`, userID, friendID)
-	return err
+	return nil
 }
 
-func (s *Database) GetFriends(userID string) ([]models.User, error) {
-	rows, err := s.db.Query(`
-		SELECT u.id, u.username, u.email, u.created_at
-		FROM users u
-		JOIN friends f ON f.friend_id = u.id
-		WHERE f.user_id = $1
-	`, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var friends []models.User
-	for rows.Next() {
-		var u models.User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.CreatedAt); err != nil {
-			return nil, err
+// ChangeEmail sets userID's email address. A new address must be verified
+// again, so any tokens issued for the old one are dropped.
+func (s *Database) ChangeEmail(ctx context.Context, userID, email string) error {
+	ctx, done := s.begin(ctx, "ChangeEmail")
+	defer done()
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE users SET email = $1, email_verified = email_verified AND email = $1, updated_at = NOW()
+			WHERE id = $2
+		`, email, userID)
+		if violatesConstraint(err, "users_email_lower_idx") {
+			return ErrEmailTaken
 		}
-		friends = append(friends, u)
-	}
-	return friends, rows.Err()
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("user %s not found", userID)
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM email_verifications WHERE user_id = $1 AND email <> $2`, userID, email)
+		return err
+	})
 }
 
-// --- Posts ---
-
-func (s *Database) CreatePost(p models.Post) error {
-	_, err := s.db.Exec(
-		`INSERT INTO posts (id, server_id, author_id, title, body, created_at, updated_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		p.ID, p.ServerID, p.AuthorID, p.Title, p.Body, p.CreatedAt, p.UpdatedAt,
+// CreateEmailVerification stores a verification token for the user's current
+// email address. Only a hash of the token is kept.
+func (s *Database) CreateEmailVerification(ctx context.Context, userID, email, token string, expiresAt time.Time) error {
+	ctx, done := s.begin(ctx, "CreateEmailVerification")
+	defer done()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO email_verifications (token_hash, user_id, email, expires_at) VALUES ($1, $2, $3, $4)`,
+		hashToken(token), userID, email, expiresAt,
 	)
-	if isDuplicateKey(err) {
-		return fmt.Errorf("post %s already exists", p.ID)
-	}
 	return err
 }
 
-func (s *Database) GetPost(serverID, id string) (models.Post, error) {
-	var p models.Post
-	err := s.db.QueryRow(`
-		SELECT p.id, p.server_id, p.author_id, p.title, p.body,
-		       p.created_at, p.updated_at,
-		       COALESCE(SUM(v.vote), 0) AS votes
-		FROM posts p
-		LEFT JOIN votes v ON v.post_id = p.id
-		WHERE p.id = $1
-		GROUP BY p.id, p.server_id, p.author_id, p.title, p.body, p.created_at, p.updated_at
-	`, id).Scan(&p.ID, &p.ServerID, &p.AuthorID, &p.Title, &p.Body, &p.CreatedAt, &p.UpdatedAt, &p.Votes)
-	if errors.Is(err, sql.ErrNoRows) {
-		return models.Post{}, fmt.Errorf("post %s not found", id)
-	}
-	return p, err
+// VerifyEmail marks userID's email as verified if token was issued to that
+// user for the address they still have and has not expired. All of the
+// user's outstanding tokens are consumed on success.
+func (s *Database) VerifyEmail(ctx context.Context, userID, token string) error {
+	ctx, done := s.begin(ctx, "VerifyEmail")
+	defer done()
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE users u SET email_verified = TRUE, updated_at = NOW()
+			FROM email_verifications ev
+			WHERE ev.token_hash = $1 AND ev.user_id = $2 AND ev.user_id = u.id
+			  AND ev.email = u.email AND ev.expires_at > NOW()
+		`, hashToken(token), userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrInvalidToken
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM email_verifications WHERE user_id = $1`, userID)
+		return err
+	})
 }
 
-func (s *Database) UpdatePost(p models.Post) error {
-	res, err := s.db.Exec(
-		`UPDATE posts SET title = $1, body = $2, updated_at = $3 WHERE id = $4`,
-		p.Title, p.Body, p.UpdatedAt, p.ID,
-	)
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// DeleteUser removes a user account. Their posts, messages and post edits are
+// kept but reattributed to DeletedUserID, and their server memberships and friendships
+// are removed. Everything happens in one transaction, so a failure part way
+// leaves the account untouched. Users who still own servers cannot be deleted.
+func (s *Database) DeleteUser(ctx context.Context, id string) error {
+	ctx, done := s.begin(ctx, "DeleteUser")
+	defer done()
+	var posts, servers []string
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var locked string
+		err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, id).Scan(&locked)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user %s not found", id)
+		}
+		if err != nil {
+			return err
+		}
+
+		var ownsServers bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS(SELECT 1 FROM servers WHERE owner_id = $1)`, id,
+		).Scan(&ownsServers); err != nil {
+			return err
+		}
+		if ownsServers {
+			return ErrOwnsServers
+		}
+
+		if posts, err = queryIDs(ctx, tx,
+			`UPDATE posts SET author_id = $2, modified_at = NOW() WHERE author_id = $1 RETURNING id`, id, DeletedUserID,
+		); err != nil {
+			return err
+		}
+		for _, stmt := range []string{
+			`UPDATE messages SET author_id = $2 WHERE author_id = $1`,
+			`UPDATE post_revisions SET edited_by = $2 WHERE edited_by = $1`,
+			`UPDATE posts SET deleted_by = $2 WHERE deleted_by = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, id, DeletedUserID); err != nil {
+				return err
+			}
+		}
+		if servers, err = queryIDs(ctx, tx,
+			`DELETE FROM server_user WHERE user_id = $1 RETURNING server_id`, id,
+		); err != nil {
+			return err
+		}
+		if err := touch(ctx, tx, "servers", servers...); err != nil {
+			return err
+		}
+		for _, stmt := range []string{
+			`DELETE FROM friends WHERE user_id = $1 OR friend_id = $1`,
+			`DELETE FROM message_mentions WHERE user_id = $1`,
+			`DELETE FROM users WHERE id = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	if n, _ := res.RowsAffected(); n == 0 {
-		return fmt.Errorf("post %s not found", p.ID)
-	}
+	s.invalidate(cachePost, posts...)
+	s.invalidate(cacheServer, servers...)
 	return nil
 }
 
-func (s *Database) DeletePost(id string) error {
-	res, err := s.db.Exec(`DELETE FROM posts WHERE id = $1`, id)
+// touch marks rows of table, servers or users, as changed now. Their
+// updated_at backs the ETag and Last-Modified of their representation, which
+// also covers memberships and, for servers, the post list.
+func touch(ctx context.Context, tx *sql.Tx, table string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE `+table+` SET updated_at = NOW() WHERE id = ANY($1)`, pq.Array(ids))
+	return err
+}
+
+// queryIDs runs a query returning a single text column and collects it.
+func queryIDs(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if n, _ := res.RowsAffected(); n == 0 {
-		return fmt.Errorf("post %s not found", id)
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
 	}
-	return nil
+	return ids, rows.Err()
 }
 
-func (s *Database) GetVote(postID, authorID string) (models.Vote, error) {
-	var v models.Vote
-	err := s.db.QueryRow(
-		`SELECT post_id, author_id, vote FROM votes WHERE post_id = $1 AND author_id = $2`,
-		postID, authorID,
-	).Scan(&v.PostID, &v.AuthorID, &v.Vote)
-	if errors.Is(err, sql.ErrNoRows) {
-		return models.Vote{}, fmt.Errorf("Vote %s not found", postID+"-"+authorID)
+// --- Friends ---
+
+// AddFriend makes two existing users friends in both directions.
+func (s *Database) AddFriend(ctx context.Context, userID, friendID string) error {
+	ctx, done := s.begin(ctx, "AddFriend")
+	defer done()
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		// Lock both users so neither can be deleted before the rows are
+		// written.
+		var count int
+		if err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM (SELECT id FROM users WHERE id = $1 OR id = $2 FOR SHARE) u`, userID, friendID,
+		).Scan(&count); err != nil {
+			return err
+		}
+		if count < 2 {
+			return fmt.Errorf("one or more users not found")
+		}
+		if err := s.step("AddFriend:insert"); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO friends (user_id, friend_id) VALUES ($1, $2), ($2, $1)
+			ON CONFLICT DO NOTHING
+		`, userID, friendID)
+		return err
+	})
+}
+
+func (s *Database) GetFriends(ctx context.Context, userID string) ([]models.PublicUser, error) {
+	ctx, done := s.begin(ctx, "GetFriends")
+	defer done()
+	var friends []models.PublicUser
+	err := s.read(ctx, func(db *sql.DB) error {
+		friends = nil
+		rows, err := db.QueryContext(ctx, `
+			SELECT `+userColumns+`
+			FROM users u
+			JOIN friends f ON f.friend_id = u.id
+			WHERE f.user_id = $1
+		`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			u, err := scanUser(rows)
+			if err != nil {
+				return err
+			}
+			friends = append(friends, u.Public())
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
-	return v, err
+	return friends, nil
 }
 
-func (s *Database) PostVote(postID, authorID string, amount int) error {
-	var exists bool
-	if err := s.db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM posts WHERE id = $1)`, postID,
-	).Scan(&exists); err != nil {
+// --- Posts ---
+
+// CreatePost stores a new post. flags, if any, are queued for moderator
+// review in the same transaction.
+func (s *Database) CreatePost(ctx context.Context, p models.Post, flags ...models.Flag) error {
+	ctx, done := s.begin(ctx, "CreatePost")
+	defer done()
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO posts (id, server_id, author_id, title, body, created_at, updated_at, modified_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())`,
+			p.ID, p.ServerID, p.AuthorID, p.Title, p.Body, p.CreatedAt, p.UpdatedAt,
+		)
+		if isDuplicateKey(err) {
+			return fmt.Errorf("post %s already exists", p.ID)
+		}
+		if err != nil {
+			return err
+		}
+		if err := insertFlags(ctx, tx, flags...); err != nil {
+			return err
+		}
+		return touch(ctx, tx, "servers", p.ServerID)
+	})
+	if err != nil {
 		return err
 	}
-	if !exists {
-		return fmt.Errorf("post %s not found", postID)
-	}
-	_, err := s.db.Exec(`
-		INSERT INTO votes (post_id, author_id, vote) VALUES ($1, $2, $3)
-		ON CONFLICT (post_id, author_id) DO UPDATE SET vote = EXCLUDED.vote
-	`, postID, authorID, amount)
-	return err
+	s.invalidate(cacheServer, p.ServerID)
+	return nil
 }
 
-// --- Servers ---
+// postColumns is the column list scanned by scanPost.
+const postColumns = `p.id, p.server_id, p.author_id, p.title, p.body,
+	p.created_at, p.updated_at, p.modified_at, p.version, p.score, p.upvotes, p.downvotes,
+	p.deleted_at, p.deleted_by`
 
-func (s *Database) CreateServer(srv models.Server) error {
-	_, err := s.db.Exec(
-		`INSERT INTO servers (id, name, owner_id, created_at) VALUES ($1, $2, $3, $4)`,
-		srv.ID, srv.Name, srv.OwnerID, srv.CreatedAt,
-	)
-	if isDuplicateKey(err) {
-		return fmt.Errorf("server %s already exists", srv.ID)
-	}
-	return err
+func scanPost(row rowScanner) (models.Post, error) {
+	var p models.Post
+	err := row.Scan(&p.ID, &p.ServerID, &p.AuthorID, &p.Title, &p.Body, &p.CreatedAt, &p.UpdatedAt, &p.ModifiedAt, &p.Version,
+		&p.Votes, &p.Upvotes, &p.Downvotes, &p.DeletedAt, &p.DeletedBy)
+	return p, err
 }
 
-func (s *Database) GetServer(id string) (models.Server, error) {
-	var srv models.Server
-	err := s.db.QueryRow(
-		`SELECT id, name, owner_id, created_at FROM servers WHERE id = $1`, id,
-	).Scan(&srv.ID, &srv.Name, &srv.OwnerID, &srv.CreatedAt)
+// GetPost returns the post with the given ID if it belongs to serverID and
+// has not been deleted.
+func (s *Database) GetPost(ctx context.Context, serverID, id string) (models.Post, error) {
+	if v, ok := s.cached(ctx, cachePost, id); ok {
+		if p := v.(models.Post); p.ServerID == serverID {
+			return p, nil
+		}
+		return models.Post{}, fmt.Errorf("post %s not found", id)
+	}
+	ctx, done := s.begin(ctx, "GetPost")
+	defer done()
+	var p models.Post
+	var from *sql.DB
+	err := s.read(ctx, func(db *sql.DB) (err error) {
+		from = db
+		p, err = scanPost(db.QueryRowContext(ctx, `SELECT `+postColumns+` FROM posts p WHERE p.id = $1 AND p.deleted_at IS NULL`, id))
+		return err
+	})
 	if errors.Is(err, sql.ErrNoRows) {
-		return models.Server{}, fmt.Errorf("server %s not found", id)
+		return models.Post{}, fmt.Errorf("post %s not found", id)
 	}
 	if err != nil {
-		return models.Server{}, err
+		return models.Post{}, err
 	}
-	rows, err := s.db.Query(`SELECT id FROM posts WHERE server_id = $1`, id)
-	if err != nil {
-		return models.Server{}, err
+	s.fill(from, cachePost, id, p)
+	if p.ServerID != serverID {
+		return models.Post{}, fmt.Errorf("post %s not found", id)
+	}
+	return p, nil
+}
+
+// GetPosts returns the posts with the given IDs, in the order requested.
+// Unknown IDs are skipped.
+func (s *Database) GetPosts(ctx context.Context, ids []string) ([]models.Post, error) {
+	ctx, done := s.begin(ctx, "GetPosts")
+	defer done()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+postColumns+`
+		FROM unnest($1::text[]) WITH ORDINALITY AS req(id, n)
+		JOIN posts p ON p.id = req.id AND p.deleted_at IS NULL
+		ORDER BY req.n
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
+	posts := []models.Post{}
 	for rows.Next() {
-		var postID string
-		if err := rows.Scan(&postID); err != nil {
-			return models.Server{}, err
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, err
 		}
-		srv.Posts = append(srv.Posts, postID)
+		posts = append(posts, p)
 	}
-	if err := rows.Err(); err != nil {
-		return models.Server{}, err
+	return posts, rows.Err()
+}
+
+// PostEdit is a change to a post's title or body. Nil fields are left as
+// they are.
+type PostEdit struct {
+	ID       string
+	ServerID string
+	EditorID string
+	// Version is the version of the post the edit was made against.
+	Version int
+	Title   *string
+	Body    *string
+	// IfMatch, when not empty, lists the ModifiedAt times the post may have
+	// for the edit to apply.
+	IfMatch []time.Time
+	// Flag, when set, queues the edited post for moderator review. Its
+	// Content is filled in with the post's new title and body.
+	Flag *models.Flag
+}
+
+// UpdatePost applies e to its post, which must belong to e.ServerID, and
+// returns the stored result. If the
+// post is no longer at e.Version, ErrVersionConflict is returned along with
+// the current post; if it fails e.IfMatch, ErrPreconditionFailed is. Both
+// the old and the new version are kept in post_revisions.
+func (s *Database) UpdatePost(ctx context.Context, e PostEdit) (models.Post, error) {
+	ctx, done := s.begin(ctx, "UpdatePost")
+	defer done()
+	var updated models.Post
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := scanPost(tx.QueryRowContext(ctx, `SELECT `+postColumns+` FROM posts p WHERE p.id = $1 AND p.server_id = $2 AND p.deleted_at IS NULL FOR UPDATE`, e.ID, e.ServerID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("post %s not found", e.ID)
+		}
+		if err != nil {
+			return err
+		}
+		if current.Version != e.Version {
+			updated = current
+			return ErrVersionConflict
+		}
+		if len(e.IfMatch) > 0 && !slices.ContainsFunc(e.IfMatch, current.ModifiedAt.Equal) {
+			updated = current
+			return ErrPreconditionFailed
+		}
+
+		// Posts that have never been edited have no revisions yet.
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO post_revisions (post_id, version, title, body, edited_by, edited_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (post_id, version) DO NOTHING
+		`, current.ID, current.Version, current.Title, current.Body, current.AuthorID, current.UpdatedAt); err != nil {
+			return err
+		}
+		if err := s.step("UpdatePost:update"); err != nil {
+			return err
+		}
+
+		title, body := current.Title, current.Body
+		if e.Title != nil {
+			title = *e.Title
+		}
+		if e.Body != nil {
+			body = *e.Body
+		}
+		updated, err = scanPost(tx.QueryRowContext(ctx, `
+			UPDATE posts p SET title = $1, body = $2, updated_at = NOW(), modified_at = NOW(), version = version + 1
+			WHERE p.id = $3
+			RETURNING `+postColumns,
+			title, body, e.ID,
+		))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO post_revisions (post_id, version, title, body, edited_by, edited_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, updated.ID, updated.Version, updated.Title, updated.Body, e.EditorID, updated.UpdatedAt); err != nil {
+			return err
+		}
+		if e.Flag == nil {
+			return nil
+		}
+		flag := *e.Flag
+		flag.Content = updated.Title + "\n\n" + updated.Body
+		return insertFlags(ctx, tx, flag)
+	})
+	if errors.Is(err, ErrVersionConflict) || errors.Is(err, ErrPreconditionFailed) {
+		return updated, err
+	}
+	if err != nil {
+		return models.Post{}, err
+	}
+	s.invalidate(cachePost, e.ID)
+	return updated, nil
+}
+
+// ListPostRevisions returns every saved version of a post, oldest first. A
+// post that has never been edited has none.
+func (s *Database) ListPostRevisions(ctx context.Context, postID string) ([]models.PostRevision, error) {
+	ctx, done := s.begin(ctx, "ListPostRevisions")
+	defer done()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT post_id, version, title, body, edited_by, edited_at
+		FROM post_revisions WHERE post_id = $1 ORDER BY version
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []models.PostRevision{}
+	for rows.Next() {
+		var rev models.PostRevision
+		if err := rows.Scan(&rev.PostID, &rev.Version, &rev.Title, &rev.Body, &rev.EditedBy, &rev.EditedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// DeletePost marks the post with the given ID deleted by deletedBy if it
+// belongs to serverID. It stays restorable for PostRetention.
+func (s *Database) DeletePost(ctx context.Context, serverID, id, deletedBy string) error {
+	ctx, done := s.begin(ctx, "DeletePost")
+	defer done()
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		err := softDeletePost(ctx, tx, serverID, id, deletedBy)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("post %s not found", id)
+		}
+		if err != nil {
+			return err
+		}
+		return touch(ctx, tx, "servers", serverID)
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate(cachePost, id)
+	s.invalidate(cacheServer, serverID)
+	return nil
+}
+
+// softDeletePost marks a live post in serverID deleted. It returns
+// sql.ErrNoRows if there is no such post.
+func softDeletePost(ctx context.Context, tx *sql.Tx, serverID, id, deletedBy string) error {
+	var deleted string
+	return tx.QueryRowContext(ctx, `
+		UPDATE posts SET deleted_at = NOW(), deleted_by = $3, modified_at = NOW()
+		WHERE id = $1 AND server_id = $2 AND deleted_at IS NULL
+		RETURNING id
+	`, id, serverID, deletedBy).Scan(&deleted)
+}
+
+func (s *Database) GetVote(ctx context.Context, postID, authorID string) (models.Vote, error) {
+	ctx, done := s.begin(ctx, "GetVote")
+	defer done()
+	var v models.Vote
+	err := s.db.QueryRowContext(ctx,
+		`SELECT post_id, author_id, vote FROM votes WHERE post_id = $1 AND author_id = $2`,
+		postID, authorID,
+	).Scan(&v.PostID, &v.AuthorID, &v.Vote)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Vote{}, fmt.Errorf("Vote %s not found", postID+"-"+authorID)
 	}
+	return v, err
+}
 
-	memberRows, err := s.db.Query(`SELECT user_id FROM server_user WHERE server_id = $1`, id)
+// GetVoters returns every non-zero vote cast on a post, upvotes first.
+func (s *Database) GetVoters(ctx context.Context, postID string) ([]models.Vote, error) {
+	ctx, done := s.begin(ctx, "GetVoters")
+	defer done()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT post_id, author_id, vote FROM votes
+		WHERE post_id = $1 AND vote <> 0
+		ORDER BY vote DESC, author_id
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var votes []models.Vote
+	for rows.Next() {
+		var v models.Vote
+		if err := rows.Scan(&v.PostID, &v.AuthorID, &v.Vote); err != nil {
+			return nil, err
+		}
+		votes = append(votes, v)
+	}
+	return votes, rows.Err()
+}
+
+// PostVote records authorID's vote on a post and adjusts the post's denormalized
+// score, upvotes and downvotes in the same transaction. The post row is locked
+// for the duration so concurrent votes on the same post serialize. It reports
+// whether the vote changed; repeating the current vote writes nothing.
+func (s *Database) PostVote(ctx context.Context, postID, authorID string, amount int) (bool, error) {
+	ctx, done := s.begin(ctx, "PostVote")
+	defer done()
+	changed := false
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var locked string
+		err := tx.QueryRowContext(ctx, `SELECT id FROM posts WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, postID).Scan(&locked)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("post %s not found", postID)
+		}
+		if err != nil {
+			return err
+		}
+
+		var previous int
+		err = tx.QueryRowContext(ctx,
+			`SELECT vote FROM votes WHERE post_id = $1 AND author_id = $2`, postID, authorID,
+		).Scan(&previous)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if previous == amount {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO votes (post_id, author_id, vote) VALUES ($1, $2, $3)
+			ON CONFLICT (post_id, author_id) DO UPDATE SET vote = EXCLUDED.vote
+		`, postID, authorID, amount); err != nil {
+			return err
+		}
+		if err := s.step("PostVote:score"); err != nil {
+			return err
+		}
+
+		up, down := voteCounts(amount)
+		prevUp, prevDown := voteCounts(previous)
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE posts
+			SET score = score + $1, upvotes = upvotes + $2, downvotes = downvotes + $3, modified_at = NOW()
+			WHERE id = $4
+		`, amount-previous, up-prevUp, down-prevDown, postID); err != nil {
+			return err
+		}
+		changed = true
+		return nil
+	})
+	if changed && err == nil {
+		s.invalidate(cachePost, postID)
+	}
+	return changed, err
+}
+
+// voteCounts splits a single vote value into its upvote and downvote contribution.
+func voteCounts(vote int) (up, down int) {
+	switch {
+	case vote > 0:
+		return 1, 0
+	case vote < 0:
+		return 0, 1
+	}
+	return 0, 0
+}
+
+// ScoreDrift describes a post whose denormalized vote columns disagree with
+// the votes table.
+type ScoreDrift struct {
+	PostID        string `json:"post_id"`
+	Score         int    `json:"score"`
+	Upvotes       int    `json:"upvotes"`
+	Downvotes     int    `json:"downvotes"`
+	WantScore     int    `json:"want_score"`
+	WantUpvotes   int    `json:"want_upvotes"`
+	WantDownvotes int    `json:"want_downvotes"`
+}
+
+// ReconcileVoteScores recomputes every post's score, upvotes and downvotes from
+// the votes table and returns the posts that had drifted. When fix is true the
+// drifted rows are corrected in the same transaction.
+func (s *Database) ReconcileVoteScores(ctx context.Context, fix bool) ([]ScoreDrift, error) {
+	ctx, done := s.begin(ctx, "ReconcileVoteScores")
+	defer done()
+	var drifts []ScoreDrift
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT p.id, p.score, p.upvotes, p.downvotes,
+			       COALESCE(SUM(v.vote), 0),
+			       COUNT(v.vote) FILTER (WHERE v.vote > 0),
+			       COUNT(v.vote) FILTER (WHERE v.vote < 0)
+			FROM posts p
+			LEFT JOIN votes v ON v.post_id = p.id
+			GROUP BY p.id, p.score, p.upvotes, p.downvotes
+			HAVING p.score <> COALESCE(SUM(v.vote), 0)
+			    OR p.upvotes <> COUNT(v.vote) FILTER (WHERE v.vote > 0)
+			    OR p.downvotes <> COUNT(v.vote) FILTER (WHERE v.vote < 0)
+			ORDER BY p.id
+		`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var d ScoreDrift
+			if err := rows.Scan(&d.PostID, &d.Score, &d.Upvotes, &d.Downvotes,
+				&d.WantScore, &d.WantUpvotes, &d.WantDownvotes); err != nil {
+				rows.Close()
+				return err
+			}
+			drifts = append(drifts, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if !fix {
+			return nil
+		}
+
+		for _, d := range drifts {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE posts SET score = $1, upvotes = $2, downvotes = $3, modified_at = NOW() WHERE id = $4`,
+				d.WantScore, d.WantUpvotes, d.WantDownvotes, d.PostID,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fix {
+		for _, d := range drifts {
+			s.invalidate(cachePost, d.PostID)
+		}
+	}
+	return drifts, nil
+}
+
+// --- Deleted posts ---
+
+// DefaultPostRetention is the PostRetention of a new Database.
+const DefaultPostRetention = 30 * 24 * time.Hour
+
+var postsPurged = metrics.Default.Counter("dischord_posts_purged_total",
+	"Deleted posts removed for good after their retention window.")
+
+// ListDeletedPosts returns the posts in serverID that were deleted within
+// PostRetention and so can still be restored, most recently deleted first.
+func (s *Database) ListDeletedPosts(ctx context.Context, serverID string) ([]models.Post, error) {
+	ctx, done := s.begin(ctx, "ListDeletedPosts")
+	defer done()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+postColumns+` FROM posts p
+		WHERE p.server_id = $1 AND p.deleted_at > $2
+		ORDER BY p.deleted_at DESC, p.id
+	`, serverID, time.Now().Add(-s.PostRetention))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	posts := []models.Post{}
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// RestorePost undeletes a post in serverID that was deleted within
+// PostRetention and returns it.
+func (s *Database) RestorePost(ctx context.Context, serverID, id string) (models.Post, error) {
+	ctx, done := s.begin(ctx, "RestorePost")
+	defer done()
+	var p models.Post
+	err := s.withTx(ctx, func(tx *sql.Tx) (err error) {
+		p, err = scanPost(tx.QueryRowContext(ctx, `
+			UPDATE posts p SET deleted_at = NULL, deleted_by = '', modified_at = NOW()
+			WHERE p.id = $1 AND p.server_id = $2 AND p.deleted_at > $3
+			RETURNING `+postColumns,
+			id, serverID, time.Now().Add(-s.PostRetention),
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("deleted post %s not found", id)
+		}
+		if err != nil {
+			return err
+		}
+		return touch(ctx, tx, "servers", serverID)
+	})
+	if err != nil {
+		return models.Post{}, err
+	}
+	s.invalidate(cachePost, id)
+	s.invalidate(cacheServer, serverID)
+	return p, nil
+}
+
+// PurgeDeletedPosts removes the posts deleted before PostRetention ago,
+// along with their votes, revisions, content flags and reports, and returns
+// how many it removed.
+func (s *Database) PurgeDeletedPosts(ctx context.Context) (int, error) {
+	ctx, done := s.begin(ctx, "PurgeDeletedPosts")
+	defer done()
+	var purged []string
+	err := s.withTx(ctx, func(tx *sql.Tx) (err error) {
+		purged, err = queryIDs(ctx, tx,
+			`DELETE FROM posts WHERE deleted_at <= $1 RETURNING id`, time.Now().Add(-s.PostRetention),
+		)
+		if err != nil || len(purged) == 0 {
+			return err
+		}
+		if err := s.step("PurgeDeletedPosts:votes"); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM votes WHERE post_id = ANY($1)`, pq.Array(purged)); err != nil {
+			return err
+		}
+		// Flags and reports point at posts by ID without a foreign key.
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM content_flags WHERE content_type = $1 AND content_id = ANY($2)`, ContentPost, pq.Array(purged),
+		); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM reports WHERE target_type = $1 AND target_id = ANY($2)`, ContentPost, pq.Array(purged),
+		)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	postsPurged.Add(float64(len(purged)))
+	return len(purged), nil
+}
+
+// PurgeEvery calls PurgeDeletedPosts every interval until ctx is done.
+func (s *Database) PurgeEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := s.PurgeDeletedPosts(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "store: purging deleted posts failed", "error", err)
+			} else if n > 0 {
+				slog.InfoContext(ctx, "store: purged deleted posts", "count", n)
+			}
+		}
+	}
+}
+
+// --- Servers ---
+
+// CreateServer inserts a server and its initial members (srv.MemberIDs, which
+// must be existing users) in one transaction.
+func (s *Database) CreateServer(ctx context.Context, srv models.Server) error {
+	ctx, done := s.begin(ctx, "CreateServer")
+	defer done()
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO servers (id, name, owner_id, slow_mode_seconds, created_at, updated_at, description, icon_url, tags, visibility)
+			 VALUES ($1, $2, $3, $4, $5, $5, $6, $7, COALESCE($8, '{}'::text[]), $9)`,
+			srv.ID, srv.Name, srv.OwnerID, srv.SlowModeSeconds, srv.CreatedAt,
+			srv.Description, srv.IconURL, pq.Array(srv.Tags), cmp.Or(srv.Visibility, VisibilityPrivate),
+		)
+		if isDuplicateKey(err) {
+			return fmt.Errorf("server %s already exists", srv.ID)
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.step("CreateServer:members"); err != nil {
+			return err
+		}
+		for _, userID := range srv.MemberIDs {
+			_, err := tx.ExecContext(ctx, insertMember, srv.ID, userID)
+			if err != nil {
+				return err
+			}
+		}
+		return touch(ctx, tx, "users", srv.MemberIDs...)
+	})
+}
+
+// serverColumns selects a server with its post and member IDs aggregated in
+// the same row; it is scanned by scanServer.
+const serverColumns = `s.id, s.name, s.description, s.icon_url, s.tags, s.visibility,
+	s.owner_id, s.slow_mode_seconds, s.created_at, s.updated_at,
+	ARRAY(SELECT p.id FROM posts p WHERE p.server_id = s.id AND p.deleted_at IS NULL ORDER BY p.created_at, p.id),
+	ARRAY(SELECT su.user_id FROM server_user su WHERE su.server_id = s.id ORDER BY su.user_id)`
+
+func scanServer(row rowScanner) (models.Server, error) {
+	var srv models.Server
+	err := row.Scan(&srv.ID, &srv.Name, &srv.Description, &srv.IconURL, pq.Array(&srv.Tags), &srv.Visibility,
+		&srv.OwnerID, &srv.SlowModeSeconds, &srv.CreatedAt, &srv.UpdatedAt,
+		pq.Array(&srv.Posts), pq.Array(&srv.MemberIDs))
+	return srv, err
+}
+
+func (s *Database) GetServer(ctx context.Context, id string) (models.Server, error) {
+	if v, ok := s.cached(ctx, cacheServer, id); ok {
+		// Copy the slices so callers can't modify the cached server.
+		srv := v.(models.Server)
+		srv.Tags = slices.Clone(srv.Tags)
+		srv.MemberIDs = slices.Clone(srv.MemberIDs)
+		srv.Posts = slices.Clone(srv.Posts)
+		return srv, nil
+	}
+	ctx, done := s.begin(ctx, "GetServer")
+	defer done()
+	var srv models.Server
+	var from *sql.DB
+	err := s.read(ctx, func(db *sql.DB) (err error) {
+		from = db
+		srv, err = scanServer(db.QueryRowContext(ctx, `SELECT `+serverColumns+` FROM servers s WHERE s.id = $1`, id))
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Server{}, fmt.Errorf("server %s not found", id)
+	}
 	if err != nil {
 		return models.Server{}, err
 	}
-	defer memberRows.Close()
-	for memberRows.Next() {
-		var memberID string
-		if err := memberRows.Scan(&memberID); err != nil {
-			return models.Server{}, err
+	cached := srv
+	cached.Tags = slices.Clone(srv.Tags)
+	cached.MemberIDs = slices.Clone(srv.MemberIDs)
+	cached.Posts = slices.Clone(srv.Posts)
+	s.fill(from, cacheServer, id, cached)
+	return srv, nil
+}
+
+// GetServers returns the servers with the given IDs, in the order requested.
+// Unknown IDs are skipped.
+func (s *Database) GetServers(ctx context.Context, ids []string) ([]models.Server, error) {
+	ctx, done := s.begin(ctx, "GetServers")
+	defer done()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+serverColumns+`
+		FROM unnest($1::text[]) WITH ORDINALITY AS req(id, n)
+		JOIN servers s ON s.id = req.id
+		ORDER BY req.n
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	servers := []models.Server{}
+	for rows.Next() {
+		srv, err := scanServer(rows)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, srv)
+	}
+	return servers, rows.Err()
+}
+
+// GetSlowMode returns a server's slow mode interval in seconds.
+func (s *Database) GetSlowMode(ctx context.Context, serverID string) (int, error) {
+	ctx, done := s.begin(ctx, "GetSlowMode")
+	defer done()
+	var seconds int
+	err := s.db.QueryRowContext(ctx, `SELECT slow_mode_seconds FROM servers WHERE id = $1`, serverID).Scan(&seconds)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("server %s not found", serverID)
+	}
+	return seconds, err
+}
+
+// SetSlowMode sets how many seconds members must wait between messages in a
+// server. Zero turns slow mode off.
+func (s *Database) SetSlowMode(ctx context.Context, serverID string, seconds int) error {
+	ctx, done := s.begin(ctx, "SetSlowMode")
+	defer done()
+	res, err := s.db.ExecContext(ctx, `UPDATE servers SET slow_mode_seconds = $1, updated_at = NOW() WHERE id = $2`, seconds, serverID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("server %s not found", serverID)
+	}
+	s.invalidate(cacheServer, serverID)
+	return nil
+}
+
+// Server visibility. Only public servers are listed by DiscoverServers.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// ServerSettings is a change to a server's settings. Nil fields are left as
+// they are.
+type ServerSettings struct {
+	ID          string
+	Name        *string
+	Description *string
+	IconURL     *string
+	Tags        *[]string
+	Visibility  *string
+}
+
+// UpdateServerSettings applies the fields set in p to its server in a single
+// statement, so concurrent updates to different fields don't overwrite each
+// other.
+func (s *Database) UpdateServerSettings(ctx context.Context, p ServerSettings) error {
+	ctx, done := s.begin(ctx, "UpdateServerSettings")
+	defer done()
+	var tags any
+	if p.Tags != nil {
+		t := *p.Tags
+		if t == nil {
+			t = []string{}
+		}
+		tags = pq.Array(t)
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE servers SET
+			name = COALESCE($1, name),
+			description = COALESCE($2, description),
+			icon_url = COALESCE($3, icon_url),
+			tags = COALESCE($4::text[], tags),
+			visibility = COALESCE($5, visibility),
+			updated_at = NOW()
+		WHERE id = $6
+	`, p.Name, p.Description, p.IconURL, tags, p.Visibility, p.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("server %s not found", p.ID)
+	}
+	s.invalidate(cacheServer, p.ID)
+	return nil
+}
+
+// DeleteServer removes a server along with its posts, votes, messages,
+// memberships, bans, filter rules, flags and reports.
+func (s *Database) DeleteServer(ctx context.Context, id string) error {
+	ctx, done := s.begin(ctx, "DeleteServer")
+	defer done()
+	var posts []string
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var locked string
+		err := tx.QueryRowContext(ctx, `SELECT id FROM servers WHERE id = $1 FOR UPDATE`, id).Scan(&locked)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("server %s not found", id)
+		}
+		if err != nil {
+			return err
+		}
+
+		if posts, err = queryIDs(ctx, tx, `DELETE FROM posts WHERE server_id = $1 RETURNING id`, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM votes WHERE post_id = ANY($1)`, pq.Array(posts)); err != nil {
+			return err
+		}
+		if err := s.step("DeleteServer:members"); err != nil {
+			return err
+		}
+		members, err := queryIDs(ctx, tx, `DELETE FROM server_user WHERE server_id = $1 RETURNING user_id`, id)
+		if err != nil {
+			return err
+		}
+		if err := touch(ctx, tx, "users", members...); err != nil {
+			return err
+		}
+		for _, stmt := range []string{
+			`DELETE FROM messages WHERE server_id = $1`,
+			`DELETE FROM server_bans WHERE server_id = $1`,
+			`DELETE FROM server_filters WHERE server_id = $1`,
+			`DELETE FROM content_flags WHERE server_id = $1`,
+			`DELETE FROM reports WHERE server_id = $1`,
+			`DELETE FROM servers WHERE id = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate(cachePost, posts...)
+	s.invalidate(cacheServer, id)
+	return nil
+}
+
+// serverSummaryColumns selects a server's listing; it is scanned by
+// scanServerSummary.
+const serverSummaryColumns = `s.id, s.name, s.description, s.icon_url, s.tags,
+	(SELECT COUNT(*) FROM server_user su WHERE su.server_id = s.id) AS member_count,
+	GREATEST(s.created_at,
+		(SELECT MAX(p.created_at) FROM posts p WHERE p.server_id = s.id AND p.deleted_at IS NULL),
+		(SELECT MAX(m.created_at) FROM messages m WHERE m.server_id = s.id)) AS last_activity_at`
+
+// scanServerSummary scans serverSummaryColumns into sum, followed by any
+// extra columns selected after them.
+func scanServerSummary(row rowScanner, sum *models.ServerSummary, extra ...any) error {
+	return row.Scan(append([]any{&sum.ID, &sum.Name, &sum.Description, &sum.IconURL, pq.Array(&sum.Tags), &sum.MemberCount, &sum.LastActivityAt}, extra...)...)
+}
+
+// DiscoverServers lists up to limit public servers whose name or
+// description contains query and that carry tag, ignoring whichever of the
+// two is empty. The busiest servers come first: most members, then most
+// recent activity.
+func (s *Database) DiscoverServers(ctx context.Context, query, tag string, limit int) ([]models.ServerSummary, error) {
+	ctx, done := s.begin(ctx, "DiscoverServers")
+	defer done()
+	var servers []models.ServerSummary
+	err := s.read(ctx, func(db *sql.DB) error {
+		servers = []models.ServerSummary{}
+		rows, err := db.QueryContext(ctx, `
+			SELECT `+serverSummaryColumns+`
+			FROM servers s
+			WHERE s.visibility = $1
+			  AND ($2 = '' OR strpos(lower(s.name), lower($2)) > 0 OR strpos(lower(s.description), lower($2)) > 0)
+			  AND ($3 = '' OR $3 = ANY(s.tags))
+			ORDER BY member_count DESC, last_activity_at DESC, s.id
+			LIMIT $4
+		`, VisibilityPublic, query, tag, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var sum models.ServerSummary
+			if err := scanServerSummary(rows, &sum); err != nil {
+				return err
+			}
+			servers = append(servers, sum)
 		}
-		srv.MemberIDs = append(srv.MemberIDs, memberID)
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
-	return srv, memberRows.Err()
+	return servers, nil
 }
 
 // --- Server Members ---
 
-func (s *Database) JoinServer(serverID, userID string) error {
+// insertMember adds user $2 to server $1, at the end of their server list.
+const insertMember = `
+	INSERT INTO server_user (server_id, user_id, position)
+	VALUES ($1, $2, (SELECT COALESCE(MAX(position) + 1, 0) FROM server_user WHERE user_id = $2))
+	ON CONFLICT DO NOTHING`
+
+func (s *Database) JoinServer(ctx context.Context, serverID, userID string) error {
+	ctx, done := s.begin(ctx, "JoinServer")
+	defer done()
 	var count int
-	if err := s.db.QueryRow(
+	if err := s.db.QueryRowContext(ctx,
 		`SELECT COUNT(*) FROM servers WHERE id = $1`, serverID,
 	).Scan(&count); err != nil {
 		return err
@@ -330,7 +1886,7 @@ func (s *Database) JoinServer(serverID, userID string) error {
 	if count == 0 {
 		return fmt.Errorf("server %s not found", serverID)
 	}
-	if err := s.db.QueryRow(
+	if err := s.db.QueryRowContext(ctx,
 		`SELECT COUNT(*) FROM users WHERE id = $1`, userID,
 	).Scan(&count); err != nil {
 		return err
@@ -338,16 +1894,203 @@ func (s *Database) JoinServer(serverID, userID string) error {
 	if count == 0 {
 		return fmt.Errorf("user %s not found", userID)
 	}
-	_, err := s.db.Exec(
-		`INSERT INTO server_user (server_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+	var banned bool
+	if err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM server_bans WHERE server_id = $1 AND user_id = $2)`, serverID, userID,
+	).Scan(&banned); err != nil {
+		return err
+	}
+	if banned {
+		return ErrBanned
+	}
+	joined := false
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, insertMember, serverID, userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		joined = true
+		if err := touch(ctx, tx, "servers", serverID); err != nil {
+			return err
+		}
+		return touch(ctx, tx, "users", userID)
+	})
+	if err != nil {
+		return err
+	}
+	if joined {
+		s.invalidate(cacheServer, serverID)
+	}
+	return nil
+}
+
+// Member roles stored in server_user.role.
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// IsModerator reports whether userID may moderate serverID: the server owner,
+// or a member whose role is admin or moderator.
+func (s *Database) IsModerator(ctx context.Context, serverID, userID string) (bool, error) {
+	ctx, done := s.begin(ctx, "IsModerator")
+	defer done()
+	var ok bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM servers WHERE id = $1 AND owner_id = $2)
+		    OR EXISTS(SELECT 1 FROM server_user
+		              WHERE server_id = $1 AND user_id = $2 AND role IN ($3, $4))
+	`, serverID, userID, RoleAdmin, RoleModerator).Scan(&ok)
+	return ok, err
+}
+
+// memberRank orders userID's standing in serverID: 3 for the owner, 2 for
+// admins, 1 for moderators and 0 for other members and non-members.
+func memberRank(ctx context.Context, q queryer, serverID, userID string) (int, error) {
+	var rank int
+	err := q.QueryRowContext(ctx, `
+		SELECT CASE
+			WHEN EXISTS(SELECT 1 FROM servers WHERE id = $1 AND owner_id = $2) THEN 3
+			ELSE COALESCE((SELECT CASE role WHEN $3 THEN 2 WHEN $4 THEN 1 ELSE 0 END
+			               FROM server_user WHERE server_id = $1 AND user_id = $2), 0)
+		END
+	`, serverID, userID, RoleAdmin, RoleModerator).Scan(&rank)
+	return rank, err
+}
+
+// SetMemberRole gives userID the role in serverID, on behalf of actorID. The
+// owner can give any role; admins can make members moderators and back. No
+// one can change the role of someone at or above their own, including
+// themselves and the owner.
+func (s *Database) SetMemberRole(ctx context.Context, serverID, actorID, userID, role string) error {
+	ctx, done := s.begin(ctx, "SetMemberRole")
+	defer done()
+	rank := map[string]int{RoleMember: 0, RoleModerator: 1, RoleAdmin: 2}
+	want, ok := rank[role]
+	if !ok {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		var current string
+		err := tx.QueryRowContext(ctx,
+			`SELECT role FROM server_user WHERE server_id = $1 AND user_id = $2 FOR UPDATE`, serverID, userID,
+		).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotMember
+		}
+		if err != nil {
+			return err
+		}
+		actorRank, err := memberRank(ctx, tx, serverID, actorID)
+		if err != nil {
+			return err
+		}
+		targetRank, err := memberRank(ctx, tx, serverID, userID)
+		if err != nil {
+			return err
+		}
+		if targetRank >= actorRank || want >= actorRank {
+			return ErrRoleOutranked
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE server_user SET role = $1 WHERE server_id = $2 AND user_id = $3`, role, serverID, userID,
+		)
+		return err
+	})
+}
+
+// IsAdmin reports whether userID may change serverID's settings: the server
+// owner, or a member whose role is admin.
+func (s *Database) IsAdmin(ctx context.Context, serverID, userID string) (bool, error) {
+	ctx, done := s.begin(ctx, "IsAdmin")
+	defer done()
+	var ok bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM servers WHERE id = $1 AND owner_id = $2)
+		    OR EXISTS(SELECT 1 FROM server_user
+		              WHERE server_id = $1 AND user_id = $2 AND role = $3)
+	`, serverID, userID, RoleAdmin).Scan(&ok)
+	return ok, err
+}
+
+// IsMember reports whether userID is a member of serverID.
+func (s *Database) IsMember(ctx context.Context, serverID, userID string) (bool, error) {
+	ctx, done := s.begin(ctx, "IsMember")
+	defer done()
+	var ok bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM server_user WHERE server_id = $1 AND user_id = $2)`,
 		serverID, userID,
-	)
-	return err
+	).Scan(&ok)
+	return ok, err
 }
 
-func (s *Database) GetServerMembers(serverID string) ([]models.User, error) {
-	rows, err := s.db.Query(`
-		SELECT u.id, u.username, u.email, u.created_at
+// ListUserServers returns summaries of the servers userID belongs to, in the
+// order they arranged them.
+func (s *Database) ListUserServers(ctx context.Context, userID string) ([]models.UserServer, error) {
+	ctx, done := s.begin(ctx, "ListUserServers")
+	defer done()
+	var servers []models.UserServer
+	err := s.read(ctx, func(db *sql.DB) error {
+		servers = []models.UserServer{}
+		rows, err := db.QueryContext(ctx, `
+			SELECT `+serverSummaryColumns+`, su.position, `+unreadColumns+`
+			FROM server_user su
+			JOIN servers s ON s.id = su.server_id
+			WHERE su.user_id = $1
+			ORDER BY su.position, s.id
+		`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var us models.UserServer
+			if err := scanServerSummary(rows, &us.ServerSummary, &us.Position, &us.UnreadCount, &us.MentionCount); err != nil {
+				return err
+			}
+			servers = append(servers, us)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// ReorderServers arranges userID's server list in the order of serverIDs,
+// which must name each of their servers exactly once.
+func (s *Database) ReorderServers(ctx context.Context, userID string, serverIDs []string) error {
+	ctx, done := s.begin(ctx, "ReorderServers")
+	defer done()
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := queryIDs(ctx, tx,
+			`SELECT server_id FROM server_user WHERE user_id = $1 ORDER BY server_id FOR UPDATE`, userID)
+		if err != nil {
+			return err
+		}
+		requested := slices.Sorted(slices.Values(serverIDs))
+		if !slices.Equal(current, requested) {
+			return ErrServerOrder
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE server_user SET position = array_position($2::text[], server_id) - 1
+			WHERE user_id = $1
+		`, userID, pq.Array(serverIDs))
+		return err
+	})
+}
+
+func (s *Database) GetServerMembers(ctx context.Context, serverID string) ([]models.PublicUser, error) {
+	ctx, done := s.begin(ctx, "GetServerMembers")
+	defer done()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+userColumns+`
 		FROM users u
 		JOIN server_user su ON su.user_id = u.id
 		WHERE su.server_id = $1
@@ -356,52 +2099,577 @@ func (s *Database) GetServerMembers(serverID string) ([]models.User, error) {
 		return nil, err
 	}
 	defer rows.Close()
-	var members []models.User
+	var members []models.PublicUser
 	for rows.Next() {
-		var u models.User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.CreatedAt); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		members = append(members, u)
+		members = append(members, u.Public())
 	}
 	return members, rows.Err()
 }
 
 // --- Messages ---
 
-func (s *Database) CreateMessage(m models.Message) error {
-	var exists bool
-	if err := s.db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM servers WHERE id = $1)`, m.ServerID,
-	).Scan(&exists); err != nil {
+// CreateMessage stores a message. When slowMode is positive and the author's
+// previous message in the server is more recent than that, nothing is stored
+// and the remaining wait is returned instead. The check and the insert are
+// atomic per author and server. flags, if any, are queued for moderator
+// review along with the message.
+func (s *Database) CreateMessage(ctx context.Context, m models.Message, slowMode time.Duration, flags ...models.Flag) (time.Duration, error) {
+	ctx, done := s.begin(ctx, "CreateMessage")
+	defer done()
+	var wait time.Duration
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS(SELECT 1 FROM servers WHERE id = $1 FOR SHARE)`, m.ServerID,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("server %s not found", m.ServerID)
+		}
+
+		if slowMode > 0 {
+			// Serialize each author's messages in the server so that two
+			// concurrent sends cannot both pass the check.
+			if _, err := tx.ExecContext(ctx,
+				`SELECT pg_advisory_xact_lock(hashtext($1::text || ':' || $2::text))`, m.ServerID, m.AuthorID,
+			); err != nil {
+				return err
+			}
+			var last sql.NullTime
+			if err := tx.QueryRowContext(ctx,
+				`SELECT MAX(created_at) FROM messages WHERE server_id = $1 AND author_id = $2`,
+				m.ServerID, m.AuthorID,
+			).Scan(&last); err != nil {
+				return err
+			}
+			if last.Valid && last.Time.Add(slowMode).After(m.CreatedAt) {
+				wait = last.Time.Add(slowMode).Sub(m.CreatedAt)
+				return nil
+			}
+		}
+		if err := s.step("CreateMessage:insert"); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO messages (id, server_id, author_id, content, created_at) VALUES ($1, $2, $3, $4, $5)`,
+			m.ID, m.ServerID, m.AuthorID, m.Content, m.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if err := insertFlags(ctx, tx, flags...); err != nil {
+			return err
+		}
+		names := filter.Mentions(m.Content)
+		if len(names) == 0 {
+			return nil
+		}
+		// Only members other than the author can be mentioned.
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO message_mentions (message_id, user_id, server_id, created_at)
+			SELECT $1, su.user_id, su.server_id, $4
+			FROM server_user su
+			JOIN users u ON u.id = su.user_id
+			WHERE su.server_id = $2 AND su.user_id <> $3 AND lower(u.username) = ANY($5)
+		`, m.ID, m.ServerID, m.AuthorID, m.CreatedAt, pq.Array(names))
 		return err
+	})
+	return wait, err
+}
+
+// unreadColumns selects, for the server_user row su, the number of messages
+// from others and of mentions of the user since they last read the server.
+const unreadColumns = `
+	(SELECT COUNT(*) FROM messages m
+	 WHERE m.server_id = su.server_id AND m.created_at > su.last_read_at AND m.author_id <> su.user_id),
+	(SELECT COUNT(*) FROM message_mentions mm
+	 WHERE mm.user_id = su.user_id AND mm.server_id = su.server_id AND mm.created_at > su.last_read_at)`
+
+// AckMessage marks userID as having read serverID up to and including
+// messageID. Acknowledging a message older than one already read leaves the
+// read position where it is.
+func (s *Database) AckMessage(ctx context.Context, serverID, userID, messageID string) (models.ReadState, error) {
+	ctx, done := s.begin(ctx, "AckMessage")
+	defer done()
+	var state models.ReadState
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var sent time.Time
+		err := tx.QueryRowContext(ctx,
+			`SELECT created_at FROM messages WHERE id = $1 AND server_id = $2`, messageID, serverID,
+		).Scan(&sent)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("message %s not found", messageID)
+		}
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE server_user SET
+				last_read_message_id = CASE WHEN $3 > last_read_at THEN $4 ELSE last_read_message_id END,
+				last_read_at = GREATEST(last_read_at, $3)
+			WHERE server_id = $1 AND user_id = $2
+		`, serverID, userID, sent, messageID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("user %s is not a member of server %s", userID, serverID)
+		}
+		state, err = readState(ctx, tx, serverID, userID)
+		return err
+	})
+	return state, err
+}
+
+// GetReadState returns how far userID has read serverID.
+func (s *Database) GetReadState(ctx context.Context, serverID, userID string) (models.ReadState, error) {
+	ctx, done := s.begin(ctx, "GetReadState")
+	defer done()
+	var state models.ReadState
+	err := s.read(ctx, func(db *sql.DB) (err error) {
+		state, err = readState(ctx, db, serverID, userID)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ReadState{}, fmt.Errorf("user %s is not a member of server %s", userID, serverID)
 	}
-	if !exists {
-		return fmt.Errorf("server %s not found", m.ServerID)
+	return state, err
+}
+
+func readState(ctx context.Context, q queryer, serverID, userID string) (models.ReadState, error) {
+	state := models.ReadState{ServerID: serverID}
+	err := q.QueryRowContext(ctx, `
+		SELECT su.last_read_message_id, su.last_read_at, `+unreadColumns+`
+		FROM server_user su
+		WHERE su.server_id = $1 AND su.user_id = $2
+	`, serverID, userID).Scan(&state.LastReadMessageID, &state.LastReadAt, &state.UnreadCount, &state.MentionCount)
+	return state, err
+}
+
+func (s *Database) GetMessagesByServer(ctx context.Context, serverID string) []models.Message {
+	ctx, done := s.begin(ctx, "GetMessagesByServer")
+	defer done()
+	var msgs []models.Message
+	s.read(ctx, func(db *sql.DB) error {
+		msgs = nil
+		rows, err := db.QueryContext(ctx,
+			`SELECT id, server_id, author_id, content, created_at FROM messages WHERE server_id = $1 ORDER BY created_at`,
+			serverID,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var m models.Message
+			if err := rows.Scan(&m.ID, &m.ServerID, &m.AuthorID, &m.Content, &m.CreatedAt); err != nil {
+				return err
+			}
+			msgs = append(msgs, m)
+		}
+		return rows.Err()
+	})
+	return msgs
+}
+
+func (s *Database) DeleteMessage(ctx context.Context, id string) error {
+	ctx, done := s.begin(ctx, "DeleteMessage")
+	defer done()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM messages WHERE id = $1`, id)
+	if err != nil {
+		return err
 	}
-	_, err := s.db.Exec(
-		`INSERT INTO messages (id, server_id, author_id, content, created_at) VALUES ($1, $2, $3, $4, $5)`,
-		m.ID, m.ServerID, m.AuthorID, m.Content, m.CreatedAt,
-	)
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("message %s not found", id)
+	}
+	return nil
+}
+
+// --- Moderation ---
+
+// GetFilterRules returns a server's content filter rules. Servers that have
+// never configured a filter get the zero Rules, which allow everything.
+func (s *Database) GetFilterRules(ctx context.Context, serverID string) (filter.Rules, error) {
+	ctx, done := s.begin(ctx, "GetFilterRules")
+	defer done()
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, `SELECT rules FROM server_filters WHERE server_id = $1`, serverID).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return filter.Rules{}, nil
+	}
+	if err != nil {
+		return filter.Rules{}, err
+	}
+	var rules filter.Rules
+	err = json.Unmarshal(raw, &rules)
+	return rules, err
+}
+
+func (s *Database) SetFilterRules(ctx context.Context, serverID string, rules filter.Rules) error {
+	ctx, done := s.begin(ctx, "SetFilterRules")
+	defer done()
+	raw, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO server_filters (server_id, rules, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (server_id) DO UPDATE SET rules = EXCLUDED.rules, updated_at = EXCLUDED.updated_at
+	`, serverID, raw)
 	return err
 }
 
-func (s *Database) GetMessagesByServer(serverID string) []models.Message {
-	rows, err := s.db.Query(
-		`SELECT id, server_id, author_id, content, created_at FROM messages WHERE server_id = $1 ORDER BY created_at`,
-		serverID,
-	)
+// Flag review states.
+const (
+	FlagPending  = "pending"
+	FlagApproved = "approved"
+	FlagRemoved  = "removed"
+)
+
+// Flagged content types.
+const (
+	ContentPost    = "post"
+	ContentMessage = "message"
+)
+
+// insertFlags queues flags for review as part of tx, so that flagged content
+// is never stored without its queue entry.
+func insertFlags(ctx context.Context, tx *sql.Tx, flags ...models.Flag) error {
+	for _, f := range flags {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO content_flags (id, server_id, content_type, content_id, author_id, content, reasons, status, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, f.ID, f.ServerID, f.ContentType, f.ContentID, f.AuthorID, f.Content, pq.Array(f.Reasons), f.Status, f.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListFlags returns a server's flagged content with the given status, oldest
+// first. An empty status lists every flag.
+func (s *Database) ListFlags(ctx context.Context, serverID, status string) ([]models.Flag, error) {
+	ctx, done := s.begin(ctx, "ListFlags")
+	defer done()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, server_id, content_type, content_id, author_id, content, reasons,
+		       status, reviewed_by, reviewed_at, created_at
+		FROM content_flags
+		WHERE server_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at, id
+	`, serverID, status)
 	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var flags []models.Flag
+	for rows.Next() {
+		var f models.Flag
+		var reviewedAt sql.NullTime
+		if err := rows.Scan(&f.ID, &f.ServerID, &f.ContentType, &f.ContentID, &f.AuthorID, &f.Content,
+			pq.Array(&f.Reasons), &f.Status, &f.ReviewedBy, &reviewedAt, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		if reviewedAt.Valid {
+			f.ReviewedAt = &reviewedAt.Time
+		}
+		flags = append(flags, f)
+	}
+	return flags, rows.Err()
+}
+
+// ResolveFlag closes a pending flag in serverID as approved or removed. When
+// removed, the flagged message is deleted, or the flagged post marked deleted
+// by the reviewer, in the same transaction.
+func (s *Database) ResolveFlag(ctx context.Context, serverID, flagID, reviewerID, status string) error {
+	ctx, done := s.begin(ctx, "ResolveFlag")
+	defer done()
+	var deletedPost string
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var contentType, contentID string
+		err := tx.QueryRowContext(ctx, `
+			UPDATE content_flags SET status = $1, reviewed_by = $2, reviewed_at = NOW()
+			WHERE id = $3 AND server_id = $4 AND status = $5
+			RETURNING content_type, content_id
+		`, status, reviewerID, flagID, serverID, FlagPending).Scan(&contentType, &contentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("pending flag %s not found", flagID)
+		}
+		if err != nil {
+			return err
+		}
+
+		if status == FlagRemoved {
+			if contentType == ContentMessage {
+				_, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE id = $1`, contentID)
+				return err
+			}
+			err := softDeletePost(ctx, tx, serverID, contentID, reviewerID)
+			if errors.Is(err, sql.ErrNoRows) {
+				// Already deleted by its author or another moderator.
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			deletedPost = contentID
+			return touch(ctx, tx, "servers", serverID)
+		}
 		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if deletedPost != "" {
+		s.invalidate(cachePost, deletedPost)
+		s.invalidate(cacheServer, serverID)
+	}
+	return nil
+}
+
+// --- Reports ---
+
+// Report review states.
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// Moderator actions taken when resolving a report.
+const (
+	ActionNone          = "none"
+	ActionDeleteContent = "delete_content"
+	ActionKick          = "kick"
+	ActionBan           = "ban"
+)
+
+// ContentUser is the target type for reports about a user.
+const ContentUser = "user"
+
+// reportTargetAuthor returns the user responsible for a report target in
+// serverID: the author of a post or message, or the reported user, who must
+// be a member.
+func reportTargetAuthor(ctx context.Context, q queryer, serverID, targetType, targetID string) (string, error) {
+	var query string
+	switch targetType {
+	case ContentPost:
+		query = `SELECT author_id FROM posts WHERE id = $1 AND server_id = $2`
+	case ContentMessage:
+		query = `SELECT author_id FROM messages WHERE id = $1 AND server_id = $2`
+	case ContentUser:
+		query = `SELECT user_id FROM server_user WHERE user_id = $1 AND server_id = $2`
+	default:
+		return "", fmt.Errorf("unknown report target type %q", targetType)
+	}
+	var authorID string
+	err := q.QueryRowContext(ctx, query, targetID, serverID).Scan(&authorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%w: %s %s not found in server %s", ErrInvalidReportTarget, targetType, targetID, serverID)
+	}
+	return authorID, err
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// CreateReport files a report after checking that its target exists in the
+// report's server.
+func (s *Database) CreateReport(ctx context.Context, r models.Report) error {
+	ctx, done := s.begin(ctx, "CreateReport")
+	defer done()
+	if _, err := reportTargetAuthor(ctx, s.db, r.ServerID, r.TargetType, r.TargetID); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO reports (id, server_id, reporter_id, target_type, target_id, reason, details, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, r.ID, r.ServerID, r.ReporterID, r.TargetType, r.TargetID, r.Reason, r.Details, r.Status, r.CreatedAt)
+	if violatesConstraint(err, "reports_open_unique_idx") {
+		return ErrDuplicateReport
+	}
+	return err
+}
+
+// ListReports returns a server's reports with the given status, oldest first.
+// An empty status lists every report.
+func (s *Database) ListReports(ctx context.Context, serverID, status string) ([]models.Report, error) {
+	ctx, done := s.begin(ctx, "ListReports")
+	defer done()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, server_id, reporter_id, target_type, target_id, reason, details,
+		       status, action, resolved_by, resolved_at, created_at
+		FROM reports
+		WHERE server_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at, id
+	`, serverID, status)
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
-	var msgs []models.Message
+	var reports []models.Report
 	for rows.Next() {
-		var m models.Message
-		if err := rows.Scan(&m.ID, &m.ServerID, &m.AuthorID, &m.Content, &m.CreatedAt); err != nil {
-			return msgs
+		var r models.Report
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&r.ID, &r.ServerID, &r.ReporterID, &r.TargetType, &r.TargetID, &r.Reason, &r.Details,
+			&r.Status, &r.Action, &r.ResolvedBy, &resolvedAt, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		if resolvedAt.Valid {
+			r.ResolvedAt = &resolvedAt.Time
 		}
-		msgs = append(msgs, m)
+		reports = append(reports, r)
 	}
-	return msgs
+	return reports, rows.Err()
+}
+
+// ResolveReport closes an open report in serverID and carries out the
+// moderator's action in the same transaction. Dismissing takes no action.
+// Deleting content, kicking or banning also resolves every other open report
+// against the same target. Moderators can only kick or ban members below
+// their own role, and never the server owner.
+func (s *Database) ResolveReport(ctx context.Context, serverID, reportID, moderatorID, status, action string) error {
+	ctx, done := s.begin(ctx, "ResolveReport")
+	defer done()
+	var deletedPost string
+	actionTaken := false
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var targetType, targetID string
+		err := tx.QueryRowContext(ctx, `
+			SELECT target_type, target_id FROM reports
+			WHERE id = $1 AND server_id = $2 AND status = $3
+			FOR UPDATE
+		`, reportID, serverID, ReportOpen).Scan(&targetType, &targetID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrReportNotFound, reportID)
+		}
+		if err != nil {
+			return err
+		}
+
+		if status == ReportResolved && action != ActionNone {
+			authorID, err := reportTargetAuthor(ctx, tx, serverID, targetType, targetID)
+			if err != nil {
+				return err
+			}
+			switch action {
+			case ActionDeleteContent:
+				switch targetType {
+				case ContentMessage:
+					if _, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE id = $1`, targetID); err != nil {
+						return err
+					}
+				case ContentPost:
+					err := softDeletePost(ctx, tx, serverID, targetID, moderatorID)
+					if err != nil && !errors.Is(err, sql.ErrNoRows) {
+						return err
+					}
+					deletedPost = targetID
+				default:
+					return fmt.Errorf("%w: only posts and messages can be deleted", ErrInvalidReportTarget)
+				}
+			case ActionKick, ActionBan:
+				modRank, err := memberRank(ctx, tx, serverID, moderatorID)
+				if err != nil {
+					return err
+				}
+				targetRank, err := memberRank(ctx, tx, serverID, authorID)
+				if err != nil {
+					return err
+				}
+				if targetRank >= modRank {
+					return ErrOutranked
+				}
+				if _, err := tx.ExecContext(ctx,
+					`DELETE FROM server_user WHERE server_id = $1 AND user_id = $2`, serverID, authorID,
+				); err != nil {
+					return err
+				}
+				if err := touch(ctx, tx, "users", authorID); err != nil {
+					return err
+				}
+				if action == ActionBan {
+					if _, err := tx.ExecContext(ctx, `
+						INSERT INTO server_bans (server_id, user_id, banned_by, reason) VALUES ($1, $2, $3, $4)
+						ON CONFLICT DO NOTHING
+					`, serverID, authorID, moderatorID, "report "+reportID); err != nil {
+						return err
+					}
+				}
+			default:
+				return fmt.Errorf("unknown action %q", action)
+			}
+			actionTaken = true
+			if err := touch(ctx, tx, "servers", serverID); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE reports SET status = $1, action = $2, resolved_by = $3, resolved_at = NOW()
+			WHERE server_id = $4
+			  AND (id = $5 OR ($6 AND target_type = $7 AND target_id = $8 AND status = 'open'))
+		`, status, action, moderatorID, serverID, reportID, actionTaken, targetType, targetID)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if actionTaken {
+		// Deleting a post changes the server's post list; kicks and bans
+		// change its members.
+		if deletedPost != "" {
+			s.invalidate(cachePost, deletedPost)
+		}
+		s.invalidate(cacheServer, serverID)
+	}
+	return nil
+}
+
+// GetReportCounts summarizes a server's reports and pending content flags.
+func (s *Database) GetReportCounts(ctx context.Context, serverID string) (models.ReportCounts, error) {
+	ctx, done := s.begin(ctx, "GetReportCounts")
+	defer done()
+	counts := models.ReportCounts{OpenByReason: map[string]int{}}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT status, reason, COUNT(*) FROM reports WHERE server_id = $1 GROUP BY status, reason
+	`, serverID)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status, reason string
+		var n int
+		if err := rows.Scan(&status, &reason, &n); err != nil {
+			return counts, err
+		}
+		switch status {
+		case ReportOpen:
+			counts.Open += n
+			counts.OpenByReason[reason] += n
+		case ReportResolved:
+			counts.Resolved += n
+		case ReportDismissed:
+			counts.Dismissed += n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return counts, err
+	}
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM content_flags WHERE server_id = $1 AND status = $2`, serverID, FlagPending,
+	).Scan(&counts.PendingFlags)
+	return counts, err
 }
@@ -0,0 +1,684 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tonitran/dischord/cache"
+	"github.com/tonitran/dischord/models"
+)
+
+// blockingDriver's queries never finish; they return only when their
+// context is done, like a query stuck behind a lock.
+type blockingDriver struct{}
+
+func (blockingDriver) Open(string) (driver.Conn, error) { return blockingConn{}, nil }
+
+type blockingConn struct{}
+
+func (blockingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (blockingConn) Close() error                        { return nil }
+func (blockingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (blockingConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingConn) ExecContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// downDriver can't connect, like a database that is unreachable.
+type downDriver struct{}
+
+func (downDriver) Open(string) (driver.Conn, error) { return nil, errors.New("connection refused") }
+
+func init() {
+	sql.Register("blocking", blockingDriver{})
+	sql.Register("down", downDriver{})
+}
+
+func openDB(t *testing.T, driverName string) *sql.DB {
+	db, err := sql.Open(driverName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func blockingStore(t *testing.T, timeout time.Duration) *Database {
+	db, err := sql.Open("blocking", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := New(db)
+	s.QueryTimeout = timeout
+	return s
+}
+
+func TestQueryTimeout(t *testing.T) {
+	s := blockingStore(t, 20*time.Millisecond)
+
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{name: "query", call: func(ctx context.Context) error {
+			_, err := s.GetUser(ctx, "u1")
+			return err
+		}},
+		{name: "exec", call: func(ctx context.Context) error {
+			return s.CreateUser(ctx, models.User{ID: "u1"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			err := tt.call(t.Context())
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("query ran for %v after its timeout", elapsed)
+			}
+		})
+	}
+}
+
+func TestCallerCancellation(t *testing.T) {
+	s := blockingStore(t, 0)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := s.GetPost(ctx, "s1", "p1")
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("query kept running after its context was cancelled")
+	}
+}
+
+var errInjected = errors.New("injected failure")
+
+// failAtStep makes s fail at the named transaction step.
+func failAtStep(s *Database, name string) {
+	s.failAt = func(step string) error {
+		if step == name {
+			return errInjected
+		}
+		return nil
+	}
+}
+
+func TestTransactions_RollBackOnFailure(t *testing.T) {
+	s := testStore(t)
+	ctx := t.Context()
+	s.CreateUser(ctx, models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(ctx, models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateServer(ctx, models.Server{ID: "s1", Name: "general", OwnerID: "u1", MemberIDs: []string{"u1"}})
+	s.CreatePost(ctx, models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello"})
+
+	t.Run("CreateServer", func(t *testing.T) {
+		failAtStep(s, "CreateServer:members")
+		defer func() { s.failAt = nil }()
+		err := s.CreateServer(ctx, models.Server{ID: "s2", Name: "new", OwnerID: "u1", MemberIDs: []string{"u1"}})
+		if !errors.Is(err, errInjected) {
+			t.Fatalf("got error %v, want injected failure", err)
+		}
+		if _, err := s.GetServer(ctx, "s2"); err == nil {
+			t.Error("server exists without its owner as a member")
+		}
+	})
+
+	t.Run("AddFriend", func(t *testing.T) {
+		failAtStep(s, "AddFriend:insert")
+		defer func() { s.failAt = nil }()
+		if err := s.AddFriend(ctx, "u1", "u2"); !errors.Is(err, errInjected) {
+			t.Fatalf("got error %v, want injected failure", err)
+		}
+		if friends, _ := s.GetFriends(ctx, "u1"); len(friends) != 0 {
+			t.Errorf("got %d friends after a failed add, want 0", len(friends))
+		}
+	})
+
+	t.Run("PostVote", func(t *testing.T) {
+		failAtStep(s, "PostVote:score")
+		defer func() { s.failAt = nil }()
+		if _, err := s.PostVote(ctx, "p1", "u2", 1); !errors.Is(err, errInjected) {
+			t.Fatalf("got error %v, want injected failure", err)
+		}
+		if vote, _ := s.GetVote(ctx, "p1", "u2"); vote.Vote != 0 {
+			t.Errorf("vote %d was recorded without updating the score", vote.Vote)
+		}
+		if post, _ := s.GetPost(ctx, "s1", "p1"); post.Votes != 0 || post.Upvotes != 0 {
+			t.Errorf("got score %d and %d upvotes, want 0", post.Votes, post.Upvotes)
+		}
+	})
+
+	t.Run("UpdatePost", func(t *testing.T) {
+		failAtStep(s, "UpdatePost:update")
+		defer func() { s.failAt = nil }()
+		title := "Edited"
+		if _, err := s.UpdatePost(ctx, PostEdit{ID: "p1", ServerID: "s1", EditorID: "u1", Version: 1, Title: &title}); !errors.Is(err, errInjected) {
+			t.Fatalf("got error %v, want injected failure", err)
+		}
+		if revs, _ := s.ListPostRevisions(ctx, "p1"); len(revs) != 0 {
+			t.Errorf("got %d revisions after a failed edit, want 0", len(revs))
+		}
+	})
+
+	t.Run("DeleteServer", func(t *testing.T) {
+		failAtStep(s, "DeleteServer:members")
+		defer func() { s.failAt = nil }()
+		if err := s.DeleteServer(ctx, "s1"); !errors.Is(err, errInjected) {
+			t.Fatalf("got error %v, want injected failure", err)
+		}
+		if _, err := s.GetPost(ctx, "s1", "p1"); err != nil {
+			t.Errorf("post was deleted by a failed server deletion: %v", err)
+		}
+	})
+
+	t.Run("CreateMessage", func(t *testing.T) {
+		failAtStep(s, "CreateMessage:insert")
+		defer func() { s.failAt = nil }()
+		_, err := s.CreateMessage(ctx, models.Message{ID: "m1", ServerID: "s1", AuthorID: "u1", Content: "hi", CreatedAt: time.Now()}, 0)
+		if !errors.Is(err, errInjected) {
+			t.Fatalf("got error %v, want injected failure", err)
+		}
+		if msgs := s.GetMessagesByServer(ctx, "s1"); len(msgs) != 0 {
+			t.Errorf("got %d messages, want 0", len(msgs))
+		}
+	})
+}
+
+func TestUpdatePost_Versions(t *testing.T) {
+	s := testStore(t)
+	ctx := t.Context()
+	s.CreatePost(ctx, models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello", Body: "first"})
+
+	title, body := "Edited", "second"
+	updated, err := s.UpdatePost(ctx, PostEdit{ID: "p1", ServerID: "s1", EditorID: "u2", Version: 1, Title: &title, Body: &body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 2 || updated.Title != title || updated.Body != body {
+		t.Errorf("got version %d %q %q, want version 2 %q %q", updated.Version, updated.Title, updated.Body, title, body)
+	}
+
+	stale := "Stale"
+	current, err := s.UpdatePost(ctx, PostEdit{ID: "p1", ServerID: "s1", EditorID: "u1", Version: 1, Title: &stale})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("got error %v, want %v", err, ErrVersionConflict)
+	}
+	if current.Version != 2 || current.Title != title {
+		t.Errorf("conflict returned version %d %q, want the current post", current.Version, current.Title)
+	}
+
+	revs, err := s.ListPostRevisions(ctx, "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revs))
+	}
+	if revs[0].Version != 1 || revs[0].Title != "Hello" || revs[0].EditedBy != "u1" {
+		t.Errorf("got first revision %+v, want the original post by its author", revs[0])
+	}
+	if revs[1].Version != 2 || revs[1].Body != body || revs[1].EditedBy != "u2" {
+		t.Errorf("got second revision %+v, want the edit by u2", revs[1])
+	}
+}
+
+func TestPosts_ScopedToServer(t *testing.T) {
+	s := testStore(t)
+	ctx := t.Context()
+	s.CreatePost(ctx, models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello"})
+
+	if _, err := s.GetPost(ctx, "s2", "p1"); err == nil {
+		t.Error("GetPost: expected post in s1 not to be found through s2")
+	}
+	title := "Edited"
+	if _, err := s.UpdatePost(ctx, PostEdit{ID: "p1", ServerID: "s2", EditorID: "u1", Version: 1, Title: &title}); err == nil {
+		t.Error("UpdatePost: expected post in s1 not to be editable through s2")
+	}
+	if err := s.DeletePost(ctx, "s2", "p1", "u1"); err == nil {
+		t.Error("DeletePost: expected post in s1 not to be deletable through s2")
+	}
+	if p, err := s.GetPost(ctx, "s1", "p1"); err != nil || p.Title != "Hello" {
+		t.Errorf("got %+v, %v, want the post unchanged", p, err)
+	}
+}
+
+func TestPosts_SoftDelete(t *testing.T) {
+	s := testStore(t)
+	ctx := t.Context()
+	s.CreatePost(ctx, models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello"},
+		models.Flag{ID: "f1", ServerID: "s1", ContentType: ContentPost, ContentID: "p1", AuthorID: "u1", Status: FlagPending, CreatedAt: time.Now()})
+	s.PostVote(ctx, "p1", "u2", 1)
+	if err := s.CreateReport(ctx, models.Report{ID: "r1", ServerID: "s1", ReporterID: "u2", TargetType: ContentPost, TargetID: "p1", Reason: "spam", Status: ReportOpen, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeletePost(ctx, "s1", "p1", "u2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetPost(ctx, "s1", "p1"); err == nil {
+		t.Error("expected deleted post to be hidden")
+	}
+	if _, err := s.PostVote(ctx, "p1", "u3", 1); err == nil {
+		t.Error("expected votes on a deleted post to fail")
+	}
+	deleted, err := s.ListDeletedPosts(ctx, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].DeletedBy != "u2" || deleted[0].DeletedAt == nil {
+		t.Fatalf("got deleted posts %+v, want p1 deleted by u2", deleted)
+	}
+
+	if _, err := s.RestorePost(ctx, "s1", "p1"); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := s.GetPost(ctx, "s1", "p1"); err != nil || p.Votes != 1 {
+		t.Errorf("got %+v, %v, want the restored post with its vote", p, err)
+	}
+
+	// Once the retention window has passed the post can't be restored and
+	// is purged along with its votes, flags and reports.
+	s.DeletePost(ctx, "s1", "p1", "u2")
+	if _, err := s.db.ExecContext(ctx, `UPDATE posts SET deleted_at = $1 WHERE id = 'p1'`, time.Now().Add(-s.PostRetention-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RestorePost(ctx, "s1", "p1"); err == nil {
+		t.Error("expected a post past its retention window not to be restorable")
+	}
+	if n, err := s.PurgeDeletedPosts(ctx); err != nil || n != 1 {
+		t.Fatalf("got %d purged, %v, want 1", n, err)
+	}
+	if votes, _ := s.GetVoters(ctx, "p1"); len(votes) != 0 {
+		t.Errorf("got %d votes left on a purged post, want 0", len(votes))
+	}
+	if flags, _ := s.ListFlags(ctx, "s1", FlagPending); len(flags) != 0 {
+		t.Errorf("got %d flags left on a purged post, want 0", len(flags))
+	}
+	if reports, _ := s.ListReports(ctx, "s1", ReportOpen); len(reports) != 0 {
+		t.Errorf("got %d reports left on a purged post, want 0", len(reports))
+	}
+}
+
+func TestPostVote_Unchanged(t *testing.T) {
+	s := testStore(t)
+	ctx := t.Context()
+	s.CreatePost(ctx, models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello"})
+
+	for i, want := range []bool{true, false} {
+		changed, err := s.PostVote(ctx, "p1", "u2", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != want {
+			t.Errorf("vote %d: got changed %v, want %v", i, changed, want)
+		}
+	}
+}
+
+func TestCreateMessage_SlowModeIsAtomic(t *testing.T) {
+	s := testStore(t)
+	ctx := t.Context()
+	s.CreateUser(ctx, models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateServer(ctx, models.Server{ID: "s1", Name: "general", OwnerID: "u1"})
+
+	const senders = 5
+	results := make(chan time.Duration, senders)
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		go func() {
+			m := models.Message{ID: fmt.Sprintf("m%d", i), ServerID: "s1", AuthorID: "u2", Content: "hi", CreatedAt: time.Now()}
+			wait, err := s.CreateMessage(ctx, m, time.Minute)
+			results <- wait
+			errs <- err
+		}()
+	}
+	stored := 0
+	for i := 0; i < senders; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if <-results == 0 {
+			stored++
+		}
+	}
+	if stored != 1 {
+		t.Errorf("%d concurrent messages passed slow mode, want 1", stored)
+	}
+	if msgs := s.GetMessagesByServer(ctx, "s1"); len(msgs) != 1 {
+		t.Errorf("got %d stored messages, want 1", len(msgs))
+	}
+}
+
+func TestCache_ServesHits(t *testing.T) {
+	// Any query against the blocking store times out, so a successful read
+	// must have come from the cache.
+	s := blockingStore(t, 20*time.Millisecond)
+	s.Cache = cache.NewLRU(10, time.Minute)
+	s.Cache.Set(cacheKey(cachePost, "p1"), models.Post{ID: "p1", ServerID: "s1", Title: "Hello"})
+	s.Cache.Set(cacheKey(cacheServer, "s1"), models.Server{ID: "s1", MemberIDs: []string{"u1"}})
+
+	hits := cacheHits.Value(cachePost)
+	p, err := s.GetPost(t.Context(), "s1", "p1")
+	if err != nil || p.Title != "Hello" {
+		t.Fatalf("got %+v, %v, want cached post", p, err)
+	}
+	if got := cacheHits.Value(cachePost); got != hits+1 {
+		t.Errorf("got %v post hits, want %v", got, hits+1)
+	}
+
+	srv, err := s.GetServer(t.Context(), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.MemberIDs[0] = "changed"
+	if srv, _ := s.GetServer(t.Context(), "s1"); srv.MemberIDs[0] != "u1" {
+		t.Errorf("modifying a returned server changed the cache: %v", srv.MemberIDs)
+	}
+
+	misses := cacheMisses.Value(cachePost)
+	if _, err := s.GetPost(t.Context(), "s1", "p2"); err == nil {
+		t.Fatal("expected uncached post to reach the database")
+	}
+	if got := cacheMisses.Value(cachePost); got != misses+1 {
+		t.Errorf("got %v post misses, want %v", got, misses+1)
+	}
+}
+
+func TestCache_Invalidation(t *testing.T) {
+	s := testStore(t)
+	s.Cache = cache.NewLRU(100, time.Minute)
+	ctx := t.Context()
+	s.CreateUser(ctx, models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateUser(ctx, models.User{ID: "u2", Username: "bob", Email: "b@example.com"})
+	s.CreateServer(ctx, models.Server{ID: "s1", Name: "general", OwnerID: "u1", MemberIDs: []string{"u1"}})
+	s.CreatePost(ctx, models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello"})
+
+	post := func() models.Post {
+		t.Helper()
+		p, err := s.GetPost(ctx, "s1", "p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	server := func() models.Server {
+		t.Helper()
+		srv, err := s.GetServer(ctx, "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return srv
+	}
+
+	post()
+	if _, err := s.PostVote(ctx, "p1", "u2", 1); err != nil {
+		t.Fatal(err)
+	}
+	if p := post(); p.Votes != 1 {
+		t.Errorf("after vote: got score %d, want 1", p.Votes)
+	}
+	edited := "Edited"
+	if _, err := s.UpdatePost(ctx, PostEdit{ID: "p1", ServerID: "s1", EditorID: "u1", Version: 1, Title: &edited}); err != nil {
+		t.Fatal(err)
+	}
+	if p := post(); p.Title != "Edited" {
+		t.Errorf("after update: got title %q, want %q", p.Title, "Edited")
+	}
+
+	server()
+	if err := s.JoinServer(ctx, "s1", "u2"); err != nil {
+		t.Fatal(err)
+	}
+	if srv := server(); len(srv.MemberIDs) != 2 {
+		t.Errorf("after join: got members %v, want u1 and u2", srv.MemberIDs)
+	}
+	if err := s.CreatePost(ctx, models.Post{ID: "p2", ServerID: "s1", AuthorID: "u2", Title: "Second"}); err != nil {
+		t.Fatal(err)
+	}
+	if srv := server(); len(srv.Posts) != 2 {
+		t.Errorf("after create: got posts %v, want p1 and p2", srv.Posts)
+	}
+	if err := s.DeletePost(ctx, "s1", "p1", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetPost(ctx, "s1", "p1"); err == nil {
+		t.Error("expected deleted post to be gone")
+	}
+	if srv := server(); len(srv.Posts) != 1 {
+		t.Errorf("after delete: got posts %v, want p2", srv.Posts)
+	}
+	if err := s.DeleteUser(ctx, "u2"); err != nil {
+		t.Fatal(err)
+	}
+	if srv := server(); len(srv.MemberIDs) != 1 {
+		t.Errorf("after deleting u2: got members %v, want u1", srv.MemberIDs)
+	}
+}
+
+func TestReplicas_Routing(t *testing.T) {
+	primary, r1, r2 := openDB(t, "blocking"), openDB(t, "blocking"), openDB(t, "blocking")
+	s := New(primary, r1, r2)
+	t.Cleanup(func() { s.Close() })
+	ctx := t.Context()
+
+	used := map[*sql.DB]int{}
+	for i := 0; i < 4; i++ {
+		used[s.pickReplica(ctx).db]++
+	}
+	if used[r1] != 2 || used[r2] != 2 {
+		t.Errorf("expected reads spread evenly over replicas, got %d and %d", used[r1], used[r2])
+	}
+
+	s.replicas[0].healthy.Store(false)
+	for i := 0; i < 2; i++ {
+		if got := s.pickReplica(ctx); got.db != r2 {
+			t.Fatal("expected only the healthy replica to be used")
+		}
+	}
+	s.replicas[1].healthy.Store(false)
+	if got := s.pickReplica(ctx); got != nil {
+		t.Error("expected primary when no replica is healthy")
+	}
+}
+
+func TestReplicas_HealthCheck(t *testing.T) {
+	s := New(openDB(t, "blocking"), openDB(t, "down"), openDB(t, "blocking"))
+	t.Cleanup(func() { s.Close() })
+
+	s.checkReplicas(t.Context())
+	if s.replicas[0].healthy.Load() {
+		t.Error("expected unreachable replica to be unhealthy")
+	}
+	if !s.replicas[1].healthy.Load() {
+		t.Error("expected reachable replica to be healthy")
+	}
+}
+
+func TestReplicas_ReadFallsBackToPrimary(t *testing.T) {
+	primary, r := openDB(t, "blocking"), openDB(t, "blocking")
+	s := New(primary, r)
+	t.Cleanup(func() { s.Close() })
+
+	tests := []struct {
+		name        string
+		replicaErr  error
+		wantHealthy bool
+	}{
+		{name: "replica error", replicaErr: errors.New("connection reset"), wantHealthy: false},
+		{name: "no rows on lagging replica", replicaErr: sql.ErrNoRows, wantHealthy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.replicas[0].healthy.Store(true)
+			var calls []*sql.DB
+			err := s.read(t.Context(), func(db *sql.DB) error {
+				calls = append(calls, db)
+				if db == r {
+					return tt.replicaErr
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(calls) != 2 || calls[0] != r || calls[1] != primary {
+				t.Errorf("expected replica then primary, got %d calls", len(calls))
+			}
+			if got := s.replicas[0].healthy.Load(); got != tt.wantHealthy {
+				t.Errorf("got healthy %v, want %v", got, tt.wantHealthy)
+			}
+		})
+	}
+}
+
+func TestReplicas_ReadYourWrites(t *testing.T) {
+	s := New(openDB(t, "blocking"), openDB(t, "blocking"))
+	t.Cleanup(func() { s.Close() })
+	s.ReadYourWrites = time.Minute
+	s.Cache = cache.NewLRU(10, time.Minute)
+	s.Cache.Set(cacheKey(cachePost, "p1"), models.Post{ID: "p1"})
+
+	alice := WithUser(t.Context(), "alice")
+	bob := WithUser(t.Context(), "bob")
+	s.NoteWrite("alice")
+
+	if s.pickReplica(alice) != nil {
+		t.Error("expected the writer's reads to go to the primary")
+	}
+	if s.pickReplica(bob) == nil {
+		t.Error("expected other users' reads to go to a replica")
+	}
+	if _, ok := s.cached(alice, cachePost, "p1"); ok {
+		t.Error("expected the writer to bypass the cache")
+	}
+	if _, ok := s.cached(bob, cachePost, "p1"); !ok {
+		t.Error("expected other users to be served from the cache")
+	}
+	if s.pickReplica(WithPrimary(bob)) != nil {
+		t.Error("expected reads within a writing request to go to the primary")
+	}
+
+	// Rows read from a replica may be stale and are not cached.
+	s.fill(s.replicas[0].db, cachePost, "p2", models.Post{ID: "p2"})
+	if _, ok := s.Cache.Get(cacheKey(cachePost, "p2")); ok {
+		t.Error("expected a replica read not to fill the cache")
+	}
+
+	s.writes.last["alice"] = time.Now().Add(-2 * time.Minute)
+	if s.pickReplica(alice) == nil {
+		t.Error("expected replica reads once the window has passed")
+	}
+}
+
+func TestApplySchema_DedupesUsers(t *testing.T) {
+	s := testStore(t)
+	ctx := t.Context()
+	// Recreate a database from before usernames and emails were unique.
+	if _, err := s.db.ExecContext(ctx, `
+		DROP INDEX users_username_lower_idx;
+		DROP INDEX users_email_lower_idx;
+		INSERT INTO users (id, username, email, email_verified, created_at) VALUES
+			('u1', 'Alice', 'Alice@Example.com', FALSE, NOW() - INTERVAL '2 days'),
+			('u2', 'alice', 'alice@example.com', TRUE, NOW() - INTERVAL '1 day');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplySchema(s.db); err != nil {
+		t.Fatal(err)
+	}
+
+	u1, err := s.GetUser(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u2, err := s.GetUser(ctx, "u2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u1.Username != "Alice" || u2.Username != "alice-u2" {
+		t.Errorf("got usernames %q and %q, want the older one kept", u1.Username, u2.Username)
+	}
+	if u1.Email != "" || u1.EmailVerified || u2.Email != "alice@example.com" {
+		t.Errorf("got emails %q and %q, want the verified one kept and lowercased", u1.Email, u2.Email)
+	}
+}
+
+func TestCreatePost_FlagIsAtomic(t *testing.T) {
+	s := testStore(t)
+	ctx := t.Context()
+	flag := models.Flag{ID: "f1", ServerID: "s1", ContentType: ContentPost, ContentID: "p1", AuthorID: "u1", Status: FlagPending, CreatedAt: time.Now()}
+	if err := s.CreatePost(ctx, models.Post{ID: "p1", ServerID: "s1", AuthorID: "u1", Title: "Hello"}, flag); err != nil {
+		t.Fatal(err)
+	}
+	if flags, _ := s.ListFlags(ctx, "s1", FlagPending); len(flags) != 1 {
+		t.Fatalf("got %d flags, want 1", len(flags))
+	}
+
+	// A flag that can't be stored keeps the post from being stored too.
+	flag.ContentID = "p2"
+	if err := s.CreatePost(ctx, models.Post{ID: "p2", ServerID: "s1", AuthorID: "u1", Title: "Again"}, flag); err == nil {
+		t.Fatal("expected a duplicate flag to fail")
+	}
+	if _, err := s.GetPost(ctx, "s1", "p2"); err == nil {
+		t.Error("post was stored without its flag")
+	}
+}
+
+func TestUpdateServerSettings_Partial(t *testing.T) {
+	s := testStore(t)
+	ctx := t.Context()
+	s.CreateUser(ctx, models.User{ID: "u1", Username: "alice", Email: "a@example.com"})
+	s.CreateServer(ctx, models.Server{ID: "s1", Name: "general", OwnerID: "u1", Tags: []string{"go"}, Visibility: VisibilityPrivate})
+
+	// Two admins change different fields; neither change is lost.
+	name, desc := "gophers", "All things Go"
+	if err := s.UpdateServerSettings(ctx, ServerSettings{ID: "s1", Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateServerSettings(ctx, ServerSettings{ID: "s1", Description: &desc}); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := s.GetServer(ctx, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Name != name || srv.Description != desc || strings.Join(srv.Tags, ",") != "go" || srv.Visibility != VisibilityPrivate {
+		t.Errorf("got %+v, want both changes applied and other fields kept", srv)
+	}
+
+	noTags := []string{}
+	if err := s.UpdateServerSettings(ctx, ServerSettings{ID: "s1", Tags: &noTags}); err != nil {
+		t.Fatal(err)
+	}
+	if srv, _ = s.GetServer(ctx, "s1"); len(srv.Tags) != 0 {
+		t.Errorf("got tags %v, want them cleared", srv.Tags)
+	}
+	if err := s.UpdateServerSettings(ctx, ServerSettings{ID: "missing", Name: &name}); err == nil {
+		t.Error("expected an error for an unknown server")
+	}
+}
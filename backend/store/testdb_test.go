@@ -0,0 +1,33 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+func testStore(t *testing.T) *Database {
+	t.Helper()
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplySchema(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := TruncateAll(db); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		TruncateAll(db)
+		db.Close()
+	})
+	return New(db)
+}
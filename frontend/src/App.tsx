@@ -19,7 +19,7 @@ export default function App() {
     setServerIds(storedServerIds)
 
     if (storedUserId) {
-      api.getUser(storedUserId)
+      api.getMe(storedUserId)
         .then(user => {
           setCurrentUser(user)
           const merged = Array.from(new Set([...storedServerIds, ...(user.server_ids ?? [])]))
@@ -109,6 +109,7 @@ export default function App() {
         <CreateServerModal
           currentUser={currentUser}
           onCreated={handleServerCreated}
+          onUserUpdated={setCurrentUser}
           onClose={() => setShowCreateServer(false)}
         />
       )}
@@ -1,18 +1,49 @@
+import { Server, User } from '../types'
+
 const BASE = '/api'
 
 async function apiFetch(path: string, options?: RequestInit) {
+  const userId = localStorage.getItem('dischord_user_id')
   const res = await fetch(`${BASE}${path}`, {
-    headers: { 'Content-Type': 'application/json', ...options?.headers },
     ...options,
+    headers: {
+      'Content-Type': 'application/json',
+      ...(userId ? { 'X-User-ID': userId } : {}),
+      ...options?.headers,
+    },
   })
   if (!res.ok) {
     const text = await res.text()
-    throw new Error(text || `HTTP ${res.status}`)
+    let message = text
+    try {
+      // Some errors come as JSON with details alongside the message.
+      const body = JSON.parse(text)
+      if (typeof body?.error === 'string') message = body.error
+    } catch { /* plain text */ }
+    throw new Error(message || `HTTP ${res.status}`)
   }
   if (res.status === 204) return null
   return res.json()
 }
 
+// The bulk endpoints accept at most this many IDs per request.
+const MAX_BATCH_IDS = 100
+
+// getBatch loads ids through a bulk endpoint, splitting the list into as few
+// requests as the server allows. Results keep the order of ids; unknown IDs
+// are left out.
+async function getBatch(path: string, ids: string[]) {
+  if (ids.length === 0) return []
+  const chunks: string[][] = []
+  for (let i = 0; i < ids.length; i += MAX_BATCH_IDS) {
+    chunks.push(ids.slice(i, i + MAX_BATCH_IDS))
+  }
+  const results = await Promise.all(
+    chunks.map(chunk => apiFetch(`${path}?ids=${chunk.map(encodeURIComponent).join(',')}`))
+  )
+  return results.flat()
+}
+
 export const api = {
   // Users
   createUser: (username: string, email: string) =>
@@ -21,6 +52,34 @@ export const api = {
   getUser: (id: string) =>
     apiFetch(`/users/${id}`),
 
+  getUsers: (ids: string[]) =>
+    getBatch('/users', ids),
+
+  // getMe fetches the private view of the user's own account.
+  getMe: (id: string) =>
+    apiFetch(`/users/${id}`, { headers: { 'X-User-ID': id } }),
+
+  updateUser: (id: string, profile: Partial<Pick<User, 'username' | 'display_name' | 'avatar_url' | 'bio' | 'status'>>) =>
+    apiFetch(`/users/${id}`, { method: 'PATCH', body: JSON.stringify(profile) }),
+
+  deleteUser: (id: string) =>
+    apiFetch(`/users/${id}`, { method: 'DELETE' }),
+
+  changeEmail: (id: string, email: string) =>
+    apiFetch(`/users/${id}/email`, { method: 'PUT', body: JSON.stringify({ email }) }),
+
+  verifyEmail: (id: string, token: string) =>
+    apiFetch(`/users/${id}/email/verify`, { method: 'POST', body: JSON.stringify({ token }) }),
+
+  resendVerification: (id: string) =>
+    apiFetch(`/users/${id}/email/verification`, { method: 'POST' }),
+
+  getUserServers: (id: string) =>
+    apiFetch(`/users/${id}/servers`),
+
+  reorderServers: (id: string, serverIds: string[]) =>
+    apiFetch(`/users/${id}/servers/order`, { method: 'PUT', body: JSON.stringify({ server_ids: serverIds }) }),
+
   // Friends
   addFriend: (userId: string, friendId: string) =>
     apiFetch(`/users/${userId}/friends`, {
@@ -41,6 +100,18 @@ export const api = {
   getServer: (id: string) =>
     apiFetch(`/servers/${id}`),
 
+  getServers: (ids: string[]) =>
+    getBatch('/servers', ids),
+
+  updateServer: (id: string, settings: Partial<Pick<Server, 'name' | 'description' | 'icon_url' | 'tags' | 'visibility'>>) =>
+    apiFetch(`/servers/${id}`, { method: 'PATCH', body: JSON.stringify(settings) }),
+
+  deleteServer: (id: string) =>
+    apiFetch(`/servers/${id}`, { method: 'DELETE' }),
+
+  discoverServers: (query = '', tag = '') =>
+    apiFetch(`/servers?query=${encodeURIComponent(query)}&tag=${encodeURIComponent(tag)}`),
+
   // Posts
   createPost: (serverId: string, authorId: string, title: string, body: string) =>
     apiFetch(`/servers/${serverId}/posts`, {
@@ -51,20 +122,28 @@ export const api = {
   getPost: (serverId: string, postId: string) =>
     apiFetch(`/servers/${serverId}/posts/${postId}`),
 
-  updatePost: (serverId: string, postId: string, title: string, body: string) =>
+  getPosts: (ids: string[]) =>
+    getBatch('/posts', ids),
+
+  // updatePost edits the given version of a post. It fails if someone else
+  // has edited the post since.
+  updatePost: (serverId: string, postId: string, version: number, title: string, body: string) =>
     apiFetch(`/servers/${serverId}/posts/${postId}`, {
       method: 'PUT',
-      body: JSON.stringify({ title, body }),
+      body: JSON.stringify({ title, body, version }),
     }),
 
+  getPostRevisions: (serverId: string, postId: string) =>
+    apiFetch(`/servers/${serverId}/posts/${postId}/revisions`),
+
   deletePost: (serverId: string, postId: string) =>
     apiFetch(`/servers/${serverId}/posts/${postId}`, { method: 'DELETE' }),
 
   getVote: (serverId: string, postId: string, authorId: string) =>
-    apiFetch(`/servers/${serverId}/posts/${postId}/vote`, {
-      method: 'GET',
-      body: JSON.stringify({ author: authorId }),
-    }),
+    apiFetch(`/servers/${serverId}/posts/${postId}/vote?author_id=${encodeURIComponent(authorId)}`),
+
+  getVotes: (serverId: string, postId: string) =>
+    apiFetch(`/servers/${serverId}/posts/${postId}/votes`),
 
   putVote: (serverId: string, postId: string, authorId: string, vote: number) =>
     apiFetch(`/servers/${serverId}/posts/${postId}/vote`, {
@@ -81,4 +160,8 @@ export const api = {
 
   getMessages: (serverId: string) =>
     apiFetch(`/servers/${serverId}/messages`),
+
+  // ackMessage marks the server read up to and including the message.
+  ackMessage: (serverId: string, messageId: string) =>
+    apiFetch(`/servers/${serverId}/messages/${messageId}/ack`, { method: 'POST' }),
 }
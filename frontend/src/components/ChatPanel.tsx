@@ -1,5 +1,5 @@
 import { useState, useEffect, useRef } from 'react'
-import { User, Message } from '../types'
+import { User, PublicUser, Message } from '../types'
 import { api } from '../api/client'
 
 interface Props {
@@ -9,7 +9,7 @@ interface Props {
 
 export default function ChatPanel({ serverId, currentUser }: Props) {
   const [messages, setMessages] = useState<Message[]>([])
-  const [userCache, setUserCache] = useState<Record<string, User>>({})
+  const [userCache, setUserCache] = useState<Record<string, PublicUser>>({})
   const [input, setInput] = useState('')
   const [sending, setSending] = useState(false)
   const messagesEndRef = useRef<HTMLDivElement>(null)
@@ -27,15 +27,17 @@ export default function ChatPanel({ serverId, currentUser }: Props) {
       const msgs = await api.getMessages(serverId!).catch(() => [] as Message[])
       if (cancelled) return
       setMessages(msgs ?? [])
+      const latest = msgs?.[msgs.length - 1]
+      if (latest) api.ackMessage(serverId!, latest.message_id).catch(() => {})
 
       const authorIds = new Set<string>((msgs ?? []).map((m: Message) => m.author_id))
       const entries = await Promise.all(
         [...authorIds].map(id =>
-          api.getUser(id).then((u: User) => [id, u] as [string, User]).catch(() => null)
+          api.getUser(id).then((u: PublicUser) => [id, u] as [string, PublicUser]).catch(() => null)
         )
       )
       if (cancelled) return
-      const cache: Record<string, User> = {}
+      const cache: Record<string, PublicUser> = {}
       entries.forEach(e => { if (e) cache[e[0]] = e[1] })
       setUserCache(cache)
     }
@@ -51,7 +53,7 @@ export default function ChatPanel({ serverId, currentUser }: Props) {
   const ensureUser = async (id: string) => {
     if (userCache[id]) return
     try {
-      const u: User = await api.getUser(id)
+      const u: PublicUser = await api.getUser(id)
       setUserCache(prev => ({ ...prev, [id]: u }))
     } catch { /* ignore */ }
   }
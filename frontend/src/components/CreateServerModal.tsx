@@ -1,14 +1,16 @@
 import { useState } from 'react'
 import { User, Server } from '../types'
 import { api } from '../api/client'
+import VerifyEmailForm from './VerifyEmailForm'
 
 interface Props {
   currentUser: User
   onCreated: (server: Server) => void
+  onUserUpdated: (user: User) => void
   onClose: () => void
 }
 
-export default function CreateServerModal({ currentUser, onCreated, onClose }: Props) {
+export default function CreateServerModal({ currentUser, onCreated, onUserUpdated, onClose }: Props) {
   const [name, setName] = useState('')
   const [error, setError] = useState('')
   const [loading, setLoading] = useState(false)
@@ -35,42 +37,48 @@ export default function CreateServerModal({ currentUser, onCreated, onClose }: P
     >
       <div className="modal-card max-w-md">
         <h2 className="text-xl font-bold text-white mb-1">Create a Server</h2>
-        <p className="text-[#949ba4] text-sm mb-5">
-          Your server is where you and your friends post and hang out. Give it a name!
-        </p>
-        <form onSubmit={handleSubmit} className="space-y-4">
-          <div>
-            <label className="field-label">
-              Server Name
-            </label>
-            <input
-              type="text"
-              value={name}
-              onChange={e => setName(e.target.value)}
-              className="input-field"
-              placeholder="My Awesome Server"
-              required
-              autoFocus
-            />
-          </div>
-          {error && <p className="field-error">{error}</p>}
-          <div className="flex gap-2 justify-end pt-1">
-            <button
-              type="button"
-              onClick={onClose}
-              className="px-4 py-2 text-sm text-[#949ba4] hover:text-white transition-colors"
-            >
-              Cancel
-            </button>
-            <button
-              type="submit"
-              disabled={loading || !name.trim()}
-              className="px-4 py-2 text-sm bg-[#5865f2] text-white rounded-md hover:bg-[#4752c4] transition-colors disabled:opacity-50"
-            >
-              {loading ? 'Creating...' : 'Create Server'}
-            </button>
-          </div>
-        </form>
+        {!currentUser.email_verified ? (
+          <VerifyEmailForm currentUser={currentUser} onUserUpdated={onUserUpdated} />
+        ) : (
+          <>
+            <p className="text-[#949ba4] text-sm mb-5">
+              Your server is where you and your friends post and hang out. Give it a name!
+            </p>
+            <form onSubmit={handleSubmit} className="space-y-4">
+              <div>
+                <label className="field-label">
+                  Server Name
+                </label>
+                <input
+                  type="text"
+                  value={name}
+                  onChange={e => setName(e.target.value)}
+                  className="input-field"
+                  placeholder="My Awesome Server"
+                  required
+                  autoFocus
+                />
+              </div>
+              {error && <p className="field-error">{error}</p>}
+              <div className="flex gap-2 justify-end pt-1">
+                <button
+                  type="button"
+                  onClick={onClose}
+                  className="px-4 py-2 text-sm text-[#949ba4] hover:text-white transition-colors"
+                >
+                  Cancel
+                </button>
+                <button
+                  type="submit"
+                  disabled={loading || !name.trim()}
+                  className="px-4 py-2 text-sm bg-[#5865f2] text-white rounded-md hover:bg-[#4752c4] transition-colors disabled:opacity-50"
+                >
+                  {loading ? 'Creating...' : 'Create Server'}
+                </button>
+              </div>
+            </form>
+          </>
+        )}
       </div>
     </div>
   )
@@ -33,7 +33,7 @@ export default function LoginModal({ onLogin }: Props) {
     setError('')
     setLoading(true)
     try {
-      const user = await api.getUser(userId.trim())
+      const user = await api.getMe(userId.trim())
       onLogin(user)
     } catch {
       setError('User not found. Check the ID and try again.')
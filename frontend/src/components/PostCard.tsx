@@ -1,12 +1,12 @@
 import { useState, useEffect } from 'react'
-import { User, Post } from '../types'
+import { User, PublicUser, Post } from '../types'
 import { api } from '../api/client'
 import Avatar from './Avatar'
 
 interface Props {
   post: Post
   currentUser: User
-  author?: User
+  author?: PublicUser
   onUpdated: (post: Post) => void
   onDeleted: (postId: string) => void
 }
@@ -16,6 +16,7 @@ export default function PostCard({ post, currentUser, author, onUpdated, onDelet
   const [editTitle, setEditTitle] = useState(post.title)
   const [editBody, setEditBody] = useState(post.body)
   const [saving, setSaving] = useState(false)
+  const [saveError, setSaveError] = useState('')
   const [deleting, setDeleting] = useState(false)
   const [myVote, setMyVote] = useState<number>(0)
   const [voteLoading, setVoteLoading] = useState(false)
@@ -49,10 +50,13 @@ export default function PostCard({ post, currentUser, author, onUpdated, onDelet
   const handleSave = async () => {
     if (!editTitle.trim()) return
     setSaving(true)
+    setSaveError('')
     try {
-      const updated = await api.updatePost(post.server_id, post.post_id, editTitle.trim(), editBody.trim())
+      const updated = await api.updatePost(post.server_id, post.post_id, post.version, editTitle.trim(), editBody.trim())
       onUpdated(updated)
       setEditing(false)
+    } catch (err: unknown) {
+      setSaveError(err instanceof Error ? err.message : 'Failed to save post')
     } finally {
       setSaving(false)
     }
@@ -98,9 +102,10 @@ export default function PostCard({ post, currentUser, author, onUpdated, onDelet
           rows={4}
           placeholder="Body (optional)"
         />
+        {saveError && <p className="field-error">{saveError}</p>}
         <div className="flex gap-2 justify-end">
           <button
-            onClick={() => { setEditing(false); setEditTitle(post.title); setEditBody(post.body) }}
+            onClick={() => { setEditing(false); setEditTitle(post.title); setEditBody(post.body); setSaveError('') }}
             className="px-3 py-1.5 text-sm text-[#949ba4] hover:text-white transition-colors"
           >
             Cancel
@@ -1,5 +1,5 @@
 import { useState, useEffect, useRef } from 'react'
-import { User, Server, Post } from '../types'
+import { User, PublicUser, Server, Post } from '../types'
 import { api } from '../api/client'
 import PostCard from './PostCard'
 import CreatePostModal from './CreatePostModal'
@@ -13,7 +13,7 @@ interface Props {
 export default function ServerView({ serverId, currentUser }: Props) {
   const [server, setServer] = useState<Server | null>(null)
   const [posts, setPosts] = useState<Post[]>([])
-  const [userCache, setUserCache] = useState<Record<string, User>>({})
+  const [userCache, setUserCache] = useState<Record<string, PublicUser>>({})
   const [loading, setLoading] = useState(true)
   const [showCreatePost, setShowCreatePost] = useState(false)
   const prevServerRef = useRef<string>('')
@@ -32,26 +32,18 @@ export default function ServerView({ serverId, currentUser }: Props) {
         if (cancelled) return
         setServer(s)
 
-        const postResults = await Promise.all(
-          s.post_ids.map((id: string) => api.getPost(serverId, id).catch(() => null))
-        )
+        const validPosts: Post[] = await api.getPosts(s.post_ids)
         if (cancelled) return
-
-        const validPosts = postResults.filter(Boolean) as Post[]
         setPosts(validPosts)
 
         const authorIds = new Set<string>([
           ...s.member_ids,
           ...validPosts.map(p => p.author_id),
         ])
-        const userEntries = await Promise.all(
-          [...authorIds].map(id =>
-            api.getUser(id).then((u: User) => [id, u] as [string, User]).catch(() => null)
-          )
-        )
+        const users: PublicUser[] = await api.getUsers([...authorIds])
         if (cancelled) return
-        const cache: Record<string, User> = {}
-        userEntries.forEach(entry => { if (entry) cache[entry[0]] = entry[1] })
+        const cache: Record<string, PublicUser> = {}
+        users.forEach(u => { cache[u.user_id] = u })
         setUserCache(cache)
       } finally {
         if (!cancelled) setLoading(false)
@@ -68,7 +60,7 @@ export default function ServerView({ serverId, currentUser }: Props) {
   const ensureUser = async (id: string) => {
     if (userCache[id]) return
     try {
-      const u: User = await api.getUser(id)
+      const u: PublicUser = await api.getUser(id)
       setUserCache(prev => ({ ...prev, [id]: u }))
     } catch { /* ignore */ }
   }
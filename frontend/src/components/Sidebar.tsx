@@ -1,5 +1,5 @@
 import { useState, useEffect } from 'react'
-import { User, Server } from '../types'
+import { User, PublicUser, Server } from '../types'
 import { api } from '../api/client'
 import Avatar from './Avatar'
 
@@ -20,7 +20,7 @@ export default function Sidebar({
   onCreateServer,
   onJoinServer,
 }: Props) {
-  const [friends, setFriends] = useState<User[]>([])
+  const [friends, setFriends] = useState<PublicUser[]>([])
   const [servers, setServers] = useState<Server[]>([])
   const [showAddFriend, setShowAddFriend] = useState(false)
   const [friendInput, setFriendInput] = useState('')
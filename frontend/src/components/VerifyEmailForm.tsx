@@ -0,0 +1,153 @@
+import { useState } from 'react'
+import { User } from '../types'
+import { api } from '../api/client'
+
+interface Props {
+  currentUser: User
+  onUserUpdated: (user: User) => void
+}
+
+// VerifyEmailForm asks for the token from the verification email, which an
+// account needs before it can create servers. Accounts without an address,
+// or with a mistyped one, can set a new one here.
+export default function VerifyEmailForm({ currentUser, onUserUpdated }: Props) {
+  const [token, setToken] = useState('')
+  const [email, setEmail] = useState(currentUser.email)
+  const [editingEmail, setEditingEmail] = useState(!currentUser.email)
+  const [error, setError] = useState('')
+  const [notice, setNotice] = useState('')
+  const [loading, setLoading] = useState(false)
+
+  const handleVerify = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (!token.trim()) return
+    setError('')
+    setNotice('')
+    setLoading(true)
+    try {
+      await api.verifyEmail(currentUser.user_id, token.trim())
+      onUserUpdated(await api.getMe(currentUser.user_id))
+    } catch (err: unknown) {
+      setError(err instanceof Error ? err.message : 'Failed to verify email')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  const handleChangeEmail = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (!email.trim()) return
+    setError('')
+    setNotice('')
+    setLoading(true)
+    try {
+      const user: User = await api.changeEmail(currentUser.user_id, email.trim())
+      onUserUpdated(user)
+      setEditingEmail(false)
+      setNotice(`A verification email was sent to ${user.email}.`)
+    } catch (err: unknown) {
+      setError(err instanceof Error ? err.message : 'Failed to change email')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  const handleResend = async () => {
+    setError('')
+    setNotice('')
+    try {
+      await api.resendVerification(currentUser.user_id)
+      setNotice(`A new verification email was sent to ${currentUser.email}.`)
+    } catch (err: unknown) {
+      setError(err instanceof Error ? err.message : 'Failed to resend verification email')
+    }
+  }
+
+  if (editingEmail) {
+    return (
+      <form onSubmit={handleChangeEmail} className="space-y-4">
+        <p className="text-[#949ba4] text-sm">
+          Verify your email address to create servers. Enter the address to send a verification token to.
+        </p>
+        <div>
+          <label className="field-label">
+            Email
+          </label>
+          <input
+            type="email"
+            value={email}
+            onChange={e => setEmail(e.target.value)}
+            className="input-field"
+            required
+            autoFocus
+          />
+        </div>
+        {error && <p className="field-error">{error}</p>}
+        <div className="flex gap-2 justify-end pt-1">
+          {currentUser.email && (
+            <button
+              type="button"
+              onClick={() => setEditingEmail(false)}
+              className="px-4 py-2 text-sm text-[#949ba4] hover:text-white transition-colors"
+            >
+              Cancel
+            </button>
+          )}
+          <button
+            type="submit"
+            disabled={loading || !email.trim()}
+            className="px-4 py-2 text-sm bg-[#5865f2] text-white rounded-md hover:bg-[#4752c4] transition-colors disabled:opacity-50"
+          >
+            {loading ? 'Sending...' : 'Send Verification'}
+          </button>
+        </div>
+      </form>
+    )
+  }
+
+  return (
+    <form onSubmit={handleVerify} className="space-y-4">
+      <p className="text-[#949ba4] text-sm">
+        Verify your email address to create servers. Enter the token sent to {currentUser.email}.
+      </p>
+      <div>
+        <label className="field-label">
+          Verification Token
+        </label>
+        <input
+          type="text"
+          value={token}
+          onChange={e => setToken(e.target.value)}
+          className="input-field"
+          required
+          autoFocus
+        />
+      </div>
+      {error && <p className="field-error">{error}</p>}
+      {notice && <p className="text-[#949ba4] text-sm">{notice}</p>}
+      <div className="flex gap-2 justify-end pt-1">
+        <button
+          type="button"
+          onClick={() => setEditingEmail(true)}
+          className="px-4 py-2 text-sm text-[#949ba4] hover:text-white transition-colors"
+        >
+          Change Email
+        </button>
+        <button
+          type="button"
+          onClick={handleResend}
+          className="px-4 py-2 text-sm text-[#949ba4] hover:text-white transition-colors"
+        >
+          Resend Email
+        </button>
+        <button
+          type="submit"
+          disabled={loading || !token.trim()}
+          className="px-4 py-2 text-sm bg-[#5865f2] text-white rounded-md hover:bg-[#4752c4] transition-colors disabled:opacity-50"
+        >
+          {loading ? 'Verifying...' : 'Verify'}
+        </button>
+      </div>
+    </form>
+  )
+}
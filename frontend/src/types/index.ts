@@ -1,20 +1,58 @@
-export interface User {
+export interface PublicUser {
   user_id: string
   username: string
+  display_name: string
+  avatar_url: string
+  bio: string
+  status: '' | 'online' | 'idle' | 'dnd' | 'invisible'
+  created_at: string
+}
+
+// User is the private view of an account, returned only to its owner.
+export interface User extends PublicUser {
   email: string
+  email_verified: boolean
   server_ids: string[]
-  created_at: string
 }
 
 export interface Server {
   server_id: string
   name: string
+  description: string
+  icon_url: string
+  tags: string[]
+  visibility: 'public' | 'private'
   owner_id: string
   member_ids: string[]
   post_ids: string[]
+  slow_mode_seconds: number
   created_at: string
 }
 
+export interface ServerSummary {
+  server_id: string
+  name: string
+  description: string
+  icon_url: string
+  tags: string[]
+  member_count: number
+  last_activity_at: string
+}
+
+export interface UserServer extends ServerSummary {
+  position: number
+  unread_count: number
+  mention_count: number
+}
+
+export interface ReadState {
+  server_id: string
+  last_read_message_id: string
+  last_read_at: string
+  unread_count: number
+  mention_count: number
+}
+
 export interface Post {
   post_id: string
   server_id: string
@@ -22,10 +60,31 @@ export interface Post {
   title: string
   body: string
   votes: number
+  upvotes: number
+  downvotes: number
+  version: number
   created_at: string
   updated_at: string
 }
 
+export interface PostRevision {
+  post_id: string
+  version: number
+  title: string
+  body: string
+  edited_by: string
+  edited_at: string
+}
+
+export interface VoteSummary {
+  post_id: string
+  score: number
+  upvotes: number
+  downvotes: number
+  upvoters?: string[]
+  downvoters?: string[]
+}
+
 export interface Message {
   message_id: string
   server_id: string